@@ -0,0 +1,263 @@
+/*
+Allow and deny rules checked before requests are validated: CIDR ranges, user ids, API keys and header values
+*/
+
+package limitter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const ACCESS_RESULT_NONE int = 0
+const ACCESS_RESULT_ALLOW int = 1
+const ACCESS_RESULT_DENY int = 2
+
+const DefaultAPIKeyHeader string = "X-API-Key"
+
+var ErrorRequestDenied = fmt.Errorf("request is denied")
+
+// AccessRules are allow and deny rules of an AccessList, they can be loaded from JSON
+type AccessRules struct {
+	//CIDR ranges or single IPs of clients. Gin limitters match c.ClientIP(), which trusts X-Forwarded-For of any proxy by default:
+	//set trusted proxies of the engine (gin.Engine.SetTrustedProxies) or a client can spoof its IP into an allowed range and skip all limits
+	AllowCIDRs []string `json:"allowCIDRs"`
+	DenyCIDRs  []string `json:"denyCIDRs"`
+
+	AllowUserIds []string `json:"allowUserIds"`
+	DenyUserIds  []string `json:"denyUserIds"`
+
+	//API keys are read from header APIKeyHeader
+	AllowAPIKeys []string `json:"allowAPIKeys"`
+	DenyAPIKeys  []string `json:"denyAPIKeys"`
+
+	//Header name to values
+	AllowHeaders map[string][]string `json:"allowHeaders"`
+	DenyHeaders  map[string][]string `json:"denyHeaders"`
+
+	//Header of API keys, empty means DefaultAPIKeyHeader
+	APIKeyHeader string `json:"apiKeyHeader"`
+}
+
+// cidrNode is a node of a binary radix tree of IP bits, result is set on nodes ending a CIDR range
+type cidrNode struct {
+	children [2]*cidrNode
+	result   int
+}
+
+// cidrTree finds the most specific CIDR range containing an IP
+type cidrTree struct {
+	ipv4 *cidrNode
+	ipv6 *cidrNode
+}
+
+func newCIDRTree() *cidrTree {
+	return &cidrTree{ipv4: &cidrNode{}, ipv6: &cidrNode{}}
+}
+
+// parseCIDR parses a CIDR range or a single IP
+func parseCIDR(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %v", value)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, ipNet, errParse := net.ParseCIDR(value)
+	return ipNet, errParse
+}
+
+func (tree *cidrTree) root(ip net.IP) (*cidrNode, net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return tree.ipv4, ip4
+	}
+	return tree.ipv6, ip.To16()
+}
+
+// insert sets result of a range, a deny result is not replaced by allow on the same range.
+// IPv4-mapped IPv6 ranges, e.g. ::ffff:10.0.0.0/104, are IPv4 ranges of prefix length less by 96
+func (tree *cidrTree) insert(ipNet *net.IPNet, result int) {
+	node, ip := tree.root(ipNet.IP)
+	prefixLength, bits := ipNet.Mask.Size()
+	if bits == 8*net.IPv6len && len(ip) == net.IPv4len {
+		prefixLength -= 8 * (net.IPv6len - net.IPv4len)
+	}
+	if prefixLength < 0 {
+		prefixLength = 0
+	}
+	for i := 0; i < prefixLength; i++ {
+		bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &cidrNode{}
+		}
+		node = node.children[bit]
+	}
+	if node.result != ACCESS_RESULT_DENY {
+		node.result = result
+	}
+}
+
+// lookup returns result of the most specific range containing ip, ACCESS_RESULT_NONE if there is none
+func (tree *cidrTree) lookup(ip net.IP) int {
+	node, ip := tree.root(ip)
+	if ip == nil {
+		return ACCESS_RESULT_NONE
+	}
+	result := node.result
+	for i := 0; i < len(ip)*8 && node != nil; i++ {
+		node = node.children[(ip[i/8]>>(7-uint(i%8)))&1]
+		if node != nil && node.result != ACCESS_RESULT_NONE {
+			result = node.result
+		}
+	}
+	return result
+}
+
+// accessMatcher is compiled rules, it is immutable once built
+type accessMatcher struct {
+	cidrs        *cidrTree
+	userIds      map[string]int
+	apiKeys      map[string]int
+	headers      map[string]map[string]int
+	apiKeyHeader string
+}
+
+func addAccessValues(values map[string]int, keys []string, result int) {
+	for _, key := range keys {
+		if values[key] != ACCESS_RESULT_DENY {
+			values[key] = result
+		}
+	}
+}
+
+func createAccessMatcher(rules AccessRules) (*accessMatcher, error) {
+	matcher := &accessMatcher{
+		cidrs:        newCIDRTree(),
+		userIds:      map[string]int{},
+		apiKeys:      map[string]int{},
+		headers:      map[string]map[string]int{},
+		apiKeyHeader: rules.APIKeyHeader,
+	}
+	if len(matcher.apiKeyHeader) == 0 {
+		matcher.apiKeyHeader = DefaultAPIKeyHeader
+	}
+
+	for result, cidrs := range map[int][]string{ACCESS_RESULT_ALLOW: rules.AllowCIDRs, ACCESS_RESULT_DENY: rules.DenyCIDRs} {
+		for _, cidr := range cidrs {
+			ipNet, errParse := parseCIDR(cidr)
+			if errParse != nil {
+				return nil, errParse
+			}
+			matcher.cidrs.insert(ipNet, result)
+		}
+	}
+	addAccessValues(matcher.userIds, rules.AllowUserIds, ACCESS_RESULT_ALLOW)
+	addAccessValues(matcher.userIds, rules.DenyUserIds, ACCESS_RESULT_DENY)
+	addAccessValues(matcher.apiKeys, rules.AllowAPIKeys, ACCESS_RESULT_ALLOW)
+	addAccessValues(matcher.apiKeys, rules.DenyAPIKeys, ACCESS_RESULT_DENY)
+	for result, headers := range map[int]map[string][]string{ACCESS_RESULT_ALLOW: rules.AllowHeaders, ACCESS_RESULT_DENY: rules.DenyHeaders} {
+		for name, values := range headers {
+			name = http.CanonicalHeaderKey(name)
+			if matcher.headers[name] == nil {
+				matcher.headers[name] = map[string]int{}
+			}
+			addAccessValues(matcher.headers[name], values, result)
+		}
+	}
+	return matcher, nil
+}
+
+/*
+check returns result of all rules matching a request: deny if any rule denies, allow if any rule allows.
+Of CIDR ranges, only the most specific one containing client IP is considered
+*/
+func (matcher *accessMatcher) check(clientIP string, userId string, header http.Header) int {
+	results := make([]int, 0, 4)
+	if ip := net.ParseIP(clientIP); ip != nil {
+		results = append(results, matcher.cidrs.lookup(ip))
+	}
+	if len(userId) > 0 {
+		results = append(results, matcher.userIds[userId])
+	}
+	if apiKey := header.Get(matcher.apiKeyHeader); len(apiKey) > 0 {
+		results = append(results, matcher.apiKeys[apiKey])
+	}
+	for name, values := range matcher.headers {
+		for _, value := range header.Values(name) {
+			results = append(results, values[value])
+		}
+	}
+
+	result := ACCESS_RESULT_NONE
+	for _, matched := range results {
+		if matched == ACCESS_RESULT_DENY {
+			return ACCESS_RESULT_DENY
+		}
+		if matched == ACCESS_RESULT_ALLOW {
+			result = ACCESS_RESULT_ALLOW
+		}
+	}
+	return result
+}
+
+// AccessList checks requests by allow and deny rules, rules can be reloaded while requests are checked
+type AccessList struct {
+	mutex   sync.RWMutex
+	matcher *accessMatcher
+}
+
+func NewAccessList(rules AccessRules) (*AccessList, error) {
+	matcher, errCreate := createAccessMatcher(rules)
+	if errCreate != nil {
+		return nil, errCreate
+	}
+	return &AccessList{matcher: matcher}, nil
+}
+
+// Reload replaces rules, current rules are kept if new rules are invalid
+func (accessList *AccessList) Reload(rules AccessRules) error {
+	matcher, errCreate := createAccessMatcher(rules)
+	if errCreate != nil {
+		log.Errorf("AccessList: ReloadFailed, error=%v", errCreate)
+		return errCreate
+	}
+	accessList.mutex.Lock()
+	accessList.matcher = matcher
+	accessList.mutex.Unlock()
+	log.Infof("AccessList: Reloaded, allowCIDRs=%v, denyCIDRs=%v, allowUserIds=%v, denyUserIds=%v",
+		len(rules.AllowCIDRs), len(rules.DenyCIDRs), len(rules.AllowUserIds), len(rules.DenyUserIds))
+	return nil
+}
+
+/*
+Check returns ACCESS_RESULT_DENY if any rule denies request, ACCESS_RESULT_ALLOW if any rule allows it,
+ACCESS_RESULT_NONE otherwise
+*/
+func (accessList *AccessList) Check(clientIP string, userId string, header http.Header) int {
+	accessList.mutex.RLock()
+	matcher := accessList.matcher
+	accessList.mutex.RUnlock()
+	return matcher.check(clientIP, userId, header)
+}
+
+// LoadAccessRulesFile reads rules from a JSON file
+func LoadAccessRulesFile(path string) (AccessRules, error) {
+	rules := AccessRules{}
+	data, errRead := os.ReadFile(path)
+	if errRead != nil {
+		return rules, errRead
+	}
+	errParse := json.Unmarshal(data, &rules)
+	return rules, errParse
+}
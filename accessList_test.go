@@ -0,0 +1,116 @@
+package limitter
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var accessTestRules AccessRules = AccessRules{
+	AllowCIDRs:   []string{"10.0.0.0/8", "10.1.2.0/24", "2001:db8::/32"},
+	DenyCIDRs:    []string{"10.1.0.0/16", "192.168.1.7"},
+	AllowUserIds: []string{"staff"},
+	DenyUserIds:  []string{"abuser"},
+	AllowAPIKeys: []string{"partner-key"},
+	AllowHeaders: map[string][]string{"user-agent": {"health-checker"}},
+	DenyHeaders:  map[string][]string{"X-Client": {"bad-bot"}},
+}
+
+// go test -timeout 30s -run ^TestAccessList_Rules_Matched$ github.com/zeroboo/gin-request-limitter -v
+func TestAccessList_Rules_Matched(t *testing.T) {
+	accessList, errCreate := NewAccessList(accessTestRules)
+	assert.Nil(t, errCreate, "Create success")
+
+	assert.Equal(t, ACCESS_RESULT_ALLOW, accessList.Check("10.2.3.4", "", http.Header{}), "Allowed range")
+	assert.Equal(t, ACCESS_RESULT_DENY, accessList.Check("10.1.3.4", "", http.Header{}), "Most specific range denies")
+	assert.Equal(t, ACCESS_RESULT_ALLOW, accessList.Check("10.1.2.3", "", http.Header{}), "Most specific range allows inside denied range")
+	assert.Equal(t, ACCESS_RESULT_DENY, accessList.Check("192.168.1.7", "", http.Header{}), "Denied IP")
+	assert.Equal(t, ACCESS_RESULT_NONE, accessList.Check("192.168.1.8", "", http.Header{}), "IP without rule")
+	assert.Equal(t, ACCESS_RESULT_ALLOW, accessList.Check("2001:db8::1", "", http.Header{}), "Allowed IPv6 range")
+	assert.Equal(t, ACCESS_RESULT_NONE, accessList.Check("2001:db9::1", "", http.Header{}), "IPv6 without rule")
+
+	assert.Equal(t, ACCESS_RESULT_ALLOW, accessList.Check("1.1.1.1", "staff", http.Header{}), "Allowed user")
+	assert.Equal(t, ACCESS_RESULT_DENY, accessList.Check("10.2.3.4", "abuser", http.Header{}), "Deny wins over allow")
+	assert.Equal(t, ACCESS_RESULT_ALLOW, accessList.Check("1.1.1.1", "", http.Header{"X-Api-Key": {"partner-key"}}), "Allowed API key")
+	assert.Equal(t, ACCESS_RESULT_ALLOW, accessList.Check("1.1.1.1", "", http.Header{"User-Agent": {"health-checker"}}), "Allowed header")
+	assert.Equal(t, ACCESS_RESULT_DENY, accessList.Check("1.1.1.1", "staff", http.Header{"X-Client": {"bad-bot"}}), "Denied header")
+}
+
+// go test -timeout 30s -run ^TestAccessList_Reload_RulesReplaced$ github.com/zeroboo/gin-request-limitter -v
+func TestAccessList_Reload_RulesReplaced(t *testing.T) {
+	accessList, _ := NewAccessList(accessTestRules)
+	path := filepath.Join(t.TempDir(), "rules.json")
+	os.WriteFile(path, []byte(`{"denyCIDRs":["10.0.0.0/8"]}`), 0600)
+
+	rules, errLoad := LoadAccessRulesFile(path)
+	assert.Nil(t, errLoad, "Load success")
+	assert.Nil(t, accessList.Reload(rules), "Reload success")
+	assert.Equal(t, ACCESS_RESULT_DENY, accessList.Check("10.2.3.4", "staff", http.Header{}), "New rules used")
+
+	assert.NotNil(t, accessList.Reload(AccessRules{AllowCIDRs: []string{"10.0.0.0/99"}}), "Invalid rules")
+	assert.Equal(t, ACCESS_RESULT_DENY, accessList.Check("10.2.3.4", "", http.Header{}), "Rules kept on invalid reload")
+}
+
+// go test -timeout 30s -run ^TestAccessList_StoreBackedLimitter_CheckedBeforeStore$ github.com/zeroboo/gin-request-limitter -v
+func TestAccessList_StoreBackedLimitter_CheckedBeforeStore(t *testing.T) {
+	accessList, _ := NewAccessList(accessTestRules)
+	store := &mapTrackerStore{}
+	config := limitterTestConfigLongWindow
+	config.AccessList = accessList
+	handler := CreateStoreBackedLimitter(store, GetUserIdFromContextByField(FieldNameUserId), &config, false)
+
+	for i := 0; i < 3; i++ {
+		recorder := RecordRequest(http.MethodGet, "/health", map[string][]string{"User-Agent": {"health-checker"}}, map[string][]string{},
+			CreateFakeAuthenticationHandler(FieldNameUserId, RandomString(16)), handler, HandleHealth)
+		assert.Equal(t, http.StatusOK, recorder.Code, "Allowed request bypasses limits")
+	}
+	recorder := RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{},
+		CreateFakeAuthenticationHandler(FieldNameUserId, "abuser"), handler, HandleHealth)
+	assert.Equal(t, http.StatusForbidden, recorder.Code, "Denied request rejected")
+	assert.Equal(t, 0, store.Calls, "Store not called")
+}
+
+// go test -run ^$ -bench ^BenchmarkAccessList_LargeCIDRList$ github.com/zeroboo/gin-request-limitter
+func BenchmarkAccessList_LargeCIDRList(b *testing.B) {
+	rules := AccessRules{}
+	for i := 0; i < 100000; i++ {
+		rules.DenyCIDRs = append(rules.DenyCIDRs, fmt.Sprintf("%v.%v.%v.0/24", 1+i/65536, (i/256)%256, i%256))
+	}
+	accessList, _ := NewAccessList(rules)
+	header := http.Header{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		accessList.Check("1.200.17.9", "user", header)
+	}
+}
+
+// go test -timeout 30s -run ^TestAccessList_IPv6Ranges_Matched$ github.com/zeroboo/gin-request-limitter -v
+func TestAccessList_IPv6Ranges_Matched(t *testing.T) {
+	testCases := []struct {
+		name   string
+		cidr   string
+		ip     string
+		result int
+	}{
+		{"MappedRange", "::ffff:10.0.0.0/104", "10.1.2.3", ACCESS_RESULT_DENY},
+		{"MappedRangeOther", "::ffff:10.0.0.0/104", "11.1.2.3", ACCESS_RESULT_NONE},
+		{"MappedRangeMappedIP", "::ffff:10.0.0.0/104", "::ffff:10.1.2.3", ACCESS_RESULT_DENY},
+		{"MappedSingleIP", "::ffff:192.168.1.7/128", "192.168.1.7", ACCESS_RESULT_DENY},
+		{"MappedAll", "::ffff:0.0.0.0/96", "8.8.8.8", ACCESS_RESULT_DENY},
+		{"PlainRange", "2001:db8::/32", "2001:db8::1", ACCESS_RESULT_DENY},
+		{"PlainRangeOther", "2001:db8::/32", "2001:db9::1", ACCESS_RESULT_NONE},
+		{"PlainRangeIPv4", "2001:db8::/32", "10.1.2.3", ACCESS_RESULT_NONE},
+		{"PlainSingleIP", "2001:db8::1", "2001:db8::1", ACCESS_RESULT_DENY},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			accessList, errCreate := NewAccessList(AccessRules{DenyCIDRs: []string{testCase.cidr}})
+			assert.Nil(t, errCreate, "Create success")
+			assert.Equal(t, testCase.result, accessList.Check(testCase.ip, "", http.Header{}), "Result of ip")
+		})
+	}
+}
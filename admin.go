@@ -0,0 +1,290 @@
+/*
+Admin HTTP API to inspect and reset trackers and to override policies of users
+*/
+
+package limitter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrorTrackerNotFound = fmt.Errorf("tracker not found")
+var ErrorAdminNotSupported = fmt.Errorf("store does not support admin operations")
+
+// TrackerAdmin inspects and resets trackers of a store
+type TrackerAdmin interface {
+	// GetTracker returns tracker of userId and url, ErrorTrackerNotFound if there is none
+	GetTracker(ctx context.Context, userId string, url string) (*RequestTracker, error)
+
+	// GetUserTrackers returns all trackers of userId
+	GetUserTrackers(ctx context.Context, userId string) ([]*RequestTracker, error)
+
+	// ResetTracker deletes tracker of userId and url
+	ResetTracker(ctx context.Context, userId string, url string) error
+
+	// ResetUserTrackers deletes all trackers of userId and returns number of deleted trackers
+	ResetUserTrackers(ctx context.Context, userId string) (int, error)
+}
+
+// TrackerScanner iterates all trackers of a store
+type TrackerScanner interface {
+	ForEachTracker(ctx context.Context, fn func(tracker *RequestTracker) error) error
+}
+
+type policyOverride struct {
+	config *LimitterConfig
+	until  int64
+}
+
+// PolicyOverrides keeps temporary policies of users in memory of current instance, they are not shared with other instances
+type PolicyOverrides struct {
+	mutex     sync.RWMutex
+	overrides map[string]policyOverride
+
+	//Clock tells current time to admin routes, nil means SystemClock
+	Clock Clock
+
+	//Base is policy that overrides set by admin routes are merged onto: fields omitted by a request keep values of Base.
+	//Nil means requests must be full policies
+	Base *LimitterConfig
+}
+
+func NewPolicyOverrides() *PolicyOverrides {
+	return &PolicyOverrides{
+		overrides: map[string]policyOverride{},
+	}
+}
+
+// Set overrides policy of userId by config until given time
+func (overrides *PolicyOverrides) Set(userId string, config *LimitterConfig, until time.Time) {
+	overrides.mutex.Lock()
+	defer overrides.mutex.Unlock()
+	overrides.overrides[userId] = policyOverride{config: config, until: until.UnixMilli()}
+}
+
+// now returns current time of clock of overrides, overrides can be nil
+func (overrides *PolicyOverrides) now() time.Time {
+	if overrides == nil {
+		return SystemClock.Now()
+	}
+	return getClock(overrides.Clock).Now()
+}
+
+// Remove removes override of userId
+func (overrides *PolicyOverrides) Remove(userId string) {
+	overrides.mutex.Lock()
+	defer overrides.mutex.Unlock()
+	delete(overrides.overrides, userId)
+}
+
+// Get returns policy of userId at currentTime, nil if there is no override or it expired
+func (overrides *PolicyOverrides) Get(userId string, currentTime time.Time) *LimitterConfig {
+	overrides.mutex.RLock()
+	override, found := overrides.overrides[userId]
+	overrides.mutex.RUnlock()
+	if !found {
+		return nil
+	}
+	if currentTime.UnixMilli() >= override.until {
+		overrides.mutex.Lock()
+		if current, found := overrides.overrides[userId]; found && current.until == override.until {
+			delete(overrides.overrides, userId)
+		}
+		overrides.mutex.Unlock()
+		return nil
+	}
+	return override.config
+}
+
+// PolicyOverrideRequest is body of a policy override: the policy and how long it lasts
+type PolicyOverrideRequest struct {
+	LimitterConfig
+	DurationSec int64 `json:"durationSec"`
+}
+
+// Fields a policy override request must have if PolicyOverrides.Base is nil
+var PolicyOverrideRequiredFields []string = []string{"minRequestInterval", "windowSize", "maxRequestPerWindow", "expSec"}
+
+var ErrorPolicyOverrideIncomplete = fmt.Errorf("policy override misses fields and there is no base policy")
+
+// ParsePolicyOverrideRequest decodes body of a policy override onto a copy of base policy, so omitted fields keep values of base.
+// Without base, body must have all PolicyOverrideRequiredFields
+func ParsePolicyOverrideRequest(body []byte, base *LimitterConfig) (*PolicyOverrideRequest, error) {
+	request := &PolicyOverrideRequest{}
+	if base != nil {
+		request.LimitterConfig = *base
+		request.Overrides = nil
+		//Decoding reuses slices and pointers, base must not be changed
+		request.BanDurations = append([]int64(nil), base.BanDurations...)
+		request.Rules = append([]LimitRule(nil), base.Rules...)
+		if base.Candidate != nil {
+			candidate := *base.Candidate
+			candidate.BanDurations = append([]int64(nil), base.Candidate.BanDurations...)
+			candidate.Rules = append([]LimitRule(nil), base.Candidate.Rules...)
+			request.Candidate = &candidate
+		}
+	} else {
+		fields := map[string]json.RawMessage{}
+		if errFields := json.Unmarshal(body, &fields); errFields != nil {
+			return nil, errFields
+		}
+		for _, field := range PolicyOverrideRequiredFields {
+			if _, found := fields[field]; !found {
+				return nil, fmt.Errorf("%w: %v", ErrorPolicyOverrideIncomplete, field)
+			}
+		}
+	}
+	if errDecode := json.Unmarshal(body, request); errDecode != nil {
+		return nil, errDecode
+	}
+	return request, nil
+}
+
+/*
+RegisterTrackerAdminRoutes adds admin routes to a group:
+
+  - GET /users/:userId/trackers?url=: tracker of userId and url, all trackers of userId if url is empty
+
+  - DELETE /users/:userId/trackers?url=: resets tracker of userId and url, all trackers of userId if url is empty
+
+  - DELETE /users/:userId/ban?url=: lifts ban of tracker of userId and url, bans of all trackers of userId if url is empty.
+    Admin must implement TrackerBanAdmin, 501 otherwise
+
+  - GET /users/:userId/policy: policy override of userId
+
+  - PUT /users/:userId/policy: overrides policy of userId by a PolicyOverrideRequest merged onto PolicyOverrides.Base,
+    400 if it is not a full policy and there is no base. Overrides are kept in memory of this instance only,
+    every instance serving the user must be called
+
+  - DELETE /users/:userId/policy: removes policy override of userId
+
+Requests are served only if authorize returns true, 403 otherwise. Overrides nil disables policy routes
+*/
+func RegisterTrackerAdminRoutes(group *gin.RouterGroup, admin TrackerAdmin, overrides *PolicyOverrides,
+	authorize func(c *gin.Context) bool) {
+	group.Use(func(c *gin.Context) {
+		if authorize == nil || !authorize(c) {
+			c.AbortWithStatus(http.StatusForbidden)
+		}
+	})
+
+	group.GET("/users/:userId/trackers", func(c *gin.Context) {
+		userId := c.Param("userId")
+		url := c.Query("url")
+		if len(url) > 0 {
+			tracker, err := admin.GetTracker(c.Request.Context(), userId, url)
+			if err != nil {
+				abortAdminRequest(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, tracker)
+			return
+		}
+		trackers, err := admin.GetUserTrackers(c.Request.Context(), userId)
+		if err != nil {
+			abortAdminRequest(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, trackers)
+	})
+
+	group.DELETE("/users/:userId/trackers", func(c *gin.Context) {
+		userId := c.Param("userId")
+		url := c.Query("url")
+		deleted := 1
+		var err error
+		if len(url) > 0 {
+			err = admin.ResetTracker(c.Request.Context(), userId, url)
+		} else {
+			deleted, err = admin.ResetUserTrackers(c.Request.Context(), userId)
+		}
+		if err != nil {
+			abortAdminRequest(c, err)
+			return
+		}
+		log.Infof("TrackerAdmin: Reset, userId=%v, url=%v, deleted=%v, IP=%v", userId, url, deleted, c.ClientIP())
+		c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+	})
+
+	group.DELETE("/users/:userId/ban", func(c *gin.Context) {
+		banAdmin, isBanAdmin := admin.(TrackerBanAdmin)
+		if !isBanAdmin {
+			abortAdminRequest(c, ErrorAdminNotSupported)
+			return
+		}
+		userId := c.Param("userId")
+		url := c.Query("url")
+		lifted := 1
+		var err error
+		if len(url) > 0 {
+			err = banAdmin.LiftBan(c.Request.Context(), userId, url)
+		} else {
+			lifted, err = liftUserBans(c.Request.Context(), admin, banAdmin, userId, overrides.now())
+		}
+		if err != nil {
+			abortAdminRequest(c, err)
+			return
+		}
+		log.Infof("TrackerAdmin: BanLifted, userId=%v, url=%v, lifted=%v, IP=%v", userId, url, lifted, c.ClientIP())
+		c.JSON(http.StatusOK, gin.H{"lifted": lifted})
+	})
+
+	if overrides == nil {
+		return
+	}
+
+	group.GET("/users/:userId/policy", func(c *gin.Context) {
+		config := overrides.Get(c.Param("userId"), overrides.now())
+		if config == nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.JSON(http.StatusOK, config)
+	})
+
+	group.PUT("/users/:userId/policy", func(c *gin.Context) {
+		body, errRead := c.GetRawData()
+		if errRead != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		request, errParse := ParsePolicyOverrideRequest(body, overrides.Base)
+		if errParse != nil || request.DurationSec <= 0 {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		userId := c.Param("userId")
+		config := request.LimitterConfig
+		until := overrides.now().Add(time.Duration(request.DurationSec) * time.Second)
+		overrides.Set(userId, &config, until)
+		log.Infof("TrackerAdmin: PolicyOverridden, userId=%v, config=%+v, until=%v, IP=%v", userId, config, until, c.ClientIP())
+		c.JSON(http.StatusOK, config)
+	})
+
+	group.DELETE("/users/:userId/policy", func(c *gin.Context) {
+		userId := c.Param("userId")
+		overrides.Remove(userId)
+		log.Infof("TrackerAdmin: PolicyOverrideRemoved, userId=%v, IP=%v", userId, c.ClientIP())
+		c.Status(http.StatusOK)
+	})
+}
+
+func abortAdminRequest(c *gin.Context, err error) {
+	if errors.Is(err, ErrorTrackerNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
+	} else if errors.Is(err, ErrorAdminNotSupported) {
+		c.AbortWithStatus(http.StatusNotImplemented)
+	} else {
+		log.Errorf("TrackerAdmin: Failed, path=%v, error=%v", c.Request.URL.Path, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}
+}
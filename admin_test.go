@@ -0,0 +1,148 @@
+package limitter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// createAdminTestRouter returns a router with admin routes under /admin, requests are authorized by header X-Admin
+func createAdminTestRouter(admin TrackerAdmin, overrides *PolicyOverrides) *gin.Engine {
+	router := gin.New()
+	RegisterTrackerAdminRoutes(router.Group("/admin"), admin, overrides, func(c *gin.Context) bool {
+		return c.GetHeader("X-Admin") == "secret"
+	})
+	return router
+}
+
+func serveAdminRequest(router *gin.Engine, method string, path string, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("X-Admin", "secret")
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// go test -timeout 30s -run ^TestTrackerAdmin_NotAuthorized_Forbidden$ github.com/zeroboo/gin-request-limitter -v
+func TestTrackerAdmin_NotAuthorized_Forbidden(t *testing.T) {
+	router := createAdminTestRouter(NewRedisTrackerStore(nil), nil)
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest(http.MethodGet, "/admin/users/user/trackers", nil)
+	router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code, "Request without authorization is forbidden")
+}
+
+// go test -timeout 30s -run ^TestTrackerAdmin_RedisTrackers_InspectedAndReset$ github.com/zeroboo/gin-request-limitter -v
+func TestTrackerAdmin_RedisTrackers_InspectedAndReset(t *testing.T) {
+	userId := RandomString(16)
+	handler := CreateRedisBackedLimitter(GetUserIdFromContextByField(FieldNameUserId), &limitterTestConfigLongWindow, false)
+	for _, url := range []string{"/health", "/items"} {
+		RecordRequest(http.MethodGet, url, map[string][]string{}, map[string][]string{},
+			CreateFakeAuthenticationHandler(FieldNameUserId, userId), handler, HandleHealth)
+	}
+	router := createAdminTestRouter(NewRedisTrackerStore(nil), nil)
+
+	recorder := serveAdminRequest(router, http.MethodGet, "/admin/users/"+userId+"/trackers?url=/health", "")
+	assert.Equal(t, http.StatusOK, recorder.Code, "Get tracker success")
+	tracker := RequestTracker{}
+	json.Unmarshal(recorder.Body.Bytes(), &tracker)
+	assert.Equal(t, int64(1), tracker.WindowRequest, "Tracker of request")
+
+	recorder = serveAdminRequest(router, http.MethodGet, "/admin/users/"+userId+"/trackers", "")
+	trackers := []RequestTracker{}
+	json.Unmarshal(recorder.Body.Bytes(), &trackers)
+	assert.Equal(t, 2, len(trackers), "Trackers of user listed")
+
+	recorder = serveAdminRequest(router, http.MethodDelete, "/admin/users/"+userId+"/trackers?url=/health", "")
+	assert.Equal(t, http.StatusOK, recorder.Code, "Reset tracker success")
+	recorder = serveAdminRequest(router, http.MethodGet, "/admin/users/"+userId+"/trackers?url=/health", "")
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "Tracker reset")
+
+	recorder = serveAdminRequest(router, http.MethodDelete, "/admin/users/"+userId+"/trackers", "")
+	assert.Equal(t, `{"deleted":1}`, recorder.Body.String(), "Remaining trackers of user reset")
+}
+
+// go test -timeout 30s -run ^TestTrackerAdmin_PolicyOverridden_LimitterUsesOverride$ github.com/zeroboo/gin-request-limitter -v
+func TestTrackerAdmin_PolicyOverridden_LimitterUsesOverride(t *testing.T) {
+	userId := RandomString(16)
+	overrides := NewPolicyOverrides()
+	config := limitterTestConfigLongWindow
+	config.Overrides = overrides
+	overrides.Base = &config
+	handler := CreateStoreBackedLimitter(&mapTrackerStore{}, GetUserIdFromContextByField(FieldNameUserId), &config, false)
+	router := createAdminTestRouter(NewNearCacheTrackerStore(&mapTrackerStore{}, 10), overrides)
+
+	recorder := serveAdminRequest(router, http.MethodPut, "/admin/users/"+userId+"/policy", `{"minRequestInterval":0,"maxRequestPerWindow":3,"durationSec":60}`)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Override success")
+
+	for i := 0; i < 3; i++ {
+		recorder = RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{},
+			CreateFakeAuthenticationHandler(FieldNameUserId, userId), handler, HandleHealth)
+		assert.Equal(t, http.StatusOK, recorder.Code, "Overridden policy has no min interval and a larger window")
+	}
+	recorder = RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{},
+		CreateFakeAuthenticationHandler(FieldNameUserId, userId), handler, HandleHealth)
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code, "Window of base policy kept")
+
+	recorder = serveAdminRequest(router, http.MethodDelete, "/admin/users/"+userId+"/policy", "")
+	assert.Equal(t, http.StatusOK, recorder.Code, "Remove override success")
+	recorder = serveAdminRequest(router, http.MethodGet, "/admin/users/"+userId+"/policy", "")
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "Override removed")
+
+	recorder = serveAdminRequest(router, http.MethodGet, "/admin/users/"+userId+"/trackers", "")
+	assert.Equal(t, http.StatusNotImplemented, recorder.Code, "Store without admin support")
+}
+
+// go test -timeout 30s -run ^TestParsePolicyOverrideRequest_Partial_MergedOrRejected$ github.com/zeroboo/gin-request-limitter -v
+func TestParsePolicyOverrideRequest_Partial_MergedOrRejected(t *testing.T) {
+	base := limitterTestConfigLongWindow
+	base.BanDurations = []int64{60000}
+
+	request, errParse := ParsePolicyOverrideRequest([]byte(`{"maxRequestPerWindow":10,"banDurations":[1000],"durationSec":60}`), &base)
+	assert.Nil(t, errParse, "Partial request merged")
+	assert.Equal(t, int64(10), request.MaxRequestPerWindow, "Field of request")
+	assert.Equal(t, base.WindowSize, request.WindowSize, "Omitted field of base")
+	assert.Equal(t, int64(60), request.DurationSec, "Duration")
+	assert.Equal(t, []int64{60000}, base.BanDurations, "Base not changed")
+
+	_, errParse = ParsePolicyOverrideRequest([]byte(`{"maxRequestPerWindow":10,"durationSec":60}`), nil)
+	assert.ErrorIs(t, errParse, ErrorPolicyOverrideIncomplete, "Partial request without base rejected")
+	request, errParse = ParsePolicyOverrideRequest(
+		[]byte(`{"minRequestInterval":0,"windowSize":1000,"maxRequestPerWindow":10,"expSec":60,"durationSec":60}`), nil)
+	assert.Nil(t, errParse, "Full request without base accepted")
+	assert.Equal(t, int64(1000), request.WindowSize, "Field of request")
+
+	router := createAdminTestRouter(NewNearCacheTrackerStore(&mapTrackerStore{}, 10), NewPolicyOverrides())
+	recorder := serveAdminRequest(router, http.MethodPut, "/admin/users/user/policy", `{"maxRequestPerWindow":10,"durationSec":60}`)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code, "Partial override rejected by route")
+}
+
+// go test -timeout 30s -run ^TestTrackerAdmin_PolicyWithCandidateRules_BaseUnchanged$ github.com/zeroboo/gin-request-limitter -v
+func TestTrackerAdmin_PolicyWithCandidateRules_BaseUnchanged(t *testing.T) {
+	overrides := NewPolicyOverrides()
+	base := limitterTestConfigLongWindow
+	base.Candidate = &LimitterConfig{
+		WindowSize:          60000,
+		MaxRequestPerWindow: 10,
+		Rules:               []LimitRule{{Name: "minute", WindowSize: 60000, MaxRequestPerWindow: 10}},
+		BanDurations:        []int64{60000},
+	}
+	overrides.Base = &base
+	router := createAdminTestRouter(NewNearCacheTrackerStore(&mapTrackerStore{}, 10), overrides)
+
+	recorder := serveAdminRequest(router, http.MethodPut, "/admin/users/user/policy",
+		`{"candidate":{"rules":[{"name":"second","windowSize":1000,"maxRequestPerWindow":1}],"banDurations":[1000]},"durationSec":60}`)
+
+	assert.Equal(t, http.StatusOK, recorder.Code, "Override success")
+	assert.Equal(t, []LimitRule{{Name: "minute", WindowSize: 60000, MaxRequestPerWindow: 10}}, base.Candidate.Rules, "Candidate rules of base unchanged")
+	assert.Equal(t, []int64{60000}, base.Candidate.BanDurations, "Candidate ban durations of base unchanged")
+	override := overrides.Get("user", overrides.now())
+	assert.Equal(t, "second", override.Candidate.Rules[0].Name, "Candidate rules of override")
+}
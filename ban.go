@@ -0,0 +1,161 @@
+/*
+Penalty box: keys that keep violating a policy are banned for escalating durations
+*/
+
+package limitter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrorRequestBanned = fmt.Errorf("request is banned")
+
+// Ban durations in milisecs by ban level when LimitterConfig.BanDurations is empty
+var DefaultBanDurations []int64 = []int64{60000, 600000, 3600000}
+
+const HeaderBanUntil string = "X-RateLimit-Ban-Until"
+const HeaderBanLevel string = "X-RateLimit-Ban-Level"
+
+// TrackerBanAdmin lifts bans of a store
+type TrackerBanAdmin interface {
+	// LiftBan clears ban and violations of tracker of userId and url, ErrorTrackerNotFound if there is none
+	LiftBan(ctx context.Context, userId string, url string) error
+}
+
+// IsBanEnabled returns true if violations of this policy lead to bans
+func (config *LimitterConfig) IsBanEnabled() bool {
+	return config.BanThreshold > 0
+}
+
+// GetBanDuration returns ban duration in milisecs of a ban level, levels over the last duration use the last one
+func (config *LimitterConfig) GetBanDuration(banLevel int64) int64 {
+	durations := config.BanDurations
+	if len(durations) == 0 {
+		durations = DefaultBanDurations
+	}
+	if banLevel >= int64(len(durations)) {
+		banLevel = int64(len(durations)) - 1
+	}
+	return durations[banLevel]
+}
+
+// IsBanned returns true if tracker is banned at currentTime
+func (tracker *RequestTracker) IsBanned(currentTime time.Time) bool {
+	return tracker.BanUntil > currentTime.UnixMilli()
+}
+
+/*
+RecordViolation counts a rejected request and bans tracker when violations reach config.BanThreshold in config.BanPeriod.
+Each ban lasts longer than the previous one, ban level is forgotten if there is no violation in BanPeriod after a ban ends.
+Returns true if tracker is banned by this violation
+*/
+func (tracker *RequestTracker) RecordViolation(currentTime time.Time, config *LimitterConfig) bool {
+	if !config.IsBanEnabled() {
+		return false
+	}
+	now := currentTime.UnixMilli()
+	if tracker.ViolationStart == 0 || (config.BanPeriod > 0 && now-tracker.ViolationStart >= config.BanPeriod) {
+		if tracker.BanUntil > 0 && config.BanPeriod > 0 && now-tracker.BanUntil >= config.BanPeriod {
+			tracker.BanLevel = 0
+		}
+		tracker.ViolationStart = now
+		tracker.Violations = 0
+	}
+	tracker.Violations += 1
+	if tracker.Violations < config.BanThreshold {
+		return false
+	}
+
+	tracker.BanUntil = now + config.GetBanDuration(tracker.BanLevel)
+	tracker.BanLevel += 1
+	tracker.Violations = 0
+	tracker.ViolationStart = 0
+	if tracker.Exp < tracker.BanUntil {
+		tracker.Exp = tracker.BanUntil
+	}
+	return true
+}
+
+// IsPenaltyChanged returns true if violations or ban of tracker differ from previous
+func (tracker *RequestTracker) IsPenaltyChanged(previous *RequestTracker) bool {
+	return tracker.Violations != previous.Violations ||
+		tracker.ViolationStart != previous.ViolationStart ||
+		tracker.BanLevel != previous.BanLevel ||
+		tracker.BanUntil != previous.BanUntil
+}
+
+// mergeTrackerPenalty keeps the latest ban and the latest violations of stored and local
+func mergeTrackerPenalty(stored *RequestTracker, local *RequestTracker) {
+	if local.BanUntil > stored.BanUntil {
+		stored.BanUntil = local.BanUntil
+		stored.BanLevel = local.BanLevel
+	}
+	if local.ViolationStart > stored.ViolationStart ||
+		(local.ViolationStart == stored.ViolationStart && local.Violations > stored.Violations) {
+		stored.ViolationStart = local.ViolationStart
+		stored.Violations = local.Violations
+	}
+}
+
+// CreatePenalizedTracker returns loaded tracker with violations and ban of validated tracker, so rejected request is not counted
+func CreatePenalizedTracker(loaded *RequestTracker, validated *RequestTracker) *RequestTracker {
+	penalized := *loaded
+	penalized.Violations = validated.Violations
+	penalized.ViolationStart = validated.ViolationStart
+	penalized.BanLevel = validated.BanLevel
+	penalized.BanUntil = validated.BanUntil
+	if penalized.Exp < validated.Exp {
+		penalized.Exp = validated.Exp
+	}
+	return &penalized
+}
+
+// ClearBan lifts ban and forgets violations and ban level of tracker
+func (tracker *RequestTracker) ClearBan() {
+	tracker.Violations = 0
+	tracker.ViolationStart = 0
+	tracker.BanLevel = 0
+	tracker.BanUntil = 0
+}
+
+// SetBanHeaders tells client when ban of tracker ends: Retry-After in seconds, ban end in unix seconds and ban level
+func SetBanHeaders(c *gin.Context, tracker *RequestTracker, currentTime time.Time) {
+	WriteBanHeaders(c.Writer.Header(), tracker, currentTime)
+}
+
+// WriteBanHeaders writes headers of SetBanHeaders to header
+func WriteBanHeaders(header http.Header, tracker *RequestTracker, currentTime time.Time) {
+	retryAfterSec := (tracker.BanUntil - currentTime.UnixMilli() + 999) / 1000
+	if retryAfterSec < 0 {
+		retryAfterSec = 0
+	}
+	header.Set("Retry-After", strconv.FormatInt(retryAfterSec, 10))
+	header.Set(HeaderBanUntil, strconv.FormatInt((tracker.BanUntil+999)/1000, 10))
+	header.Set(HeaderBanLevel, strconv.FormatInt(tracker.BanLevel, 10))
+}
+
+// liftUserBans lifts bans of all trackers of userId and returns number of lifted bans
+func liftUserBans(ctx context.Context, admin TrackerAdmin, banAdmin TrackerBanAdmin, userId string, currentTime time.Time) (int, error) {
+	trackers, errList := admin.GetUserTrackers(ctx, userId)
+	if errList != nil {
+		return 0, errList
+	}
+	lifted := 0
+	for _, tracker := range trackers {
+		if !tracker.IsBanned(currentTime) && tracker.Violations == 0 {
+			continue
+		}
+		if errLift := banAdmin.LiftBan(ctx, tracker.UID, tracker.URL); errLift != nil && !errors.Is(errLift, ErrorTrackerNotFound) {
+			return lifted, errLift
+		}
+		lifted += 1
+	}
+	return lifted, nil
+}
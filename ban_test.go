@@ -0,0 +1,120 @@
+package limitter
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var limitterTestConfigBan LimitterConfig = LimitterConfig{
+	MinRequestInterval:  0,
+	WindowSize:          6000000,
+	MaxRequestPerWindow: 1,
+	ExpSec:              600,
+	BanThreshold:        2,
+	BanPeriod:           60000,
+	BanDurations:        []int64{60000, 600000},
+}
+
+// go test -timeout 30s -run ^TestRecordViolation_RepeatedBans_Escalated$ github.com/zeroboo/gin-request-limitter -v
+func TestRecordViolation_RepeatedBans_Escalated(t *testing.T) {
+	tracker := NewRequestTracker("user", "/health")
+	now := time.UnixMilli(1000000)
+
+	assert.False(t, tracker.RecordViolation(now, &limitterTestConfigBan), "Violation under threshold")
+	assert.True(t, tracker.RecordViolation(now, &limitterTestConfigBan), "Violation reaching threshold bans")
+	assert.Equal(t, now.UnixMilli()+60000, tracker.BanUntil, "First ban duration")
+	assert.True(t, tracker.IsBanned(now), "Banned")
+	assert.Equal(t, tracker.BanUntil, tracker.Exp, "Tracker lives until ban ends")
+
+	now = time.UnixMilli(tracker.BanUntil)
+	assert.False(t, tracker.IsBanned(now), "Ban ended")
+	tracker.RecordViolation(now, &limitterTestConfigBan)
+	tracker.RecordViolation(now, &limitterTestConfigBan)
+	assert.Equal(t, now.UnixMilli()+600000, tracker.BanUntil, "Second ban is longer")
+
+	now = time.UnixMilli(tracker.BanUntil)
+	tracker.RecordViolation(now, &limitterTestConfigBan)
+	tracker.RecordViolation(now, &limitterTestConfigBan)
+	assert.Equal(t, now.UnixMilli()+600000, tracker.BanUntil, "Bans over last duration use last duration")
+
+	now = time.UnixMilli(tracker.BanUntil + limitterTestConfigBan.BanPeriod)
+	tracker.RecordViolation(now, &limitterTestConfigBan)
+	tracker.RecordViolation(now, &limitterTestConfigBan)
+	assert.Equal(t, now.UnixMilli()+60000, tracker.BanUntil, "Ban level forgotten after a clean period")
+}
+
+// go test -timeout 30s -run ^TestRecordViolation_ViolationsOutOfPeriod_NotBanned$ github.com/zeroboo/gin-request-limitter -v
+func TestRecordViolation_ViolationsOutOfPeriod_NotBanned(t *testing.T) {
+	tracker := NewRequestTracker("user", "/health")
+
+	assert.False(t, tracker.RecordViolation(time.UnixMilli(1000000), &limitterTestConfigBan), "First violation")
+	assert.False(t, tracker.RecordViolation(time.UnixMilli(1000000+limitterTestConfigBan.BanPeriod), &limitterTestConfigBan), "Violation of new period")
+	assert.Equal(t, int64(1), tracker.Violations, "Old violations forgotten")
+	assert.False(t, tracker.RecordViolation(time.UnixMilli(1000000), &limitterConfigNoBan), "Ban disabled")
+}
+
+var limitterConfigNoBan LimitterConfig = LimitterConfig{}
+
+// go test -timeout 30s -run ^TestBan_StoreBackedLimitter_BannedWithHeaders$ github.com/zeroboo/gin-request-limitter -v
+func TestBan_StoreBackedLimitter_BannedWithHeaders(t *testing.T) {
+	store := NewNearCacheTrackerStore(&mapTrackerStore{}, 10)
+	handler := CreateStoreBackedLimitter(store, GetUserIdFromContextByField(FieldNameUserId), &limitterTestConfigBan, false)
+	authHandler := CreateFakeAuthenticationHandler(FieldNameUserId, RandomString(16))
+
+	codes := []int{}
+	for i := 0; i < 4; i++ {
+		recorder := RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{}, authHandler, handler, HandleHealth)
+		codes = append(codes, recorder.Code)
+		if i == 3 {
+			assert.Equal(t, "60", recorder.Header().Get("Retry-After"), "Retry after ban ends")
+			assert.Equal(t, "1", recorder.Header().Get(HeaderBanLevel), "First ban")
+			assert.NotEmpty(t, recorder.Header().Get(HeaderBanUntil), "Ban end")
+		}
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusTooManyRequests, http.StatusForbidden, http.StatusForbidden}, codes,
+		"Second violation bans")
+}
+
+// go test -timeout 30s -run ^TestBan_RedisTracker_BanSavedAndLifted$ github.com/zeroboo/gin-request-limitter -v
+func TestBan_RedisTracker_BanSavedAndLifted(t *testing.T) {
+	userId := RandomString(16)
+	handler := CreateStoreBackedLimitter(NewRedisTrackerStore(nil), GetUserIdFromContextByField(FieldNameUserId), &limitterTestConfigBan, false)
+	authHandler := CreateFakeAuthenticationHandler(FieldNameUserId, userId)
+	for i := 0; i < 3; i++ {
+		RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{}, authHandler, handler, HandleHealth)
+	}
+
+	store := NewRedisTrackerStore(nil)
+	tracker, errGet := store.GetTracker(context.Background(), userId, "/health")
+	assert.Nil(t, errGet, "Get tracker success")
+	assert.True(t, tracker.IsBanned(time.Now()), "Ban saved")
+	assert.Equal(t, int64(1), tracker.BanLevel, "Ban level saved")
+
+	router := createAdminTestRouter(store, nil)
+	recorder := serveAdminRequest(router, http.MethodDelete, "/admin/users/"+userId+"/ban", "")
+	assert.Equal(t, `{"lifted":1}`, recorder.Body.String(), "Ban lifted")
+
+	tracker, _ = store.GetTracker(context.Background(), userId, "/health")
+	assert.False(t, tracker.IsBanned(time.Now()), "Ban cleared")
+	assert.Equal(t, int64(1), tracker.WindowRequest, "Requests kept")
+	recorder = RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{}, authHandler, handler, HandleHealth)
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code, "Limit still applies after ban lifted")
+}
+
+// go test -timeout 30s -run ^TestAggregateShards_Penalty_LatestKept$ github.com/zeroboo/gin-request-limitter -v
+func TestAggregateShards_Penalty_LatestKept(t *testing.T) {
+	tracker := AggregateShards("user", "/health", []RequestTracker{
+		{BanUntil: 2000, BanLevel: 2, ViolationStart: 100, Violations: 3},
+		{BanUntil: 1000, BanLevel: 1, ViolationStart: 300, Violations: 1},
+		{},
+	})
+
+	assert.Equal(t, int64(2000), tracker.BanUntil, "Latest ban")
+	assert.Equal(t, int64(2), tracker.BanLevel, "Level of latest ban")
+	assert.Equal(t, int64(300), tracker.ViolationStart, "Latest violation period")
+	assert.Equal(t, int64(1), tracker.Violations, "Violations of latest period")
+}
@@ -0,0 +1,223 @@
+/*
+Circuit breaker that protects requests from a failing tracker store
+*/
+
+package limitter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const CIRCUIT_STATE_CLOSED int = 0
+const CIRCUIT_STATE_OPEN int = 1
+const CIRCUIT_STATE_HALF_OPEN int = 2
+
+var ErrorCircuitOpen = fmt.Errorf("circuit breaker is open")
+
+type CircuitBreakerConfig struct {
+	//Window frame in milisecs to collect error rate
+	WindowSize int64
+
+	//Min calls in a window before error rate is considered
+	MinRequestPerWindow int64
+
+	//Error rate in range (0, 1] that opens the breaker
+	ErrorRateThreshold float64
+
+	//Time in milisecs breaker stays open before trial calls are let through
+	OpenDuration int64
+
+	//Max trial calls in half-open state, all must succeed to close the breaker
+	HalfOpenMaxRequest int64
+
+	//Clock tells current time to breaker, nil means SystemClock
+	Clock Clock
+}
+
+var DefaultCircuitBreakerConfig CircuitBreakerConfig = CircuitBreakerConfig{
+	WindowSize:          10000,
+	MinRequestPerWindow: 20,
+	ErrorRateThreshold:  0.5,
+	OpenDuration:        5000,
+	HalfOpenMaxRequest:  1,
+}
+
+// CircuitBreakerTrackerStore wraps a store, it fails fast with ErrorCircuitOpen when the store keeps failing
+type CircuitBreakerTrackerStore struct {
+	Store  TrackerStore
+	Config CircuitBreakerConfig
+
+	mutex           sync.Mutex
+	state           int
+	windowNum       int64
+	windowRequest   int64
+	windowFailure   int64
+	openedAt        int64
+	halfOpenRequest int64
+	halfOpenSuccess int64
+}
+
+// NewCircuitBreakerTrackerStore returns a breaker around store, non-positive values of config are replaced by DefaultCircuitBreakerConfig.
+// A zero window would never forget old errors and zero half-open requests would never close the breaker
+func NewCircuitBreakerTrackerStore(store TrackerStore, config CircuitBreakerConfig) *CircuitBreakerTrackerStore {
+	if config.WindowSize <= 0 {
+		config.WindowSize = DefaultCircuitBreakerConfig.WindowSize
+	}
+	if config.ErrorRateThreshold <= 0 {
+		config.ErrorRateThreshold = DefaultCircuitBreakerConfig.ErrorRateThreshold
+	}
+	if config.OpenDuration <= 0 {
+		config.OpenDuration = DefaultCircuitBreakerConfig.OpenDuration
+	}
+	if config.HalfOpenMaxRequest <= 0 {
+		config.HalfOpenMaxRequest = DefaultCircuitBreakerConfig.HalfOpenMaxRequest
+	}
+	return &CircuitBreakerTrackerStore{
+		Store:  store,
+		Config: config,
+		state:  CIRCUIT_STATE_CLOSED,
+	}
+}
+
+// GetState returns current state of breaker
+func (breaker *CircuitBreakerTrackerStore) GetState() int {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	breaker.updateState(getClock(breaker.Config.Clock).Now())
+	return breaker.state
+}
+
+// updateState moves open breaker to half-open after open duration. Caller must hold mutex
+func (breaker *CircuitBreakerTrackerStore) updateState(currentTime time.Time) {
+	if breaker.state == CIRCUIT_STATE_OPEN &&
+		currentTime.UnixMilli()-breaker.openedAt >= breaker.Config.OpenDuration {
+		breaker.state = CIRCUIT_STATE_HALF_OPEN
+		breaker.halfOpenRequest = 0
+		breaker.halfOpenSuccess = 0
+		log.Infof("CircuitBreaker: HalfOpen, openedAt=%v", breaker.openedAt)
+	}
+}
+
+// allow returns true if a call can go to the store
+func (breaker *CircuitBreakerTrackerStore) allow(currentTime time.Time) bool {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	breaker.updateState(currentTime)
+
+	switch breaker.state {
+	case CIRCUIT_STATE_OPEN:
+		return false
+	case CIRCUIT_STATE_HALF_OPEN:
+		if breaker.halfOpenRequest >= breaker.Config.HalfOpenMaxRequest {
+			return false
+		}
+		breaker.halfOpenRequest += 1
+	}
+	return true
+}
+
+// record collects result of a call and changes state of breaker
+func (breaker *CircuitBreakerTrackerStore) record(currentTime time.Time, success bool) {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	switch breaker.state {
+	case CIRCUIT_STATE_HALF_OPEN:
+		if !success {
+			breaker.open(currentTime)
+			return
+		}
+		breaker.halfOpenSuccess += 1
+		if breaker.halfOpenSuccess >= breaker.Config.HalfOpenMaxRequest {
+			breaker.state = CIRCUIT_STATE_CLOSED
+			breaker.windowNum = 0
+			log.Infof("CircuitBreaker: Closed")
+		}
+	case CIRCUIT_STATE_CLOSED:
+		if breaker.Config.WindowSize > 0 {
+			currentWindow := currentTime.UnixMilli() / breaker.Config.WindowSize
+			if currentWindow != breaker.windowNum {
+				breaker.windowNum = currentWindow
+				breaker.windowRequest = 0
+				breaker.windowFailure = 0
+			}
+		}
+		breaker.windowRequest += 1
+		if !success {
+			breaker.windowFailure += 1
+		}
+		if breaker.windowRequest >= breaker.Config.MinRequestPerWindow &&
+			float64(breaker.windowFailure) >= breaker.Config.ErrorRateThreshold*float64(breaker.windowRequest) &&
+			breaker.windowFailure > 0 {
+			breaker.open(currentTime)
+		}
+	}
+}
+
+// open trips the breaker. Caller must hold mutex
+func (breaker *CircuitBreakerTrackerStore) open(currentTime time.Time) {
+	log.Warnf("CircuitBreaker: Open, calls=%v, failures=%v, openDuration=%v",
+		breaker.windowRequest, breaker.windowFailure, breaker.Config.OpenDuration)
+	breaker.state = CIRCUIT_STATE_OPEN
+	breaker.openedAt = currentTime.UnixMilli()
+	breaker.windowRequest = 0
+	breaker.windowFailure = 0
+}
+
+// UpdateTracker calls wrapped store if breaker allows, returns ErrorCircuitOpen otherwise
+func (breaker *CircuitBreakerTrackerStore) UpdateTracker(ctx context.Context, userId string, url string, config *LimitterConfig,
+	validate func(tracker *RequestTracker) error) (*RequestTracker, error) {
+	if !breaker.allow(getClock(breaker.Config.Clock).Now()) {
+		return nil, ErrorCircuitOpen
+	}
+
+	tracker, err := breaker.Store.UpdateTracker(ctx, userId, url, config, validate)
+	breaker.record(getClock(breaker.Config.Clock).Now(), err == nil || IsValidateError(err))
+	return tracker, err
+}
+
+// GetTracker returns tracker from wrapped store, admin calls are not guarded by breaker
+func (breaker *CircuitBreakerTrackerStore) GetTracker(ctx context.Context, userId string, url string) (*RequestTracker, error) {
+	admin, isAdmin := breaker.Store.(TrackerAdmin)
+	if !isAdmin {
+		return nil, ErrorAdminNotSupported
+	}
+	return admin.GetTracker(ctx, userId, url)
+}
+
+func (breaker *CircuitBreakerTrackerStore) GetUserTrackers(ctx context.Context, userId string) ([]*RequestTracker, error) {
+	admin, isAdmin := breaker.Store.(TrackerAdmin)
+	if !isAdmin {
+		return nil, ErrorAdminNotSupported
+	}
+	return admin.GetUserTrackers(ctx, userId)
+}
+
+func (breaker *CircuitBreakerTrackerStore) ResetTracker(ctx context.Context, userId string, url string) error {
+	admin, isAdmin := breaker.Store.(TrackerAdmin)
+	if !isAdmin {
+		return ErrorAdminNotSupported
+	}
+	return admin.ResetTracker(ctx, userId, url)
+}
+
+func (breaker *CircuitBreakerTrackerStore) ResetUserTrackers(ctx context.Context, userId string) (int, error) {
+	admin, isAdmin := breaker.Store.(TrackerAdmin)
+	if !isAdmin {
+		return 0, ErrorAdminNotSupported
+	}
+	return admin.ResetUserTrackers(ctx, userId)
+}
+
+func (breaker *CircuitBreakerTrackerStore) LiftBan(ctx context.Context, userId string, url string) error {
+	banAdmin, isBanAdmin := breaker.Store.(TrackerBanAdmin)
+	if !isBanAdmin {
+		return ErrorAdminNotSupported
+	}
+	return banAdmin.LiftBan(ctx, userId, url)
+}
@@ -0,0 +1,159 @@
+package limitter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zeroboo/gin-request-limitter/clocktest"
+)
+
+// failingTrackerStore fails every call while Fail is true
+type failingTrackerStore struct {
+	Fail  bool
+	Calls int
+}
+
+func (store *failingTrackerStore) UpdateTracker(ctx context.Context, userId string, url string, config *LimitterConfig,
+	validate func(tracker *RequestTracker) error) (*RequestTracker, error) {
+	store.Calls += 1
+	if store.Fail {
+		return nil, fmt.Errorf("backend failed")
+	}
+	tracker := NewRequestTracker(userId, url)
+	return tracker, validate(tracker)
+}
+
+var breakerTestConfig CircuitBreakerConfig = CircuitBreakerConfig{
+	WindowSize:          60000,
+	MinRequestPerWindow: 4,
+	ErrorRateThreshold:  0.5,
+	OpenDuration:        200,
+	HalfOpenMaxRequest:  1,
+}
+
+// go test -timeout 30s -run ^TestCircuitBreaker_StoreKeepsFailing_OpenAndShortCircuit$ github.com/zeroboo/gin-request-limitter -v
+func TestCircuitBreaker_StoreKeepsFailing_OpenAndShortCircuit(t *testing.T) {
+	store := &failingTrackerStore{Fail: true}
+	breaker := NewCircuitBreakerTrackerStore(store, breakerTestConfig)
+	config := &LimitterConfig{}
+	validate := func(tracker *RequestTracker) error { return nil }
+
+	for i := 0; i < 4; i++ {
+		_, err := breaker.UpdateTracker(context.Background(), "user", "/health", config, validate)
+		assert.NotNil(t, err, "Store error returned")
+	}
+	assert.Equal(t, CIRCUIT_STATE_OPEN, breaker.GetState(), "Breaker opened")
+
+	_, err := breaker.UpdateTracker(context.Background(), "user", "/health", config, validate)
+	assert.ErrorIs(t, err, ErrorCircuitOpen, "Open breaker fails fast")
+	assert.Equal(t, 4, store.Calls, "Open breaker does not call store")
+}
+
+// go test -timeout 30s -run ^TestCircuitBreaker_StoreRecovered_Closed$ github.com/zeroboo/gin-request-limitter -v
+func TestCircuitBreaker_StoreRecovered_Closed(t *testing.T) {
+	store := &failingTrackerStore{Fail: true}
+	clock := clocktest.NewFakeClock(time.Now())
+	breakerConfig := breakerTestConfig
+	breakerConfig.Clock = clock
+	breaker := NewCircuitBreakerTrackerStore(store, breakerConfig)
+	config := &LimitterConfig{}
+	validate := func(tracker *RequestTracker) error { return nil }
+	for i := 0; i < 4; i++ {
+		breaker.UpdateTracker(context.Background(), "user", "/health", config, validate)
+	}
+
+	clock.Advance(time.Duration(breakerConfig.OpenDuration) * time.Millisecond)
+	assert.Equal(t, CIRCUIT_STATE_HALF_OPEN, breaker.GetState(), "Breaker half-open after open duration")
+
+	store.Fail = false
+	_, err := breaker.UpdateTracker(context.Background(), "user", "/health", config, validate)
+	assert.Nil(t, err, "Trial call success")
+	assert.Equal(t, CIRCUIT_STATE_CLOSED, breaker.GetState(), "Breaker closed after trial call success")
+}
+
+// go test -timeout 30s -run ^TestCircuitBreaker_RejectedRequests_NotCountedAsFailure$ github.com/zeroboo/gin-request-limitter -v
+func TestCircuitBreaker_RejectedRequests_NotCountedAsFailure(t *testing.T) {
+	store := &failingTrackerStore{Fail: false}
+	breaker := NewCircuitBreakerTrackerStore(store, breakerTestConfig)
+	config := &LimitterConfig{}
+	validate := func(tracker *RequestTracker) error { return ErrorRequestTooFast }
+
+	for i := 0; i < 10; i++ {
+		_, err := breaker.UpdateTracker(context.Background(), "user", "/health", config, validate)
+		assert.ErrorIs(t, err, ErrorRequestTooFast, "Validate error returned")
+	}
+	assert.Equal(t, CIRCUIT_STATE_CLOSED, breaker.GetState(), "Breaker stays closed")
+}
+
+// go test -timeout 30s -run ^TestStoreBackedLimitter_StoreFailed_ApplyFailurePolicy$ github.com/zeroboo/gin-request-limitter -v
+func TestStoreBackedLimitter_StoreFailed_ApplyFailurePolicy(t *testing.T) {
+	store := &failingTrackerStore{Fail: true}
+	userId := RandomString(16)
+
+	recorder := RecordRequest(http.MethodGet,
+		"/health",
+		map[string][]string{},
+		map[string][]string{},
+		CreateFakeAuthenticationHandler(FieldNameUserId, userId),
+		CreateStoreBackedLimitter(store, GetUserIdFromContextByField(FieldNameUserId), &LimitterConfig{AbortOnFail: false}, false),
+		HandleHealth,
+	)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Request served when not abort on fail")
+
+	recorder2 := RecordRequest(http.MethodGet,
+		"/health",
+		map[string][]string{},
+		map[string][]string{},
+		CreateFakeAuthenticationHandler(FieldNameUserId, userId),
+		CreateStoreBackedLimitter(store, GetUserIdFromContextByField(FieldNameUserId), &LimitterConfig{AbortOnFail: true}, false),
+		HandleHealth,
+	)
+	assert.Equal(t, http.StatusInternalServerError, recorder2.Code, "Request aborted when abort on fail")
+}
+
+// go test -timeout 30s -run ^TestCircuitBreaker_ZeroHalfOpenMaxRequest_Closed$ github.com/zeroboo/gin-request-limitter -v
+func TestCircuitBreaker_ZeroHalfOpenMaxRequest_Closed(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.UnixMilli(1000000))
+	store := &failingTrackerStore{Fail: true}
+	breakerConfig := breakerTestConfig
+	breakerConfig.HalfOpenMaxRequest = 0
+	breakerConfig.Clock = clock
+	breaker := NewCircuitBreakerTrackerStore(store, breakerConfig)
+	config := &LimitterConfig{}
+	validate := func(tracker *RequestTracker) error { return nil }
+
+	for i := 0; i < 4; i++ {
+		breaker.UpdateTracker(context.Background(), "user", "/health", config, validate)
+	}
+	store.Fail = false
+	clock.Advance(time.Duration(breakerConfig.OpenDuration) * time.Millisecond)
+
+	_, err := breaker.UpdateTracker(context.Background(), "user", "/health", config, validate)
+	assert.Nil(t, err, "Trial call let through")
+	assert.Equal(t, CIRCUIT_STATE_CLOSED, breaker.GetState(), "Breaker closed after default trial calls")
+}
+
+// go test -timeout 30s -run ^TestCircuitBreaker_ZeroWindow_OldErrorsForgotten$ github.com/zeroboo/gin-request-limitter -v
+func TestCircuitBreaker_ZeroWindow_OldErrorsForgotten(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.UnixMilli(1000000))
+	store := &failingTrackerStore{Fail: true}
+	breakerConfig := breakerTestConfig
+	breakerConfig.WindowSize = 0
+	breakerConfig.Clock = clock
+	breaker := NewCircuitBreakerTrackerStore(store, breakerConfig)
+	config := &LimitterConfig{}
+	validate := func(tracker *RequestTracker) error { return nil }
+
+	for i := 0; i < 3; i++ {
+		breaker.UpdateTracker(context.Background(), "user", "/health", config, validate)
+	}
+	clock.Advance(time.Hour)
+	breaker.UpdateTracker(context.Background(), "user", "/health", config, validate)
+
+	assert.Equal(t, CIRCUIT_STATE_CLOSED, breaker.GetState(), "Errors of old window do not open breaker")
+	assert.Equal(t, DefaultCircuitBreakerConfig.WindowSize, breaker.Config.WindowSize, "Default window")
+}
@@ -0,0 +1,34 @@
+/*
+Clock of limitters: current time is read from a Clock so tests and simulations can move time manually
+*/
+
+package limitter
+
+import "time"
+
+// Clock tells current time, see clocktest.FakeClock for a manual clock
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (clock systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the wall clock, used when no clock is configured
+var SystemClock Clock = systemClock{}
+
+// getClock returns clock if not nil, SystemClock otherwise
+func getClock(clock Clock) Clock {
+	if clock == nil {
+		return SystemClock
+	}
+	return clock
+}
+
+// Now returns current time of clock of config
+func (config *LimitterConfig) Now() time.Time {
+	return getClock(config.Clock).Now()
+}
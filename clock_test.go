@@ -0,0 +1,46 @@
+package limitter
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zeroboo/gin-request-limitter/clocktest"
+)
+
+// go test -timeout 30s -run ^TestStoreBackedLimitter_FakeClock_BanAndWindowEndInstantly$ github.com/zeroboo/gin-request-limitter -v
+func TestStoreBackedLimitter_FakeClock_BanAndWindowEndInstantly(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.UnixMilli(60000 * 1000))
+	config := limitterTestConfigBan
+	config.WindowSize = 60000
+	config.Clock = clock
+	handler := CreateStoreBackedLimitter(&mapTrackerStore{}, GetUserIdFromContextByField(FieldNameUserId), &config, false)
+	authHandler := CreateFakeAuthenticationHandler(FieldNameUserId, RandomString(16))
+
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		recorder := RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{}, authHandler, handler, HandleHealth)
+		codes = append(codes, recorder.Code)
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusTooManyRequests, http.StatusForbidden}, codes, "Banned after violations")
+
+	clock.Advance(time.Duration(config.BanDurations[0]) * time.Millisecond)
+	recorder := RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{}, authHandler, handler, HandleHealth)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Ban ended and next window started")
+}
+
+// go test -timeout 30s -run ^TestGetUserConfig_OverrideWithoutClock_ClockOfConfigUsed$ github.com/zeroboo/gin-request-limitter -v
+func TestGetUserConfig_OverrideWithoutClock_ClockOfConfigUsed(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.UnixMilli(1000000))
+	config := limitterTestConfig
+	config.Clock = clock
+	config.Overrides = NewPolicyOverrides()
+	config.Overrides.Set("user", &LimitterConfig{WindowSize: 1000, MaxRequestPerWindow: 1}, clock.Now().Add(time.Minute))
+
+	userConfig := config.GetUserConfig("user", clock.Now())
+
+	assert.Equal(t, int64(1000), userConfig.WindowSize, "Override used")
+	assert.Equal(t, clock.Now(), userConfig.Now(), "Override uses clock of config")
+	assert.Equal(t, SystemClock, getClock(nil), "No clock means system clock")
+}
@@ -0,0 +1,43 @@
+/*
+Package clocktest provides a manual clock for tests and simulations of limitters
+*/
+
+package clocktest
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a clock that only moves when told to, it is safe for concurrent use
+type FakeClock struct {
+	mutex sync.RWMutex
+	now   time.Time
+}
+
+// NewFakeClock returns a clock stopped at start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns current time of clock
+func (clock *FakeClock) Now() time.Time {
+	clock.mutex.RLock()
+	defer clock.mutex.RUnlock()
+	return clock.now
+}
+
+// Advance moves clock forward by duration and returns new time
+func (clock *FakeClock) Advance(duration time.Duration) time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.now = clock.now.Add(duration)
+	return clock.now
+}
+
+// Set moves clock to given time, forward or backward
+func (clock *FakeClock) Set(now time.Time) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.now = now
+}
@@ -0,0 +1,21 @@
+package clocktest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestFakeClock_AdvanceAndSet_Moved$ github.com/zeroboo/gin-request-limitter/clocktest -v
+func TestFakeClock_AdvanceAndSet_Moved(t *testing.T) {
+	start := time.UnixMilli(1000000)
+	clock := NewFakeClock(start)
+	assert.Equal(t, start, clock.Now(), "Clock stopped at start")
+
+	assert.Equal(t, start.Add(time.Minute), clock.Advance(time.Minute), "Advanced")
+	assert.Equal(t, start.Add(time.Minute), clock.Now(), "Clock stays after advance")
+
+	clock.Set(start)
+	assert.Equal(t, start, clock.Now(), "Set back")
+}
@@ -0,0 +1,445 @@
+/*
+limitterctl inspects, resets and exports trackers of gin-request-limitter in Redis or Datastore.
+
+Usage:
+
+	limitterctl [flags] <command> [command flags]
+
+Commands:
+
+  - list [-user U]: prints trackers, of a user if given
+
+  - get -user U -url URL: prints tracker of a user and url
+
+  - reset -user U [-url URL]: deletes tracker of a user and url, all trackers of the user if url is empty
+
+  - purge [-before TIME]: deletes trackers expired before TIME in RFC3339, now if empty
+
+  - top [-n N] [-window MILIS] [-max N]: prints N users with most bans and violations, then most requests in current window
+
+  - export [-format json|csv] [-user U]: prints trackers as JSON lines or CSV
+
+  - replay -policy FILE [-log-format combined|json] [-user-field F] [-time-field F] [-path-field F] [-n N] [LOG...]:
+    replays access logs, stdin if none, against a JSON policy in virtual time and prints N most throttled users and routes.
+    It needs no backend
+*/
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	limitter "github.com/zeroboo/gin-request-limitter"
+	"github.com/zeroboo/gin-request-limitter/limittersim"
+)
+
+// trackerBackend is what commands need from a store
+type trackerBackend interface {
+	limitter.TrackerAdmin
+	limitter.TrackerScanner
+	PurgeExpired(ctx context.Context, before time.Time) (int, error)
+}
+
+type redisBackend struct {
+	*limitter.RedisTrackerStore
+}
+
+// PurgeExpired deletes trackers expired before given time, redis also expires them by TTL
+func (backend redisBackend) PurgeExpired(ctx context.Context, before time.Time) (int, error) {
+	expired := []*limitter.RequestTracker{}
+	errScan := backend.ForEachTracker(ctx, func(tracker *limitter.RequestTracker) error {
+		if tracker.Exp > 0 && tracker.Exp < before.UnixMilli() {
+			expired = append(expired, tracker)
+		}
+		return nil
+	})
+	if errScan != nil {
+		return 0, errScan
+	}
+	for i, tracker := range expired {
+		if errReset := backend.ResetTracker(ctx, tracker.UID, tracker.URL); errReset != nil {
+			return i, errReset
+		}
+	}
+	return len(expired), nil
+}
+
+type datastoreBackend struct {
+	*limitter.DatastoreTrackerStore
+}
+
+func (backend datastoreBackend) PurgeExpired(ctx context.Context, before time.Time) (int, error) {
+	ctx = limitter.WithTrackerNamespace(ctx, backend.Namespace)
+	deleted, errPurge := limitter.PurgeExpiredTrackers(ctx, backend.Client, backend.Kind, before)
+	if errPurge != nil {
+		return deleted, errPurge
+	}
+	shardDeleted, errPurge := limitter.PurgeExpiredTrackers(ctx, backend.Client, limitter.CreateShardKind(backend.Kind), before)
+	return deleted + shardDeleted, errPurge
+}
+
+type options struct {
+	backend       string
+	redisAddress  string
+	redisPassword string
+	redisDatabase int
+	keyPrefix     string
+	environment   string
+	projectId     string
+	kind          string
+	namespace     string
+	codec         string
+	groupByUser   bool
+}
+
+func parseOptions(args []string, output io.Writer) (*options, []string, error) {
+	opts := &options{}
+	flags := flag.NewFlagSet("limitterctl", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.StringVar(&opts.backend, "backend", "redis", "Backend of trackers: redis or datastore")
+	flags.StringVar(&opts.redisAddress, "redis-addr", "127.0.0.1:6379", "Redis address")
+	flags.StringVar(&opts.redisPassword, "redis-password", "", "Redis password")
+	flags.IntVar(&opts.redisDatabase, "redis-db", 0, "Redis database")
+	flags.StringVar(&opts.keyPrefix, "prefix", "", "Key prefix of redis trackers, as given to InitRedis")
+	flags.StringVar(&opts.environment, "env", "", "Environment of redis trackers, as given to InitRedis")
+	flags.StringVar(&opts.projectId, "project", os.Getenv("DATASTORE_PROJECT_ID"), "Datastore project id")
+	flags.StringVar(&opts.kind, "kind", "tracker", "Datastore kind of trackers")
+	flags.StringVar(&opts.namespace, "namespace", "", "Datastore namespace of trackers")
+	flags.StringVar(&opts.codec, "codec", "hash", "Datastore key codec: hash or escape")
+	flags.BoolVar(&opts.groupByUser, "group-by-user", false, "Datastore trackers are children of user keys")
+	if errParse := flags.Parse(args); errParse != nil {
+		return nil, nil, errParse
+	}
+	return opts, flags.Args(), nil
+}
+
+func createBackend(ctx context.Context, opts *options) (trackerBackend, error) {
+	switch opts.backend {
+	case "redis":
+		limitter.InitRedis(opts.keyPrefix, opts.environment, opts.redisAddress, opts.redisPassword, opts.redisDatabase)
+		return redisBackend{limitter.NewRedisTrackerStore(nil)}, nil
+	case "datastore":
+		client, errClient := datastore.NewClient(ctx, opts.projectId)
+		if errClient != nil {
+			return nil, errClient
+		}
+		store := limitter.NewDatastoreTrackerStore(client, opts.kind)
+		store.Namespace = opts.namespace
+		store.GroupByUser = opts.groupByUser
+		if opts.codec == "escape" {
+			store.KeyCodec = limitter.EscapeTrackerKeyCodec{}
+		}
+		return datastoreBackend{store}, nil
+	}
+	return nil, fmt.Errorf("unknown backend %v", opts.backend)
+}
+
+// collectTrackers returns trackers of userId, all trackers if userId is empty
+func collectTrackers(ctx context.Context, backend trackerBackend, userId string) ([]*limitter.RequestTracker, error) {
+	if len(userId) > 0 {
+		return backend.GetUserTrackers(ctx, userId)
+	}
+	trackers := []*limitter.RequestTracker{}
+	errScan := backend.ForEachTracker(ctx, func(tracker *limitter.RequestTracker) error {
+		trackers = append(trackers, tracker)
+		return nil
+	})
+	return trackers, errScan
+}
+
+// userRequests are requests, violations and bans of a user over all of its trackers
+type userRequests struct {
+	UID string `json:"uid"`
+	//Requests of all trackers in current window
+	Requests int64 `json:"requests"`
+	//Throttled is number of trackers reached max requests
+	Throttled int64 `json:"throttled"`
+	//Violations of all trackers
+	Violations int64 `json:"violations"`
+	//Banned is number of trackers banned at current time
+	Banned int64 `json:"banned"`
+	//BanLevel is the highest ban level of trackers
+	BanLevel int64 `json:"banLevel"`
+}
+
+/*
+topUsers returns n users with most banned trackers, then highest ban level, most violations, most throttled trackers and most requests.
+Requests are counted only of trackers of current window if windowMilis is given, violations and bans of all trackers.
+A tracker is throttled if it has maxRequest requests or more, maxRequest 0 means no tracker is throttled
+*/
+func topUsers(trackers []*limitter.RequestTracker, n int, currentTime time.Time, windowMilis int64, maxRequest int64) []*userRequests {
+	users := map[string]*userRequests{}
+	for _, tracker := range trackers {
+		isCurrentWindow := windowMilis <= 0 || tracker.WindowNum == currentTime.UnixMilli()/windowMilis
+		isBanned := tracker.IsBanned(currentTime)
+		if !isCurrentWindow && !isBanned && tracker.Violations == 0 {
+			continue
+		}
+		user, found := users[tracker.UID]
+		if !found {
+			user = &userRequests{UID: tracker.UID}
+			users[tracker.UID] = user
+		}
+		user.Violations += tracker.Violations
+		if isBanned {
+			user.Banned += 1
+		}
+		if tracker.BanLevel > user.BanLevel {
+			user.BanLevel = tracker.BanLevel
+		}
+		if !isCurrentWindow {
+			continue
+		}
+		user.Requests += tracker.WindowRequest
+		if maxRequest > 0 && tracker.WindowRequest >= maxRequest {
+			user.Throttled += 1
+		}
+	}
+
+	top := make([]*userRequests, 0, len(users))
+	for _, user := range users {
+		top = append(top, user)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Banned != top[j].Banned {
+			return top[i].Banned > top[j].Banned
+		}
+		if top[i].BanLevel != top[j].BanLevel {
+			return top[i].BanLevel > top[j].BanLevel
+		}
+		if top[i].Violations != top[j].Violations {
+			return top[i].Violations > top[j].Violations
+		}
+		if top[i].Throttled != top[j].Throttled {
+			return top[i].Throttled > top[j].Throttled
+		}
+		if top[i].Requests != top[j].Requests {
+			return top[i].Requests > top[j].Requests
+		}
+		return top[i].UID < top[j].UID
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+var csvHeader []string = []string{"uid", "url", "winNum", "winReq", "last", "exp", "vio", "vioStart", "banLvl", "banUntil", "rules"}
+
+// exportTrackers writes trackers as JSON lines or CSV with header
+func exportTrackers(output io.Writer, format string, trackers []*limitter.RequestTracker) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(output)
+		for _, tracker := range trackers {
+			if errEncode := encoder.Encode(tracker); errEncode != nil {
+				return errEncode
+			}
+		}
+		return nil
+	case "csv":
+		writer := csv.NewWriter(output)
+		writer.Write(csvHeader)
+		for _, tracker := range trackers {
+			writer.Write([]string{
+				tracker.UID,
+				tracker.URL,
+				strconv.FormatInt(tracker.WindowNum, 10),
+				strconv.FormatInt(tracker.WindowRequest, 10),
+				strconv.FormatInt(tracker.LastCall, 10),
+				strconv.FormatInt(tracker.Exp, 10),
+				strconv.FormatInt(tracker.Violations, 10),
+				strconv.FormatInt(tracker.ViolationStart, 10),
+				strconv.FormatInt(tracker.BanLevel, 10),
+				strconv.FormatInt(tracker.BanUntil, 10),
+				tracker.RuleWindows,
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return fmt.Errorf("unknown format %v", format)
+}
+
+func printTrackers(output io.Writer, trackers []*limitter.RequestTracker) {
+	for _, tracker := range trackers {
+		fmt.Fprintf(output, "uid=%v url=%v window=%v/%v last=%v exp=%v\n",
+			tracker.UID, tracker.URL, tracker.WindowRequest, tracker.WindowNum,
+			time.UnixMilli(tracker.LastCall).Format(time.RFC3339), time.UnixMilli(tracker.Exp).Format(time.RFC3339))
+	}
+}
+
+// ReplayAccessLogs replays requests of logs against config and prints n most throttled users and routes, all if n is 0
+func ReplayAccessLogs(output io.Writer, config *limitter.LimitterConfig, parser *limittersim.AccessLogParser, logs []io.Reader, n int) error {
+	requests := []limittersim.Request{}
+	invalid := 0
+	for _, log := range logs {
+		logRequests, logInvalid, errParse := parser.Parse(log)
+		if errParse != nil {
+			return errParse
+		}
+		requests = append(requests, logRequests...)
+		invalid += logInvalid
+	}
+
+	report := limittersim.Replay(nil, config, requests, 0)
+	fmt.Fprintf(output, "requests=%v accepted=%v rejected=%v invalidLines=%v maxAcceptedPerWindow=%v burstRatio=%.2f\n",
+		report.Requests, report.Accepted, report.Rejected, invalid, report.MaxAcceptedPerWindow, report.BurstRatio)
+	for i, throttle := range report.Throttles {
+		if n > 0 && i >= n {
+			break
+		}
+		fmt.Fprintf(output, "throttled uid=%v url=%v requests=%v rejected=%v first=%v last=%v\n",
+			throttle.UserId, throttle.URL, throttle.Requests, throttle.Rejected,
+			throttle.First.Format(time.RFC3339), throttle.Last.Format(time.RFC3339))
+	}
+	for i, route := range report.Routes {
+		if n > 0 && i >= n {
+			break
+		}
+		fmt.Fprintf(output, "route url=%v requests=%v rejected=%v users=%v\n", route.URL, route.Requests, route.Rejected, route.Users)
+	}
+	return nil
+}
+
+// replayAccessLogFiles replays logs of paths against policy of policyPath, stdin if there is no path
+func replayAccessLogFiles(output io.Writer, policyPath string, parser *limittersim.AccessLogParser, paths []string, n int) error {
+	if len(policyPath) == 0 {
+		return fmt.Errorf("replay needs -policy")
+	}
+	config, errConfig := limitter.LoadLimitterConfigFile(policyPath)
+	if errConfig != nil {
+		return errConfig
+	}
+	logs := []io.Reader{}
+	if len(paths) == 0 {
+		logs = append(logs, os.Stdin)
+	}
+	for _, path := range paths {
+		file, errOpen := os.Open(path)
+		if errOpen != nil {
+			return errOpen
+		}
+		defer file.Close()
+		logs = append(logs, file)
+	}
+	return ReplayAccessLogs(output, config, parser, logs, n)
+}
+
+func run(ctx context.Context, args []string, output io.Writer) error {
+	opts, commandArgs, errOptions := parseOptions(args, output)
+	if errOptions != nil {
+		return errOptions
+	}
+	if len(commandArgs) == 0 {
+		return fmt.Errorf("missing command: list, get, reset, purge, top, export or replay")
+	}
+
+	command := commandArgs[0]
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(output)
+	userId := flags.String("user", "", "User id")
+	url := flags.String("url", "", "Request url")
+	before := flags.String("before", "", "Expiration time in RFC3339, now if empty")
+	n := flags.Int("n", 10, "Number of users")
+	window := flags.Int64("window", 0, "Window size in milisecs of policy, 0 counts all windows")
+	maxRequest := flags.Int64("max", 0, "Max requests per window of policy")
+	format := flags.String("format", "json", "Export format: json or csv")
+	policy := flags.String("policy", "", "JSON file of policy to replay logs against")
+	parser := limittersim.NewAccessLogParser(limittersim.LOG_FORMAT_COMBINED)
+	flags.StringVar(&parser.Format, "log-format", limittersim.LOG_FORMAT_COMBINED, "Access log format: combined or json")
+	flags.StringVar(&parser.UserField, "user-field", "", "JSON field of user id, common names if empty")
+	flags.StringVar(&parser.TimeField, "time-field", "", "JSON field of request time, common names if empty")
+	flags.StringVar(&parser.PathField, "path-field", "", "JSON field of request path, common names if empty")
+	if errParse := flags.Parse(commandArgs[1:]); errParse != nil {
+		return errParse
+	}
+	if command == "replay" {
+		return replayAccessLogFiles(output, *policy, parser, flags.Args(), *n)
+	}
+
+	backend, errBackend := createBackend(ctx, opts)
+	if errBackend != nil {
+		return errBackend
+	}
+
+	switch command {
+	case "list":
+		trackers, err := collectTrackers(ctx, backend, *userId)
+		if err != nil {
+			return err
+		}
+		printTrackers(output, trackers)
+	case "get":
+		if len(*userId) == 0 || len(*url) == 0 {
+			return fmt.Errorf("get needs -user and -url")
+		}
+		tracker, err := backend.GetTracker(ctx, *userId, *url)
+		if err != nil {
+			return err
+		}
+		printTrackers(output, []*limitter.RequestTracker{tracker})
+	case "reset":
+		if len(*userId) == 0 {
+			return fmt.Errorf("reset needs -user")
+		}
+		deleted := 1
+		var err error
+		if len(*url) > 0 {
+			err = backend.ResetTracker(ctx, *userId, *url)
+		} else {
+			deleted, err = backend.ResetUserTrackers(ctx, *userId)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(output, "deleted=%v\n", deleted)
+	case "purge":
+		beforeTime := time.Now()
+		if len(*before) > 0 {
+			var errTime error
+			if beforeTime, errTime = time.Parse(time.RFC3339, *before); errTime != nil {
+				return errTime
+			}
+		}
+		deleted, err := backend.PurgeExpired(ctx, beforeTime)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(output, "deleted=%v\n", deleted)
+	case "top":
+		trackers, err := collectTrackers(ctx, backend, "")
+		if err != nil {
+			return err
+		}
+		for _, user := range topUsers(trackers, *n, time.Now(), *window, *maxRequest) {
+			fmt.Fprintf(output, "uid=%v banned=%v banLvl=%v vio=%v requests=%v throttled=%v\n",
+				user.UID, user.Banned, user.BanLevel, user.Violations, user.Requests, user.Throttled)
+		}
+	case "export":
+		trackers, err := collectTrackers(ctx, backend, *userId)
+		if err != nil {
+			return err
+		}
+		return exportTrackers(output, *format, trackers)
+	default:
+		return fmt.Errorf("unknown command %v", command)
+	}
+	return nil
+}
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "limitterctl: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	limitter "github.com/zeroboo/gin-request-limitter"
+	"github.com/zeroboo/gin-request-limitter/limittersim"
+)
+
+var testTrackers []*limitter.RequestTracker = []*limitter.RequestTracker{
+	{UID: "a", URL: "/health", WindowNum: 10, WindowRequest: 3, LastCall: 10500, Exp: 20000},
+	{UID: "a", URL: "/items", WindowNum: 10, WindowRequest: 5, LastCall: 10600, Exp: 20000},
+	{UID: "b", URL: "/health", WindowNum: 10, WindowRequest: 5, LastCall: 10700, Exp: 20000},
+	{UID: "c", URL: "/health", WindowNum: 9, WindowRequest: 50, LastCall: 9900, Exp: 20000},
+}
+
+// go test -timeout 30s -run ^TestTopUsers_CurrentWindow_ThrottledFirst$ github.com/zeroboo/gin-request-limitter/cmd/limitterctl -v
+func TestTopUsers_CurrentWindow_ThrottledFirst(t *testing.T) {
+	top := topUsers(testTrackers, 2, time.UnixMilli(10800), 1000, 5)
+
+	assert.Equal(t, 2, len(top), "Top n users")
+	assert.Equal(t, &userRequests{UID: "a", Requests: 8, Throttled: 1}, top[0], "Throttled user with most requests first")
+	assert.Equal(t, &userRequests{UID: "b", Requests: 5, Throttled: 1}, top[1], "Tracker of previous window not counted")
+}
+
+// go test -timeout 30s -run ^TestTopUsers_NoWindow_AllCounted$ github.com/zeroboo/gin-request-limitter/cmd/limitterctl -v
+func TestTopUsers_NoWindow_AllCounted(t *testing.T) {
+	top := topUsers(testTrackers, 0, time.UnixMilli(10800), 0, 0)
+
+	assert.Equal(t, 3, len(top), "All users")
+	assert.Equal(t, "c", top[0].UID, "User with most requests first")
+}
+
+// go test -timeout 30s -run ^TestTopUsers_BannedAndViolations_First$ github.com/zeroboo/gin-request-limitter/cmd/limitterctl -v
+func TestTopUsers_BannedAndViolations_First(t *testing.T) {
+	violator := limitter.RequestTracker{UID: "v", URL: "/health", WindowNum: 10, WindowRequest: 1, Violations: 3, Exp: 20000}
+	banned := limitter.RequestTracker{UID: "x", URL: "/health", WindowNum: 2, WindowRequest: 1, BanLevel: 1, BanUntil: 20000, Exp: 20000}
+	trackers := append([]*limitter.RequestTracker{&violator, &banned}, testTrackers...)
+
+	top := topUsers(trackers, 3, time.UnixMilli(10800), 1000, 5)
+
+	assert.Equal(t, &userRequests{UID: "x", Banned: 1, BanLevel: 1}, top[0], "Banned user first, even without requests in current window")
+	assert.Equal(t, &userRequests{UID: "v", Requests: 1, Violations: 3}, top[1], "User with violations next")
+	assert.Equal(t, "a", top[2].UID, "Throttled user after users with bans and violations")
+}
+
+// go test -timeout 30s -run ^TestExportTrackers_Csv_HeaderAndRows$ github.com/zeroboo/gin-request-limitter/cmd/limitterctl -v
+func TestExportTrackers_Csv_HeaderAndRows(t *testing.T) {
+	output := &bytes.Buffer{}
+	banned := *testTrackers[1]
+	banned.Violations = 4
+	banned.BanLevel = 2
+	banned.BanUntil = 30000
+	banned.ViolationStart = 10100
+	banned.RuleWindows = "60000:0:5"
+	err := exportTrackers(output, "csv", []*limitter.RequestTracker{testTrackers[0], &banned})
+
+	assert.Nil(t, err, "Export success")
+	assert.Equal(t, "uid,url,winNum,winReq,last,exp,vio,vioStart,banLvl,banUntil,rules\n"+
+		"a,/health,10,3,10500,20000,0,0,0,0,\n"+
+		"a,/items,10,5,10600,20000,4,10100,2,30000,60000:0:5\n", output.String(), "Csv rows with bans and rule windows")
+}
+
+// go test -timeout 30s -run ^TestExportTrackers_Json_OneTrackerPerLine$ github.com/zeroboo/gin-request-limitter/cmd/limitterctl -v
+func TestExportTrackers_Json_OneTrackerPerLine(t *testing.T) {
+	output := &bytes.Buffer{}
+	err := exportTrackers(output, "json", testTrackers[:2])
+
+	assert.Nil(t, err, "Export success")
+	assert.Equal(t, `{"uid":"a","url":"/health","winNum":10,"winReq":3,"last":10500,"exp":20000}`+"\n"+
+		`{"uid":"a","url":"/items","winNum":10,"winReq":5,"last":10600,"exp":20000}`+"\n", output.String(), "Json lines")
+	assert.NotNil(t, exportTrackers(output, "xml", testTrackers), "Unknown format")
+}
+
+// go test -timeout 30s -run ^TestReplayAccessLogs_PrintsThrottles$ github.com/zeroboo/gin-request-limitter/cmd/limitterctl -v
+func TestReplayAccessLogs_PrintsThrottles(t *testing.T) {
+	config := &limitter.LimitterConfig{WindowSize: 60000, MaxRequestPerWindow: 1, ExpSec: 600}
+	log := `10.0.0.1 - a [01/Jan/2024:00:00:01 +0000] "GET /health HTTP/1.1" 200 2 "-" "-"
+10.0.0.1 - a [01/Jan/2024:00:00:02 +0000] "GET /health HTTP/1.1" 200 2 "-" "-"
+10.0.0.1 - a [01/Jan/2024:00:00:03 +0000] "GET /health HTTP/1.1" 200 2 "-" "-"
+garbage
+`
+	output := &bytes.Buffer{}
+	err := ReplayAccessLogs(output, config, limittersim.NewAccessLogParser(limittersim.LOG_FORMAT_COMBINED),
+		[]io.Reader{strings.NewReader(log)}, 10)
+
+	assert.Nil(t, err, "Replayed")
+	assert.Equal(t, "requests=3 accepted=1 rejected=2 invalidLines=1 maxAcceptedPerWindow=1 burstRatio=1.00\n"+
+		"throttled uid=a url=/health requests=3 rejected=2 first=2024-01-01T00:00:02Z last=2024-01-01T00:00:03Z\n"+
+		"route url=/health requests=3 rejected=2 users=1\n", output.String(), "Report")
+}
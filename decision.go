@@ -0,0 +1,156 @@
+/*
+Decision engine of limitters, independent of http frameworks. Gin and net/http limitters are adapters over it
+*/
+
+package limitter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Decision is outcome of a request decided by a limitter
+type Decision struct {
+	//Err is nil if request is served, a rejection or a backend failure with AbortOnFail otherwise
+	Err error
+
+	//Tracker of request after deciding, nil if store was not called or failed
+	Tracker *RequestTracker
+
+	//Time request was decided at
+	Time time.Time
+
+	//Header is response headers telling client about decision: retry time, ban, rule and shadow decisions
+	Header http.Header
+}
+
+// IsAllowed returns true if request is served
+func (decision *Decision) IsAllowed() bool {
+	return decision.Err == nil
+}
+
+// GetStatus returns http status of response of a rejected request, http.StatusOK if request is served
+func (decision *Decision) GetStatus() int {
+	return GetRejectionStatus(decision.Err)
+}
+
+// GetRetryAfter returns time client should wait before retrying a rejected request: until its ban or its rule ends, 0 if unknown
+func (decision *Decision) GetRetryAfter() time.Duration {
+	retryTime := int64(0)
+	var errLimit *RuleLimitError
+	if errors.Is(decision.Err, ErrorRequestBanned) && decision.Tracker != nil {
+		retryTime = decision.Tracker.BanUntil
+	} else if errors.As(decision.Err, &errLimit) {
+		retryTime = errLimit.RetryTime
+	}
+	if retryTime <= decision.Time.UnixMilli() {
+		return 0
+	}
+	return time.Duration(retryTime-decision.Time.UnixMilli()) * time.Millisecond
+}
+
+/*
+GetRejectionStatus returns http status of a validate error: http.StatusTooEarly for too fast requests,
+http.StatusTooManyRequests for full windows and levels, http.StatusForbidden for bans and denials,
+http.StatusInternalServerError for failures. Nil error is http.StatusOK
+*/
+func GetRejectionStatus(validateError error) int {
+	if validateError == nil {
+		return http.StatusOK
+	} else if errors.Is(validateError, ErrorRequestTooFast) {
+		return http.StatusTooEarly
+	} else if errors.Is(validateError, ErrorRequestTooFreequently) || errors.Is(validateError, ErrorLevelLimitExceeded) {
+		return http.StatusTooManyRequests
+	} else if errors.Is(validateError, ErrorRequestBanned) || errors.Is(validateError, ErrorRequestDenied) {
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// Limitter decides requests by a policy and trackers persisted in a store
+type Limitter struct {
+	Store  TrackerStore
+	Config *LimitterConfig
+}
+
+func NewLimitter(store TrackerStore, config *LimitterConfig) *Limitter {
+	return &Limitter{
+		Store:  store,
+		Config: config,
+	}
+}
+
+/*
+Decide decides a request of userId to url from clientIP with header.
+
+Requests allowed by config.AccessList are served and denied ones are rejected without calling store.
+Banned requests get ban headers and requests rejected by a limit get the most restrictive rule and its retry time.
+If store fails, request is rejected with the failure when config.AbortOnFail is true, served otherwise.
+In shadow mode, rejections and denials are recorded and requests are served, no violation or ban is saved.
+Candidate policy decisions are only recorded.
+*/
+func (limitter *Limitter) Decide(ctx context.Context, userId string, url string, clientIP string, header http.Header) *Decision {
+	decision := &Decision{Header: http.Header{}}
+	if limitter.Config.AccessList != nil {
+		switch limitter.Config.AccessList.Check(clientIP, userId, header) {
+		case ACCESS_RESULT_ALLOW:
+			decision.Time = limitter.Config.Now()
+			return decision
+		case ACCESS_RESULT_DENY:
+			decision.Time = limitter.Config.Now()
+			if limitter.Config.Shadow {
+				limitter.Config.Decisions.record(true, false, false)
+				decision.Header.Set(HeaderDecision, DECISION_REJECT)
+				log.Infof("RequestLimitter: ShadowDenied, userId=%v, url=%v, IP=%v", userId, url, clientIP)
+				return decision
+			}
+			log.Debugf("RequestLimitter: Denied, userId=%v, url=%v, IP=%v", userId, url, clientIP)
+			decision.Err = ErrorRequestDenied
+			return decision
+		}
+	}
+	currentTime := limitter.Config.Now()
+	config := limitter.Config.GetUserConfig(userId, currentTime)
+	decision.Time = currentTime
+
+	candidateEvaluated := false
+	var errCandidate error
+	tracker, errUpdate := limitter.Store.UpdateTracker(ctx, userId, url, config, func(tracker *RequestTracker) error {
+		if config.Candidate != nil {
+			candidateEvaluated = true
+			errCandidate = checkCandidatePolicy(tracker, currentTime, config)
+		}
+		return ValidateRequest(tracker, currentTime, url, clientIP, config)
+	})
+	if errUpdate != nil && !IsValidateError(errUpdate) {
+		log.Errorf("RequestLimitter: UpdateTrackerFailed, userId=%v, url=%v, abortOnFail=%v, error=%v",
+			userId, url, config.AbortOnFail, errUpdate)
+		if !config.AbortOnFail {
+			errUpdate = nil
+		}
+	} else {
+		errUpdate = recordDecision(decision.Header, config, userId, url, clientIP, errUpdate, candidateEvaluated, errCandidate)
+	}
+	if tracker != nil && errors.Is(errUpdate, ErrorRequestBanned) {
+		WriteBanHeaders(decision.Header, tracker, currentTime)
+	}
+	WriteRuleHeaders(decision.Header, errUpdate, currentTime)
+	decision.Err = errUpdate
+	decision.Tracker = tracker
+
+	if tracker != nil && log.IsLevelEnabled(log.TraceLevel) {
+		log.Tracef("RequestLimitter: ValidateFinish, UID=%v, url=%v, IP=%v, calls=%v|%v, window=%v/%v|%v, errValidate=%v",
+			tracker.UID,
+			url,
+			clientIP,
+			currentTime.UnixMilli()-tracker.LastCall, config.MinRequestInterval,
+			tracker.WindowRequest, config.MaxRequestPerWindow, tracker.WindowNum,
+			errUpdate,
+		)
+	}
+	return decision
+}
@@ -0,0 +1,23 @@
+package limitter
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// RequireTestDatastore exposes datastore client of tests to package limitter_test, skips test if there is none
+func RequireTestDatastore(t testing.TB) *datastore.Client {
+	return requireDatastore(t)
+}
+
+// TestDatastoreKind is kind of trackers of datastore tests
+const TestDatastoreKind string = DatastoreKindRequestTracker
+
+// ExpireTestRedis moves time of miniredis of tests forward so keys expire, it does nothing on a real redis
+func ExpireTestRedis(duration time.Duration) {
+	if testMiniRedis != nil {
+		testMiniRedis.FastForward(duration)
+	}
+}
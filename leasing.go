@@ -0,0 +1,457 @@
+/*
+Quota leasing: each instance leases chunks of window allowance of a key from a shared store and serves them locally
+*/
+
+package limitter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultMinLeaseSize int64 = 1
+const DefaultMaxLeaseSize int64 = 100
+const DefaultLeaseDurationMilis int64 = 1000
+
+// errLeaseOutdated tells wrapped store not to save a release of a lease whose window is over
+var errLeaseOutdated = fmt.Errorf("lease outdated")
+
+// LeasingStats are counters of a leasing store since it was created
+type LeasingStats struct {
+	//Acquisitions is number of calls to wrapped store leasing a chunk
+	Acquisitions int64
+	//LocalRequests is number of requests served from leases without calling wrapped store
+	LocalRequests int64
+	//Returned is number of leased requests returned unused to wrapped store
+	Returned int64
+	//Leases is current number of keys holding a lease
+	Leases int
+}
+
+type quotaLease struct {
+	mutex sync.Mutex
+	key   trackerCacheKey
+
+	//tracker is tracker of last acquisition updated by local requests, its window requests are MaxRequestPerWindow minus remaining
+	tracker RequestTracker
+	config  *LimitterConfig
+
+	//windowNum is window the lease is in, remaining is requests of it not served yet
+	windowNum int64
+	remaining int64
+
+	//size is number of requests of next acquisition
+	size int64
+
+	//expireAt is end of lease, unix milisec. Unused requests are returned after it
+	expireAt int64
+
+	//removed lease is not in store anymore and must not be used
+	removed bool
+}
+
+/*
+LeasingTrackerStore wraps a shared store and leases chunks of window allowance of a key from it.
+A request of a key without lease calls wrapped store once, which counts the request and leases up to size-1 more requests of its window.
+Following requests are served from the lease and only update a local tracker, until the lease is used up, its window ends or it expires.
+
+Lease size adapts to traffic of a key: it doubles when a lease is used up before it expires and halves when requests of it are returned.
+Unused requests of expired leases are returned to wrapped store in background every LeaseDuration, so other instances can use them.
+
+Limits are kept across instances but leased requests count in shared tracker before they are served, so a key hitting
+several instances can be rejected early by up to MaxLeaseSize requests per instance until leases expire.
+Min request interval is checked per instance. Policies banning keys, with rules, candidates or level limits are passed to wrapped store as is
+*/
+type LeasingTrackerStore struct {
+	Store TrackerStore
+
+	//MinLeaseSize is size of first lease of a key and min size of next ones
+	MinLeaseSize int64
+
+	//MaxLeaseSize is max size of a lease
+	MaxLeaseSize int64
+
+	//LeaseDuration is time in milisecs a lease is served before unused requests are returned
+	LeaseDuration int64
+
+	//mutex guards leases, it is locked before mutex of a lease and never while holding one
+	mutex      sync.Mutex
+	leases     map[trackerCacheKey]*quotaLease
+	statsMutex sync.Mutex
+	stats      LeasingStats
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// NewLeasingTrackerStore returns a store leasing from store and starts returning expired leases, Close must be called to stop it
+func NewLeasingTrackerStore(store TrackerStore, minLeaseSize int64, maxLeaseSize int64, leaseDurationMilis int64) *LeasingTrackerStore {
+	if minLeaseSize <= 0 {
+		minLeaseSize = DefaultMinLeaseSize
+	}
+	if maxLeaseSize <= 0 {
+		maxLeaseSize = DefaultMaxLeaseSize
+	}
+	if maxLeaseSize < minLeaseSize {
+		maxLeaseSize = minLeaseSize
+	}
+	if leaseDurationMilis <= 0 {
+		leaseDurationMilis = DefaultLeaseDurationMilis
+	}
+	leasing := &LeasingTrackerStore{
+		Store:         store,
+		MinLeaseSize:  minLeaseSize,
+		MaxLeaseSize:  maxLeaseSize,
+		LeaseDuration: leaseDurationMilis,
+		leases:        map[trackerCacheKey]*quotaLease{},
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go leasing.runReleaseLoop()
+	return leasing
+}
+
+func (leasing *LeasingTrackerStore) runReleaseLoop() {
+	defer close(leasing.done)
+	ticker := time.NewTicker(time.Duration(leasing.LeaseDuration) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-leasing.stop:
+			return
+		case <-ticker.C:
+			leasing.ReleaseExpiredLeases(context.Background())
+		}
+	}
+}
+
+// Close stops returning expired leases and returns unused requests of all leases
+func (leasing *LeasingTrackerStore) Close(ctx context.Context) error {
+	close(leasing.stop)
+	<-leasing.done
+
+	var errRelease error
+	for _, lease := range leasing.removeLeases(func(lease *quotaLease) bool { return true }) {
+		lease.mutex.Lock()
+		lease.removed = true
+		if lease.config != nil {
+			if errLease := leasing.release(ctx, lease, lease.config.Now()); errLease != nil {
+				errRelease = errLease
+			}
+		}
+		lease.mutex.Unlock()
+	}
+	return errRelease
+}
+
+// GetStats returns a snapshot of leasing counters
+func (leasing *LeasingTrackerStore) GetStats() LeasingStats {
+	leasing.statsMutex.Lock()
+	stats := leasing.stats
+	leasing.statsMutex.Unlock()
+	leasing.mutex.Lock()
+	defer leasing.mutex.Unlock()
+	stats.Leases = len(leasing.leases)
+	return stats
+}
+
+// IsLeasable returns true if requests of config can be served from leases, false if they are passed to wrapped store
+func IsLeasable(config *LimitterConfig) bool {
+	return config.WindowSize > 0 && config.MaxRequestPerWindow > 0 &&
+		!config.IsBanEnabled() && len(config.Rules) == 0 && config.Candidate == nil && !config.HasLevelLimits()
+}
+
+func (leasing *LeasingTrackerStore) getLease(key trackerCacheKey) *quotaLease {
+	leasing.mutex.Lock()
+	defer leasing.mutex.Unlock()
+	lease, found := leasing.leases[key]
+	if !found {
+		lease = &quotaLease{key: key, size: leasing.MinLeaseSize}
+		leasing.leases[key] = lease
+	}
+	return lease
+}
+
+// removeLeases removes leases matched by filter from store and returns them
+func (leasing *LeasingTrackerStore) removeLeases(filter func(lease *quotaLease) bool) []*quotaLease {
+	leasing.mutex.Lock()
+	defer leasing.mutex.Unlock()
+	removed := []*quotaLease{}
+	for key, lease := range leasing.leases {
+		if filter(lease) {
+			delete(leasing.leases, key)
+			removed = append(removed, lease)
+		}
+	}
+	return removed
+}
+
+func (leasing *LeasingTrackerStore) count(update func(stats *LeasingStats)) {
+	leasing.statsMutex.Lock()
+	defer leasing.statsMutex.Unlock()
+	update(&leasing.stats)
+}
+
+// isServable returns true if lease has requests left in window of currentTime for config
+func (lease *quotaLease) isServable(currentTime time.Time, config *LimitterConfig) bool {
+	return lease.remaining > 0 &&
+		currentTime.UnixMilli() < lease.expireAt &&
+		lease.windowNum == currentTime.UnixMilli()/config.WindowSize &&
+		lease.config != nil &&
+		lease.config.WindowSize == config.WindowSize &&
+		lease.config.MaxRequestPerWindow == config.MaxRequestPerWindow
+}
+
+// getUnused returns requests of lease to return to wrapped store, 0 if its window is over
+func (lease *quotaLease) getUnused(currentTime time.Time) int64 {
+	if lease.config == nil || lease.windowNum != currentTime.UnixMilli()/lease.config.WindowSize {
+		return 0
+	}
+	return lease.remaining
+}
+
+// getAdaptedSize returns size of a lease halved if it has requests left and doubled if it was used up before it expires.
+// Lease takes it only after wrapped store accepted the call it was adapted for
+func (leasing *LeasingTrackerStore) getAdaptedSize(lease *quotaLease, currentTime time.Time) int64 {
+	if lease.config == nil {
+		return lease.size
+	}
+	if lease.remaining > 0 {
+		return maxInt64(lease.size/2, leasing.MinLeaseSize)
+	} else if currentTime.UnixMilli() < lease.expireAt {
+		return minInt64(lease.size*2, leasing.MaxLeaseSize)
+	}
+	return lease.size
+}
+
+/*
+UpdateTracker serves request from lease of key in namespace of context if it has requests left, otherwise calls wrapped store once to return unused
+requests of lease, count request and lease a new chunk. Requests of policies not leasable are passed to wrapped store
+*/
+func (leasing *LeasingTrackerStore) UpdateTracker(ctx context.Context, userId string, url string, config *LimitterConfig,
+	validate func(tracker *RequestTracker) error) (*RequestTracker, error) {
+	if !IsLeasable(config) {
+		return leasing.Store.UpdateTracker(ctx, userId, url, config, validate)
+	}
+	if errCtx := ctx.Err(); errCtx != nil {
+		return nil, errCtx
+	}
+
+	key := createTrackerCacheKey(ctx, userId, url)
+	lease := leasing.getLease(key)
+	lease.mutex.Lock()
+	for lease.removed {
+		lease.mutex.Unlock()
+		lease = leasing.getLease(key)
+		lease.mutex.Lock()
+	}
+	defer lease.mutex.Unlock()
+
+	currentTime := config.Now()
+	if lease.isServable(currentTime, config) {
+		tracker := lease.tracker
+		tracker.WindowRequest = config.MaxRequestPerWindow - lease.remaining
+		errValidate := validate(&tracker)
+		if errValidate != nil {
+			return &tracker, errValidate
+		}
+		lease.tracker = tracker
+		lease.remaining -= 1
+		leasing.count(func(stats *LeasingStats) { stats.LocalRequests += 1 })
+		return &tracker, nil
+	}
+	return leasing.acquire(ctx, lease, userId, url, config, currentTime, validate)
+}
+
+// acquire returns unused requests of lease, counts request in wrapped store and leases a new chunk of its window
+func (leasing *LeasingTrackerStore) acquire(ctx context.Context, lease *quotaLease, userId string, url string, config *LimitterConfig,
+	currentTime time.Time, validate func(tracker *RequestTracker) error) (*RequestTracker, error) {
+	unused := lease.getUnused(currentTime)
+	size := leasing.getAdaptedSize(lease, currentTime)
+	windowNum := lease.windowNum
+
+	granted := int64(0)
+	tracker, errUpdate := leasing.Store.UpdateTracker(ctx, userId, url, config, func(tracker *RequestTracker) error {
+		granted = 0
+		if unused > 0 && tracker.WindowNum == windowNum {
+			tracker.WindowRequest -= minInt64(unused, tracker.WindowRequest)
+		}
+		if errValidate := validate(tracker); errValidate != nil {
+			return errValidate
+		}
+		granted = maxInt64(minInt64(size-1, config.MaxRequestPerWindow-tracker.WindowRequest), 0)
+		tracker.WindowRequest += granted
+		return nil
+	})
+	leasing.count(func(stats *LeasingStats) { stats.Acquisitions += 1 })
+	//Unused requests are only returned with an accepted request, a rejected one leaves them to be returned later
+	if errUpdate != nil {
+		return tracker, errUpdate
+	}
+	if unused > 0 {
+		leasing.count(func(stats *LeasingStats) { stats.Returned += unused })
+		log.Debugf("LeasingLimitter: Returned, UID=%v, url=%v, requests=%v", userId, url, unused)
+	}
+
+	lease.size = size
+	lease.tracker = *tracker
+	lease.config = config
+	lease.windowNum = tracker.WindowNum
+	lease.remaining = granted
+	lease.expireAt = minInt64(currentTime.UnixMilli()+leasing.LeaseDuration, (tracker.WindowNum+1)*config.WindowSize)
+	return tracker, nil
+}
+
+// release returns unused requests of lease to wrapped store
+func (leasing *LeasingTrackerStore) release(ctx context.Context, lease *quotaLease, currentTime time.Time) error {
+	unused := lease.getUnused(currentTime)
+	lease.remaining = 0
+	if unused == 0 {
+		return nil
+	}
+	windowNum := lease.windowNum
+	_, errUpdate := leasing.Store.UpdateTracker(lease.key.withNamespace(ctx), lease.key.userId, lease.key.url, lease.config, func(tracker *RequestTracker) error {
+		if tracker.WindowNum != windowNum {
+			return errLeaseOutdated
+		}
+		tracker.WindowRequest -= minInt64(unused, tracker.WindowRequest)
+		return nil
+	})
+	if errors.Is(errUpdate, errLeaseOutdated) {
+		return nil
+	}
+	if errUpdate != nil {
+		log.Errorf("LeasingLimitter: ReturnFailed, UID=%v, url=%v, requests=%v, error=%v",
+			lease.key.userId, lease.key.url, unused, errUpdate)
+		return errUpdate
+	}
+	leasing.count(func(stats *LeasingStats) { stats.Returned += unused })
+	return nil
+}
+
+/*
+ReleaseExpiredLeases returns unused requests of expired leases to wrapped store and halves their size.
+Leases not acquired again during a LeaseDuration after they expire are removed. Returns number of requests returned
+*/
+func (leasing *LeasingTrackerStore) ReleaseExpiredLeases(ctx context.Context) int64 {
+	leasing.mutex.Lock()
+	leases := make([]*quotaLease, 0, len(leasing.leases))
+	for _, lease := range leasing.leases {
+		leases = append(leases, lease)
+	}
+	leasing.mutex.Unlock()
+
+	returned := int64(0)
+	for _, lease := range leases {
+		lease.mutex.Lock()
+		if lease.config != nil && !lease.removed {
+			currentTime := lease.config.Now()
+			if currentTime.UnixMilli() >= lease.expireAt {
+				unused := lease.getUnused(currentTime)
+				size := leasing.getAdaptedSize(lease, currentTime)
+				if leasing.release(ctx, lease, currentTime) == nil {
+					lease.size = size
+					returned += unused
+				}
+			}
+		}
+		lease.mutex.Unlock()
+	}
+
+	//A lease locked by a request is in use, it is not waited for while all leases are locked
+	leasing.removeLeases(func(lease *quotaLease) bool {
+		if !lease.mutex.TryLock() {
+			return false
+		}
+		defer lease.mutex.Unlock()
+		if lease.config == nil || lease.remaining > 0 || lease.config.Now().UnixMilli() < lease.expireAt+leasing.LeaseDuration {
+			return false
+		}
+		lease.removed = true
+		return true
+	})
+	return returned
+}
+
+// Remove drops lease of userId and url in namespace of context without returning its unused requests
+func (leasing *LeasingTrackerStore) Remove(ctx context.Context, userId string, url string) {
+	key := createTrackerCacheKey(ctx, userId, url)
+	leasing.mutex.Lock()
+	lease, found := leasing.leases[key]
+	delete(leasing.leases, key)
+	leasing.mutex.Unlock()
+	if found {
+		lease.mutex.Lock()
+		lease.removed = true
+		lease.mutex.Unlock()
+	}
+}
+
+// GetTracker returns tracker from wrapped store, its window requests include requests leased and not served yet
+func (leasing *LeasingTrackerStore) GetTracker(ctx context.Context, userId string, url string) (*RequestTracker, error) {
+	admin, isAdmin := leasing.Store.(TrackerAdmin)
+	if !isAdmin {
+		return nil, ErrorAdminNotSupported
+	}
+	return admin.GetTracker(ctx, userId, url)
+}
+
+// GetUserTrackers returns trackers from wrapped store
+func (leasing *LeasingTrackerStore) GetUserTrackers(ctx context.Context, userId string) ([]*RequestTracker, error) {
+	admin, isAdmin := leasing.Store.(TrackerAdmin)
+	if !isAdmin {
+		return nil, ErrorAdminNotSupported
+	}
+	return admin.GetUserTrackers(ctx, userId)
+}
+
+// ResetTracker resets tracker in wrapped store and drops its lease
+func (leasing *LeasingTrackerStore) ResetTracker(ctx context.Context, userId string, url string) error {
+	admin, isAdmin := leasing.Store.(TrackerAdmin)
+	if !isAdmin {
+		return ErrorAdminNotSupported
+	}
+	leasing.Remove(ctx, userId, url)
+	return admin.ResetTracker(ctx, userId, url)
+}
+
+// ResetUserTrackers resets trackers in wrapped store and drops leases of userId in namespace of context
+func (leasing *LeasingTrackerStore) ResetUserTrackers(ctx context.Context, userId string) (int, error) {
+	admin, isAdmin := leasing.Store.(TrackerAdmin)
+	if !isAdmin {
+		return 0, ErrorAdminNotSupported
+	}
+	leasing.mutex.Lock()
+	leases := []*quotaLease{}
+	for key, lease := range leasing.leases {
+		if key.isUserOf(ctx, userId) {
+			delete(leasing.leases, key)
+			leases = append(leases, lease)
+		}
+	}
+	leasing.mutex.Unlock()
+	for _, lease := range leases {
+		lease.mutex.Lock()
+		lease.removed = true
+		lease.mutex.Unlock()
+	}
+	return admin.ResetUserTrackers(ctx, userId)
+}
+
+func minInt64(a int64, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a int64, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,154 @@
+package limitter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	limitter "github.com/zeroboo/gin-request-limitter"
+	"github.com/zeroboo/gin-request-limitter/clocktest"
+	"github.com/zeroboo/gin-request-limitter/limittertest"
+)
+
+// createLeasingTest returns a policy of maxRequest requests per minute on a fake clock and a shared store of it
+func createLeasingTest(maxRequest int64) (*limitter.LimitterConfig, *clocktest.FakeClock, *limittertest.MemoryStore) {
+	clock := clocktest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	config := &limitter.LimitterConfig{WindowSize: 60000, MaxRequestPerWindow: maxRequest, ExpSec: 600, Clock: clock}
+	store := limittertest.NewMemoryStore()
+	store.Clock = clock
+	return config, clock, store
+}
+
+// createLeasingStore returns a leasing store over store closed at end of test
+func createLeasingStore(t *testing.T, store limitter.TrackerStore, minLeaseSize int64, maxLeaseSize int64) *limitter.LeasingTrackerStore {
+	leasing := limitter.NewLeasingTrackerStore(store, minLeaseSize, maxLeaseSize, 1000)
+	t.Cleanup(func() { leasing.Close(context.Background()) })
+	return leasing
+}
+
+// sendLeasedRequests sends count requests of user and returns number of accepted ones
+func sendLeasedRequests(engine *limitter.Limitter, userId string, count int) int {
+	accepted := 0
+	for i := 0; i < count; i++ {
+		if engine.Decide(context.Background(), userId, "/health", "", nil).IsAllowed() {
+			accepted += 1
+		}
+	}
+	return accepted
+}
+
+// go test -timeout 30s -run ^TestLeasing_Chunk_StoreCalledOncePerChunk$ github.com/zeroboo/gin-request-limitter -v
+func TestLeasing_Chunk_StoreCalledOncePerChunk(t *testing.T) {
+	config, _, store := createLeasingTest(100)
+	leasing := createLeasingStore(t, store, 10, 10)
+
+	accepted := sendLeasedRequests(limitter.NewLimitter(leasing, config), "user", 25)
+
+	assert.Equal(t, 25, accepted, "All requests accepted")
+	assert.Equal(t, 3, store.GetCalls(), "Store called once per 10 requests")
+	stats := leasing.GetStats()
+	assert.Equal(t, int64(3), stats.Acquisitions, "Chunks leased")
+	assert.Equal(t, int64(22), stats.LocalRequests, "Requests served locally")
+	assert.Equal(t, 1, stats.Leases, "Lease of user")
+	tracker, _ := store.GetTracker(context.Background(), "user", "/health")
+	assert.Equal(t, int64(30), tracker.WindowRequest, "Leased requests counted in shared tracker")
+}
+
+// go test -timeout 30s -run ^TestLeasing_Instances_WindowLimitKept$ github.com/zeroboo/gin-request-limitter -v
+func TestLeasing_Instances_WindowLimitKept(t *testing.T) {
+	config, _, store := createLeasingTest(20)
+	first := limitter.NewLimitter(createLeasingStore(t, store, 5, 5), config)
+	second := limitter.NewLimitter(createLeasingStore(t, store, 5, 5), config)
+
+	accepted := 0
+	for i := 0; i < 20; i++ {
+		accepted += sendLeasedRequests(first, "user", 1)
+		accepted += sendLeasedRequests(second, "user", 1)
+	}
+
+	assert.Equal(t, 20, accepted, "Window limit kept across instances")
+	decision := first.Decide(context.Background(), "user", "/health", "", nil)
+	assert.ErrorIs(t, decision.Err, limitter.ErrorRequestTooFreequently, "Window full")
+}
+
+// go test -timeout 30s -run ^TestLeasing_Expired_UnusedReturned$ github.com/zeroboo/gin-request-limitter -v
+func TestLeasing_Expired_UnusedReturned(t *testing.T) {
+	config, clock, store := createLeasingTest(10)
+	leasing := createLeasingStore(t, store, 10, 10)
+	first := limitter.NewLimitter(leasing, config)
+	second := limitter.NewLimitter(createLeasingStore(t, store, 10, 10), config)
+
+	assert.Equal(t, 1, sendLeasedRequests(first, "user", 1), "First instance leased whole window")
+	assert.Equal(t, 0, sendLeasedRequests(second, "user", 1), "Second instance rejected while lease is held")
+
+	clock.Advance(time.Second)
+	returned := leasing.ReleaseExpiredLeases(context.Background())
+
+	assert.Equal(t, int64(9), returned, "Unused requests returned")
+	tracker, _ := store.GetTracker(context.Background(), "user", "/health")
+	assert.Equal(t, int64(1), tracker.WindowRequest, "Only served request counted")
+	assert.Equal(t, 9, sendLeasedRequests(second, "user", 10), "Second instance served returned requests")
+}
+
+// go test -timeout 30s -run ^TestLeasing_Traffic_SizeAdapted$ github.com/zeroboo/gin-request-limitter -v
+func TestLeasing_Traffic_SizeAdapted(t *testing.T) {
+	config, clock, store := createLeasingTest(1000)
+	leasing := createLeasingStore(t, store, 1, 16)
+	engine := limitter.NewLimitter(leasing, config)
+
+	sendLeasedRequests(engine, "user", 1+2+4+8+16)
+	assert.Equal(t, int64(5), leasing.GetStats().Acquisitions, "Size doubled while leases are used up")
+	sendLeasedRequests(engine, "user", 1)
+	assert.Equal(t, int64(6), leasing.GetStats().Acquisitions, "Size kept at max")
+
+	clock.Advance(time.Second)
+	assert.Equal(t, int64(15), leasing.ReleaseExpiredLeases(context.Background()), "Unused requests of last lease returned")
+	sendLeasedRequests(engine, "user", 8)
+	assert.Equal(t, int64(7), leasing.GetStats().Acquisitions, "Size halved after requests were returned")
+	sendLeasedRequests(engine, "user", 1)
+	assert.Equal(t, int64(8), leasing.GetStats().Acquisitions, "Lease of halved size used up")
+}
+
+// go test -timeout 30s -run ^TestLeasing_BanPolicy_PassedToStore$ github.com/zeroboo/gin-request-limitter -v
+func TestLeasing_BanPolicy_PassedToStore(t *testing.T) {
+	config, _, store := createLeasingTest(100)
+	config.BanThreshold = 3
+	leasing := createLeasingStore(t, store, 10, 10)
+
+	sendLeasedRequests(limitter.NewLimitter(leasing, config), "user", 5)
+
+	assert.False(t, limitter.IsLeasable(config), "Bans need violations in store")
+	assert.Equal(t, 5, store.GetCalls(), "Every request sent to store")
+	assert.Equal(t, int64(0), leasing.GetStats().Acquisitions, "Nothing leased")
+}
+
+// go test -timeout 30s -run ^TestLeasing_AcquireFailed_SizeKept$ github.com/zeroboo/gin-request-limitter -v
+func TestLeasing_AcquireFailed_SizeKept(t *testing.T) {
+	config, _, store := createLeasingTest(1000)
+	faulty := limittertest.NewFaultStore(store)
+	engine := limitter.NewLimitter(createLeasingStore(t, faulty, 1, 16), config)
+
+	assert.Equal(t, 1, sendLeasedRequests(engine, "user", 1), "Lease of min size")
+	faulty.Inject(limittertest.Fault{Kind: limittertest.FAULT_ERROR}, 1)
+	sendLeasedRequests(engine, "user", 1)
+	assert.Equal(t, 1, sendLeasedRequests(engine, "user", 1), "Leased after store recovered")
+
+	tracker, _ := store.GetTracker(context.Background(), "user", "/health")
+	assert.Equal(t, int64(1+2), tracker.WindowRequest, "Size doubled once, not by failed acquire")
+}
+
+// go test -timeout 30s -run ^TestLeasing_ResetUser_OtherUsersKept$ github.com/zeroboo/gin-request-limitter -v
+func TestLeasing_ResetUser_OtherUsersKept(t *testing.T) {
+	config, _, store := createLeasingTest(100)
+	leasing := createLeasingStore(t, store, 10, 10)
+	engine := limitter.NewLimitter(leasing, config)
+	sendLeasedRequests(engine, "user", 1)
+	sendLeasedRequests(engine, "user|admin", 1)
+
+	leasing.ResetUserTrackers(context.Background(), "user")
+
+	assert.Equal(t, 1, leasing.GetStats().Leases, "Lease of user with separator in id kept")
+	_, errGet := store.GetTracker(context.Background(), "user|admin", "/health")
+	assert.Nil(t, errGet, "Tracker of other user kept")
+}
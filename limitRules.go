@@ -0,0 +1,224 @@
+/*
+Stacked limit rules: a policy can have several windows checked together, a request is counted only if all of them admit it
+*/
+
+package limitter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Names of rules of LimitterConfig.MinRequestInterval and LimitterConfig.WindowSize
+const RULE_NAME_MIN_INTERVAL string = "minRequestInterval"
+const RULE_NAME_WINDOW string = "window"
+
+const HeaderLimitRule string = "X-RateLimit-Rule"
+
+// LimitRule is a fixed window limit checked in addition to window of policy
+type LimitRule struct {
+	//Name reported when rule rejects a request, empty means window size e.g. 60000ms
+	Name string `json:"name"`
+
+	//Window frame in milisec. Value 0 means no limit
+	WindowSize int64 `json:"windowSize"`
+
+	//Max requests per window
+	MaxRequestPerWindow int64 `json:"maxRequestPerWindow"`
+}
+
+func (rule *LimitRule) GetName() string {
+	if len(rule.Name) > 0 {
+		return rule.Name
+	}
+	return fmt.Sprintf("%vms", rule.WindowSize)
+}
+
+// RuleLimitError is a rejection by the most restrictive rule a request hit
+type RuleLimitError struct {
+	//Rule is name of the rule
+	Rule string
+
+	//Err is ErrorRequestTooFast or ErrorRequestTooFreequently
+	Err error
+
+	//RetryTime is unix milisec when rule admits requests again
+	RetryTime int64
+}
+
+func (err *RuleLimitError) Error() string {
+	return fmt.Sprintf("%v, rule=%v", err.Err, err.Rule)
+}
+
+func (err *RuleLimitError) Unwrap() error {
+	return err.Err
+}
+
+/*
+checkRequestLimits returns the rule with latest retry time among interval, window and rules of policy hit by a request,
+nil if request is admitted. Tracker is not changed.
+Windows of size primaryWindowSize are read from tracker window, others from tracker rule windows
+*/
+func checkRequestLimits(tracker *RequestTracker, currentTime time.Time, policy *LimitterConfig, primaryWindowSize int64) *RuleLimitError {
+	var errLimit *RuleLimitError
+	hit := func(rule string, errRule error, retryTime int64) {
+		if errLimit == nil || retryTime > errLimit.RetryTime {
+			errLimit = &RuleLimitError{Rule: rule, Err: errRule, RetryTime: retryTime}
+		}
+	}
+
+	now := currentTime.UnixMilli()
+	if policy.MinRequestInterval > 0 && tracker.IsRequestTooFast(currentTime, policy.MinRequestInterval) {
+		hit(RULE_NAME_MIN_INTERVAL, ErrorRequestTooFast, tracker.LastCall+policy.MinRequestInterval)
+	}
+	if policy.WindowSize > 0 && tracker.getWindowRequest(policy.WindowSize, primaryWindowSize, currentTime) >= policy.MaxRequestPerWindow {
+		hit(RULE_NAME_WINDOW, ErrorRequestTooFreequently, (now/policy.WindowSize+1)*policy.WindowSize)
+	}
+	for _, rule := range policy.Rules {
+		if rule.WindowSize > 0 && tracker.getWindowRequest(rule.WindowSize, primaryWindowSize, currentTime) >= rule.MaxRequestPerWindow {
+			hit(rule.GetName(), ErrorRequestTooFreequently, (now/rule.WindowSize+1)*rule.WindowSize)
+		}
+	}
+	return errLimit
+}
+
+/*
+validateRequestLimits checks interval, window and all rules of policy.
+If some of them are hit, the one with latest retry time is returned and request is not counted.
+Otherwise request is counted in all windows
+*/
+func validateRequestLimits(tracker *RequestTracker, currentTime time.Time, limitterConfig *LimitterConfig) error {
+	if errLimit := checkRequestLimits(tracker, currentTime, limitterConfig, limitterConfig.WindowSize); errLimit != nil {
+		return errLimit
+	}
+	tracker.UpdateRequest(currentTime, limitterConfig)
+	return nil
+}
+
+// getTrackedRules returns rules whose windows are kept in tracker: rules of policy and windows of candidate policy
+func (config *LimitterConfig) getTrackedRules() []LimitRule {
+	if config.Candidate == nil {
+		return config.Rules
+	}
+	rules := append([]LimitRule{}, config.Rules...)
+	if config.Candidate.WindowSize != config.WindowSize {
+		rules = append(rules, LimitRule{WindowSize: config.Candidate.WindowSize})
+	}
+	return append(rules, config.Candidate.Rules...)
+}
+
+// getWindowRequest returns requests in window of currentTime of given size
+func (tracker *RequestTracker) getWindowRequest(windowSize int64, primaryWindowSize int64, currentTime time.Time) int64 {
+	if windowSize != primaryWindowSize {
+		return tracker.GetRuleWindowRequest(windowSize, currentTime)
+	}
+	if tracker.WindowNum != currentTime.UnixMilli()/windowSize {
+		return 0
+	}
+	return tracker.WindowRequest
+}
+
+// parseRuleWindows decodes RequestTracker.RuleWindows to window size -> [window index, requests]
+func parseRuleWindows(value string) map[int64][2]int64 {
+	windows := map[int64][2]int64{}
+	for _, part := range strings.Split(value, ",") {
+		fields := strings.Split(part, ":")
+		if len(fields) != 3 {
+			continue
+		}
+		windowSize, errSize := strconv.ParseInt(fields[0], 10, 64)
+		windowNum, errNum := strconv.ParseInt(fields[1], 10, 64)
+		windowRequest, errRequest := strconv.ParseInt(fields[2], 10, 64)
+		if errSize != nil || errNum != nil || errRequest != nil {
+			continue
+		}
+		windows[windowSize] = [2]int64{windowNum, windowRequest}
+	}
+	return windows
+}
+
+// formatRuleWindows encodes windows as size:index:requests separated by comma, ordered by size
+func formatRuleWindows(windows map[int64][2]int64) string {
+	sizes := make([]int64, 0, len(windows))
+	for size := range windows {
+		sizes = append(sizes, size)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	parts := make([]string, len(sizes))
+	for i, size := range sizes {
+		parts[i] = fmt.Sprintf("%v:%v:%v", size, windows[size][0], windows[size][1])
+	}
+	return strings.Join(parts, ",")
+}
+
+// GetRuleWindowRequest returns requests counted in window of currentTime of a rule with given window size
+func (tracker *RequestTracker) GetRuleWindowRequest(windowSize int64, currentTime time.Time) int64 {
+	window, found := parseRuleWindows(tracker.RuleWindows)[windowSize]
+	if !found || window[0] != currentTime.UnixMilli()/windowSize {
+		return 0
+	}
+	return window[1]
+}
+
+// updateRuleWindows counts a request in windows of rules, windows of removed rules are dropped
+func (tracker *RequestTracker) updateRuleWindows(currentTime time.Time, rules []LimitRule) {
+	if len(rules) == 0 && len(tracker.RuleWindows) == 0 {
+		return
+	}
+	previous := parseRuleWindows(tracker.RuleWindows)
+	windows := map[int64][2]int64{}
+	for _, rule := range rules {
+		if rule.WindowSize <= 0 {
+			continue
+		}
+		windowNum := currentTime.UnixMilli() / rule.WindowSize
+		window := previous[rule.WindowSize]
+		if window[0] != windowNum {
+			window = [2]int64{windowNum, 0}
+		}
+		window[1] += 1
+		windows[rule.WindowSize] = window
+	}
+	tracker.RuleWindows = formatRuleWindows(windows)
+}
+
+// mergeRuleWindows keeps the latest window of each size, requests of the same window are max of both so merged counts are approximate
+func mergeRuleWindows(stored string, local string) string {
+	if stored == local || len(local) == 0 {
+		return stored
+	}
+	windows := parseRuleWindows(stored)
+	for size, window := range parseRuleWindows(local) {
+		current, found := windows[size]
+		if !found || window[0] > current[0] || (window[0] == current[0] && window[1] > current[1]) {
+			windows[size] = window
+		}
+	}
+	return formatRuleWindows(windows)
+}
+
+// SetRuleHeaders tells client which rule rejected request and when to retry: Retry-After in seconds and rule name
+func SetRuleHeaders(c *gin.Context, errValidate error, currentTime time.Time) {
+	WriteRuleHeaders(c.Writer.Header(), errValidate, currentTime)
+}
+
+// WriteRuleHeaders writes headers of SetRuleHeaders to header
+func WriteRuleHeaders(header http.Header, errValidate error, currentTime time.Time) {
+	var errLimit *RuleLimitError
+	if !errors.As(errValidate, &errLimit) {
+		return
+	}
+	retryAfterSec := (errLimit.RetryTime - currentTime.UnixMilli() + 999) / 1000
+	if retryAfterSec < 0 {
+		retryAfterSec = 0
+	}
+	header.Set("Retry-After", strconv.FormatInt(retryAfterSec, 10))
+	header.Set(HeaderLimitRule, errLimit.Rule)
+}
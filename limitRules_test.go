@@ -0,0 +1,72 @@
+package limitter
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var limitterTestConfigRules LimitterConfig = LimitterConfig{
+	MinRequestInterval:  200,
+	WindowSize:          60000,
+	MaxRequestPerWindow: 100,
+	ExpSec:              600,
+	Rules: []LimitRule{
+		{Name: "day", WindowSize: 86400000, MaxRequestPerWindow: 2},
+		{WindowSize: 3600000, MaxRequestPerWindow: 1000},
+	},
+}
+
+// go test -timeout 30s -run ^TestValidateRequest_StackedRules_CountedTogether$ github.com/zeroboo/gin-request-limitter -v
+func TestValidateRequest_StackedRules_CountedTogether(t *testing.T) {
+	tracker := NewRequestTracker("user", "/health")
+	now := time.UnixMilli(86400000*100 + 1000)
+
+	assert.Nil(t, ValidateRequest(tracker, now, "/health", "", &limitterTestConfigRules), "First request")
+	assert.Equal(t, int64(1), tracker.GetRuleWindowRequest(86400000, now), "Counted in day rule")
+	assert.Equal(t, int64(1), tracker.GetRuleWindowRequest(3600000, now), "Counted in hour rule")
+
+	errValidate := ValidateRequest(tracker, now.Add(100*time.Millisecond), "/health", "", &limitterTestConfigRules)
+	assert.ErrorIs(t, errValidate, ErrorRequestTooFast, "Interval rule hit")
+	assert.Equal(t, int64(1), tracker.GetRuleWindowRequest(86400000, now), "Rejected request not counted in any rule")
+	assert.Equal(t, int64(1), tracker.WindowRequest, "Rejected request not counted in window")
+
+	assert.Nil(t, ValidateRequest(tracker, now.Add(time.Second), "/health", "", &limitterTestConfigRules), "Second request")
+
+	errValidate = ValidateRequest(tracker, now.Add(time.Second+100*time.Millisecond), "/health", "", &limitterTestConfigRules)
+	var errLimit *RuleLimitError
+	assert.True(t, errors.As(errValidate, &errLimit), "Rejected by a rule")
+	assert.Equal(t, "day", errLimit.Rule, "Most restrictive rule reported")
+	assert.ErrorIs(t, errValidate, ErrorRequestTooFreequently, "Day rule is a window rule")
+	assert.Equal(t, (now.UnixMilli()/86400000+1)*86400000, tracker.GetRetryTime(errValidate, &limitterTestConfigRules).UnixMilli(),
+		"Retry at next day")
+}
+
+// go test -timeout 30s -run ^TestMergeRuleWindows_LatestWindowKept$ github.com/zeroboo/gin-request-limitter -v
+func TestMergeRuleWindows_LatestWindowKept(t *testing.T) {
+	merged := mergeRuleWindows("1000:5:3,60000:1:9", "1000:6:1,60000:1:7,86400000:0:1")
+
+	assert.Equal(t, "1000:6:1,60000:1:9,86400000:0:1", merged, "Latest window and max requests kept")
+}
+
+// go test -timeout 30s -run ^TestStoreBackedLimitter_StackedRules_RuleReported$ github.com/zeroboo/gin-request-limitter -v
+func TestStoreBackedLimitter_StackedRules_RuleReported(t *testing.T) {
+	config := limitterTestConfigRules
+	config.MinRequestInterval = 0
+	handler := CreateStoreBackedLimitter(NewRedisTrackerStore(nil), GetUserIdFromContextByField(FieldNameUserId), &config, false)
+	authHandler := CreateFakeAuthenticationHandler(FieldNameUserId, RandomString(16))
+
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		recorder := RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{}, authHandler, handler, HandleHealth)
+		codes = append(codes, recorder.Code)
+		if i == 2 {
+			assert.Equal(t, "day", recorder.Header().Get(HeaderLimitRule), "Rule reported")
+			assert.NotEmpty(t, recorder.Header().Get("Retry-After"), "Retry time reported")
+		}
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes, "Day rule saved in redis")
+}
@@ -4,9 +4,9 @@ import (
 	"context"
 	"crypto/sha1"
 	"encoding/hex"
-	"errors"
+	"encoding/json"
 	"fmt"
-	"net/http"
+	"os"
 	"time"
 
 	"cloud.google.com/go/datastore"
@@ -29,27 +29,109 @@ const MIN_REQUEST_INTERVAL_MILIS int64 = 200
 
 type LimitterConfig struct {
 	//Time between 2 requests in milisecs. 0 means no limit
-	MinRequestInterval int64
+	MinRequestInterval int64 `json:"minRequestInterval"`
 
 	//Window frame in milisec. Value 0 means no limit
-	WindowSize int64
+	WindowSize int64 `json:"windowSize"`
 
 	//Max requests per window
-	MaxRequestPerWindow int64
+	MaxRequestPerWindow int64 `json:"maxRequestPerWindow"`
 
 	//If true, error when save/load tracker will abort request
 	//If false, request will be served even if save/load tracker error
-	AbortOnFail bool
+	AbortOnFail bool `json:"abortOnFail"`
 
 	//ExpSec is sesion expiration in seconds
-	ExpSec int64
+	ExpSec int64 `json:"expSec"`
+
+	//Deadline in milisecs of loading tracker from backend. 0 means no deadline
+	LoadTimeout int64 `json:"loadTimeout"`
+
+	//Deadline in milisecs of saving tracker to backend. 0 means no deadline
+	SaveTimeout int64 `json:"saveTimeout"`
+
+	//Number of datastore entities a tracker is spread over to avoid write contention.
+	//Values less than 2 mean no sharding. Only shards below it are read, so lowering it forgets requests,
+	//violations and bans held in higher shards, they are deleted once expired by DatastoreTrackerStore.CleanupShards
+	ShardCount int `json:"shardCount"`
+
+	//Violations in BanPeriod that ban a key. 0 means no ban
+	BanThreshold int64 `json:"banThreshold"`
+
+	//Period in milisecs violations are counted in. 0 means violations are counted until tracker expires
+	BanPeriod int64 `json:"banPeriod"`
+
+	//Durations in milisecs of consecutive bans, empty means DefaultBanDurations
+	BanDurations []int64 `json:"banDurations"`
+
+	//Rules are more windows checked together with WindowSize, e.g. per minute and per day.
+	//A request is counted only if all of them admit it
+	Rules []LimitRule `json:"rules"`
+
+	//Limit of all requests to an url, checked before user limits. Only single node redis supports level limits
+	GlobalLimit LevelLimit `json:"globalLimit"`
+
+	//Limit of requests of a tenant to an url, tenant is namespace of request context. Only redis supports level limits
+	TenantLimit LevelLimit `json:"tenantLimit"`
+
+	//Shadow mode computes and records decisions but serves every request, including ones denied by AccessList.
+	//Violations and bans are not saved in shadow mode
+	Shadow bool `json:"shadow"`
+
+	//Candidate is a policy evaluated next to this one, its decisions are recorded but not enforced. Nil means no candidate.
+	//Candidate windows are charged only by requests this policy admits, so candidate decisions approximate the candidate
+	//enforced alone: requests rejected by this policy do not count toward candidate limits
+	Candidate *LimitterConfig `json:"candidate,omitempty"`
+
+	//Decisions counts decisions of this policy and its candidate. Nil means decisions are only logged
+	Decisions *DecisionRecorder `json:"-"`
+
+	//AccessList is checked before validating: allowed requests bypass limits, denied ones are rejected. Nil means no rule
+	AccessList *AccessList `json:"-"`
+
+	//Overrides are temporary policies of some users replacing this one, nil means no override
+	Overrides *PolicyOverrides `json:"-"`
+
+	//Clock tells current time to limitter and stores, nil means SystemClock
+	Clock Clock `json:"-"`
+}
+
+// GetUserConfig returns policy override of userId at currentTime if any, this config otherwise.
+// Override without a clock uses clock of this config
+func (config *LimitterConfig) GetUserConfig(userId string, currentTime time.Time) *LimitterConfig {
+	if config.Overrides != nil {
+		if override := config.Overrides.Get(userId, currentTime); override != nil {
+			if override.Clock == nil && config.Clock != nil {
+				userConfig := *override
+				userConfig.Clock = config.Clock
+				return &userConfig
+			}
+			return override
+		}
+	}
+	return config
+}
+
+// LoadLimitterConfigFile reads a policy from a JSON file, fields without JSON names are left empty
+func LoadLimitterConfigFile(path string) (*LimitterConfig, error) {
+	config := &LimitterConfig{}
+	data, errRead := os.ReadFile(path)
+	if errRead != nil {
+		return nil, errRead
+	}
+	if errParse := json.Unmarshal(data, config); errParse != nil {
+		return nil, errParse
+	}
+	return config, nil
 }
 
 var ErrorRequestTooFast = fmt.Errorf("request is too fast")
 var ErrorRequestTooFreequently = fmt.Errorf("request is too freequently")
 
 /*
-ValidateRequest returns nil if request is valid, an error means invalid request
+ValidateRequest returns nil if request is valid, an error means invalid request.
+Banned tracker rejects request with ErrorRequestBanned, other rejections are counted as violations.
+In shadow mode, violations are not counted so no penalty is saved
 */
 func ValidateRequest(tracker *RequestTracker,
 	currentTime time.Time,
@@ -57,41 +139,28 @@ func ValidateRequest(tracker *RequestTracker,
 	requestClientIP string,
 	limitterConfig *LimitterConfig) error {
 
-	//log.Infof("ValidateRequest: Current=%v, lastCall=%v, passed=%v, minInterval=%v", currentTime.UnixMilli(), tracker.LastCall, currentTime.UnixMilli()-tracker.LastCall, limitterConfig.MinRequestInterval)
-	if limitterConfig.MinRequestInterval > 0 {
-		if tracker.IsRequestTooFast(currentTime, limitterConfig.MinRequestInterval) {
-			//log.Infof("InvalidRequest: TooFast, ID=%v, url=%v, IP=%v, elapse=%v", tracker.UID, requestURL, requestClientIP, currentTime.UnixMilli()-tracker.LastCall)
-			return ErrorRequestTooFast
-		}
+	if tracker.IsBanned(currentTime) {
+		return ErrorRequestBanned
 	}
 
-	//log.Printf("WindowSize=%v, callLimit=%v", limitterConfig.WindowSize, limitterConfig.WindowRequestMax)
-	tracker.UpdateRequest(currentTime, limitterConfig)
-
-	if limitterConfig.WindowSize > 0 {
-		if tracker.IsRequestTooFrequently(currentTime, limitterConfig.MaxRequestPerWindow) {
-			//log.Infof("InvalidRequest: TooMany, ID=%v, url=%v, IP=%v, window=%v, windowCount=%v", tracker.UID, requestURL, requestClientIP, tracker.Window, tracker.WindowCount)
-			return ErrorRequestTooFreequently
-		}
+	errValidate := validateRequestLimits(tracker, currentTime, limitterConfig)
+	if errValidate != nil && !limitterConfig.Shadow && tracker.RecordViolation(currentTime, limitterConfig) {
+		log.Warnf("RequestLimitter: Banned, UID=%v, url=%v, IP=%v, level=%v, until=%v",
+			tracker.UID, requestURL, requestClientIP, tracker.BanLevel, tracker.BanUntil)
+		return ErrorRequestBanned
 	}
-
-	return nil
+	return errValidate
 }
 
-// ProcessValidateResult aborts gin context if there is an error, let gin context run otherwise
+// ProcessValidateResult aborts gin context with status of GetRejectionStatus if there is an error, let gin context run otherwise
 func ProcessValidateResult(validateError error, c *gin.Context, isMiddleware bool) {
 	if validateError == nil {
 		if isMiddleware {
 			c.Next()
 		}
-
-	} else if errors.Is(validateError, ErrorRequestTooFast) {
-		c.AbortWithStatus(http.StatusTooEarly)
-	} else if errors.Is(validateError, ErrorRequestTooFreequently) {
-		c.AbortWithStatus(http.StatusTooManyRequests)
-	} else {
-		c.AbortWithStatus(http.StatusInternalServerError)
+		return
 	}
+	c.AbortWithStatus(GetRejectionStatus(validateError))
 }
 
 // CreateTrackerName returns key of tracker based on userId and request URL.
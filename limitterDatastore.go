@@ -5,84 +5,212 @@ Limitter that uses datastore
 package limitter
 
 import (
+	"context"
 	"errors"
-	"time"
 
 	"cloud.google.com/go/datastore"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
 )
 
-func CreateDatastoreBackedLimitter(pClient *datastore.Client, pTrackerKind string,
-	pUserIdExtractor func(c *gin.Context) string,
-	pConfig *LimitterConfig,
-	pIsMiddleware bool) func(c *gin.Context) {
+// DatastoreTrackerStore persists trackers as entities of a kind in datastore
+type DatastoreTrackerStore struct {
+	Client *datastore.Client
+	Kind   string
 
-	return func(c *gin.Context) {
-		// Sets the name/ID for the new entity.
-		userId := pUserIdExtractor(c)
-		url := c.Request.URL.Path
-		currentTime := time.Now()
-		tracker := &RequestTracker{}
-		trackerName := CreateTrackerName(userId, url)
-		trackerKey := datastore.NameKey(pTrackerKind, trackerName, nil)
-
-		_, err := pClient.RunInTransaction(c.Request.Context(), func(tx *datastore.Transaction) error {
-
-			errTracker := tx.Get(trackerKey, tracker)
-			if errTracker != nil {
-				_, isErrorFieldMismatch := errTracker.(*datastore.ErrFieldMismatch)
-				if isErrorFieldMismatch {
-					errTracker = nil
-					if log.IsLevelEnabled(log.TraceLevel) {
-						log.Tracef("LoadUserTracker: TypeMisMatch, kind=%v, url=%v, userId=%v, error=%v",
-							pTrackerKind, url, userId, errTracker)
-					}
-				} else if errors.Is(errTracker, datastore.ErrNoSuchEntity) {
-					errTracker = nil
-					tracker = NewRequestTrackerWithExpiration(userId, url, pConfig.CreateExpiration(currentTime))
-					if log.IsLevelEnabled(log.TraceLevel) {
-						log.Tracef("LoadUserTracker: NotFound, kind=%v, url=%v, userId=%v, error=%v",
-							pTrackerKind, url, userId, errTracker)
-					}
-				} else {
-					//It's critical
-					log.Errorf("LoadUserTracker: Failed, kind=%v, url=%v, userId=%v, error=%v",
-						pTrackerKind, url, userId, errTracker)
-					return errTracker
+	//Namespace of trackers if request context has no namespace, empty means default namespace
+	Namespace string
+
+	//GroupByUser puts trackers of a user under a parent key of the user
+	GroupByUser bool
+
+	//KeyCodec encodes names of trackers, nil means HashTrackerKeyCodec
+	KeyCodec TrackerKeyCodec
+}
+
+func NewDatastoreTrackerStore(client *datastore.Client, kind string) *DatastoreTrackerStore {
+	return &DatastoreTrackerStore{
+		Client: client,
+		Kind:   kind,
+	}
+}
+
+// Max attempts of a transaction updating a tracker changed concurrently by other requests
+const DatastoreMaxTransactionAttempts int = 10
+
+// UpdateTracker loads, validates and saves tracker in a transaction. Rejected requests are not counted, only their penalty is saved
+func (store *DatastoreTrackerStore) UpdateTracker(ctx context.Context, userId string, url string, config *LimitterConfig,
+	validate func(tracker *RequestTracker) error) (*RequestTracker, error) {
+	if config.ShardCount > 1 {
+		return store.updateShardedTracker(ctx, userId, url, config, validate)
+	}
+	tracker := &RequestTracker{}
+	trackerKey := store.CreateTrackerKey(ctx, userId, url)
+
+	//Transaction does not separate load and save so it is given both deadlines
+	txCtx, cancel := createTimeoutContext(ctx, config.LoadTimeout+config.SaveTimeout)
+	defer cancel()
+	var errValidate error
+	_, err := store.Client.RunInTransaction(txCtx, func(tx *datastore.Transaction) error {
+
+		errTracker := tx.Get(trackerKey, tracker)
+		if errTracker != nil {
+			_, isErrorFieldMismatch := errTracker.(*datastore.ErrFieldMismatch)
+			if isErrorFieldMismatch {
+				errTracker = nil
+				if log.IsLevelEnabled(log.TraceLevel) {
+					log.Tracef("LoadUserTracker: TypeMisMatch, kind=%v, url=%v, userId=%v, error=%v",
+						store.Kind, url, userId, errTracker)
 				}
-			} else {
+			} else if errors.Is(errTracker, datastore.ErrNoSuchEntity) {
+				errTracker = nil
+				tracker = NewRequestTrackerWithExpiration(userId, url, config.CreateExpiration(config.Now()))
 				if log.IsLevelEnabled(log.TraceLevel) {
-					log.Tracef("RequestLimitter: TrackerLoaded, key=%v, tracker=%v", trackerKey, tracker)
+					log.Tracef("LoadUserTracker: NotFound, kind=%v, url=%v, userId=%v, error=%v",
+						store.Kind, url, userId, errTracker)
 				}
+			} else {
+				//It's critical
+				log.Errorf("LoadUserTracker: Failed, kind=%v, url=%v, userId=%v, error=%v",
+					store.Kind, url, userId, errTracker)
+				return errTracker
+			}
+		} else if tracker.IsExpired(config.Now()) {
+			//Expired trackers not purged yet start over
+			tracker = NewRequestTrackerWithExpiration(userId, url, config.CreateExpiration(config.Now()))
+		} else {
+			if log.IsLevelEnabled(log.TraceLevel) {
+				log.Tracef("RequestLimitter: TrackerLoaded, key=%v, tracker=%v", trackerKey, tracker.Format(config.Now()))
 			}
+		}
 
-			errValidate := ValidateRequest(tracker, currentTime, url, c.ClientIP(), pConfig)
-			if errValidate != nil {
+		loaded := *tracker
+		errValidate = validate(tracker)
+		saved := tracker
+		if errValidate != nil {
+			if !tracker.IsPenaltyChanged(&loaded) {
 				return errValidate
 			}
+			saved = CreatePenalizedTracker(&loaded, tracker)
+		}
 
-			_, errTracker = tx.Put(trackerKey, tracker)
-			if errTracker != nil {
-				log.Errorf("RequestLimitter: UpdateTrackerFailed, UID=%v, key=%v, error=%v", userId, trackerKey, errTracker)
-				return errTracker
+		_, errTracker = tx.Put(trackerKey, saved)
+		if errTracker != nil {
+			log.Errorf("RequestLimitter: UpdateTrackerFailed, UID=%v, key=%v, error=%v", userId, trackerKey, errTracker)
+			return errTracker
+		}
+
+		return nil
+	}, datastore.MaxAttempts(DatastoreMaxTransactionAttempts))
+	if err == nil {
+		err = errValidate
+	}
+	return tracker, err
+}
+
+func CreateDatastoreBackedLimitter(pClient *datastore.Client, pTrackerKind string,
+	pUserIdExtractor func(c *gin.Context) string,
+	pConfig *LimitterConfig,
+	pIsMiddleware bool) func(c *gin.Context) {
+	return CreateStoreBackedLimitter(NewDatastoreTrackerStore(pClient, pTrackerKind), pUserIdExtractor, pConfig, pIsMiddleware)
+}
+
+func (store *DatastoreTrackerStore) GetTracker(ctx context.Context, userId string, url string) (*RequestTracker, error) {
+	tracker := &RequestTracker{}
+	errGet := store.Client.Get(ctx, store.CreateTrackerKey(ctx, userId, url), tracker)
+	if errors.Is(errGet, datastore.ErrNoSuchEntity) {
+		return nil, ErrorTrackerNotFound
+	}
+	if _, isErrorFieldMismatch := errGet.(*datastore.ErrFieldMismatch); errGet != nil && !isErrorFieldMismatch {
+		return nil, errGet
+	}
+	return tracker, nil
+}
+
+func (store *DatastoreTrackerStore) GetUserTrackers(ctx context.Context, userId string) ([]*RequestTracker, error) {
+	_, trackers, errList := store.ListUserTrackers(ctx, userId)
+	return trackers, errList
+}
+
+// ResetTracker deletes tracker and its shards
+func (store *DatastoreTrackerStore) ResetTracker(ctx context.Context, userId string, url string) error {
+	trackerKey := store.CreateTrackerKey(ctx, userId, url)
+	shardQuery := datastore.NewQuery(CreateShardKind(store.Kind)).Namespace(trackerKey.Namespace).Ancestor(trackerKey)
+	if _, errDelete := deleteQueryKeys(ctx, store.Client, shardQuery); errDelete != nil {
+		return errDelete
+	}
+	return store.Client.Delete(ctx, trackerKey)
+}
+
+// LiftBan clears ban and violations of tracker in a transaction. Ban of sharded tracker is lifted on all of its shards
+func (store *DatastoreTrackerStore) LiftBan(ctx context.Context, userId string, url string) error {
+	trackerKey := store.CreateTrackerKey(ctx, userId, url)
+	shardQuery := datastore.NewQuery(CreateShardKind(store.Kind)).Namespace(trackerKey.Namespace).Ancestor(trackerKey).KeysOnly()
+	shardKeys, errShards := store.Client.GetAll(ctx, shardQuery, nil)
+	if errShards != nil {
+		return errShards
+	}
+	keys := append(shardKeys, trackerKey)
+
+	found := false
+	_, errTx := store.Client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		found = false
+		trackers := make([]RequestTracker, len(keys))
+		errGet := tx.GetMulti(keys, trackers)
+		multiError, isMultiError := errGet.(datastore.MultiError)
+		if errGet != nil && !isMultiError {
+			return errGet
+		}
+		changed := []*datastore.Key{}
+		changedTrackers := []*RequestTracker{}
+		for i := range keys {
+			if isMultiError && multiError[i] != nil {
+				if errors.Is(multiError[i], datastore.ErrNoSuchEntity) {
+					continue
+				}
+				if _, isErrorFieldMismatch := multiError[i].(*datastore.ErrFieldMismatch); !isErrorFieldMismatch {
+					return multiError[i]
+				}
 			}
+			found = true
+			trackers[i].ClearBan()
+			changed = append(changed, keys[i])
+			changedTrackers = append(changedTrackers, &trackers[i])
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+		_, errPut := tx.PutMulti(changed, changedTrackers)
+		return errPut
+	})
+	if errTx != nil {
+		return errTx
+	}
+	if !found {
+		return ErrorTrackerNotFound
+	}
+	return nil
+}
+
+func (store *DatastoreTrackerStore) ResetUserTrackers(ctx context.Context, userId string) (int, error) {
+	return store.DeleteUserTrackers(ctx, userId)
+}
 
+// ForEachTracker queries all trackers of kind in namespace of context and calls fn on each of them until fn returns an error
+func (store *DatastoreTrackerStore) ForEachTracker(ctx context.Context, fn func(tracker *RequestTracker) error) error {
+	it := store.Client.Run(ctx, datastore.NewQuery(store.Kind).Namespace(store.getNamespace(ctx)))
+	for {
+		tracker := &RequestTracker{}
+		_, errNext := it.Next(tracker)
+		if errNext == iterator.Done {
 			return nil
-		})
-
-		ProcessValidateResult(err, c, pIsMiddleware)
-
-		if log.IsLevelEnabled(log.TraceLevel) {
-			log.Tracef("RequestLimitter: ValidateFinish, UID=%v, url=%v, IP=%v, calls=%v|%v, window=%v/%v|%v, key=%v, errValidate=%v",
-				tracker.UID,
-				url,
-				c.ClientIP(),
-				currentTime.UnixMilli()-tracker.LastCall, pConfig.MinRequestInterval,
-				tracker.WindowRequest, pConfig.MaxRequestPerWindow, tracker.WindowNum,
-				trackerKey,
-				err,
-			)
+		}
+		if _, isErrorFieldMismatch := errNext.(*datastore.ErrFieldMismatch); errNext != nil && !isErrorFieldMismatch {
+			return errNext
+		}
+		if errFn := fn(tracker); errFn != nil {
+			return errFn
 		}
 	}
 }
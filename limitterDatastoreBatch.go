@@ -0,0 +1,426 @@
+/*
+Approximate limitter that decides on local trackers and writes them to datastore in batches
+*/
+
+package limitter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	log "github.com/sirupsen/logrus"
+)
+
+// Max entities of a datastore transaction
+const DatastoreMaxBatchSize int = 500
+
+const DefaultBatchFlushIntervalMilis int64 = 1000
+
+type batchTrackerEntry struct {
+	mutex sync.Mutex
+	key   *datastore.Key
+
+	//tracker is state in datastore plus pending requests
+	tracker RequestTracker
+	loaded  bool
+
+	//pendingRequest is requests of window tracker.WindowNum accepted since last flush
+	pendingRequest int64
+	dirty          bool
+
+	//refreshedAt is last time tracker is synced with datastore, unix milisec
+	refreshedAt int64
+
+	//evicted entry is removed from store and must not be used
+	evicted bool
+}
+
+// batchTrackerChange is a snapshot of an entry to flush
+type batchTrackerChange struct {
+	entry          *batchTrackerEntry
+	tracker        RequestTracker
+	pendingRequest int64
+}
+
+/*
+DatastoreBatchTrackerStore validates requests on trackers cached in memory
+and flushes accepted requests to datastore every FlushInterval.
+
+Requests of other instances are merged in datastore at flush, so decisions can be stale up to
+max(FlushInterval, MaxStaleness) and a user can exceed limits by requests accepted in that period.
+*/
+type DatastoreBatchTrackerStore struct {
+	Client *datastore.Client
+	Kind   string
+
+	//Namespace, GroupByUser and KeyCodec lay out trackers as DatastoreTrackerStore does
+	Namespace   string
+	GroupByUser bool
+	KeyCodec    TrackerKeyCodec
+
+	//FlushInterval is time in milisecs between flushes
+	FlushInterval int64
+
+	//MaxStaleness is time in milisecs a tracker without pending requests is used before reloaded
+	MaxStaleness int64
+
+	//Clock tells staleness of trackers at flush, nil means SystemClock. Requests are timed by clock of their config
+	Clock Clock
+
+	mutex   sync.Mutex
+	entries map[string]*batchTrackerEntry
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewDatastoreBatchTrackerStore returns a store and starts its flushing loop, Close must be called to stop it
+func NewDatastoreBatchTrackerStore(client *datastore.Client, kind string, flushIntervalMilis int64, maxStalenessMilis int64) *DatastoreBatchTrackerStore {
+	if flushIntervalMilis <= 0 {
+		flushIntervalMilis = DefaultBatchFlushIntervalMilis
+	}
+	if maxStalenessMilis < flushIntervalMilis {
+		maxStalenessMilis = flushIntervalMilis
+	}
+	store := &DatastoreBatchTrackerStore{
+		Client:        client,
+		Kind:          kind,
+		FlushInterval: flushIntervalMilis,
+		MaxStaleness:  maxStalenessMilis,
+		entries:       map[string]*batchTrackerEntry{},
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go store.runFlushLoop()
+	return store
+}
+
+func (store *DatastoreBatchTrackerStore) runFlushLoop() {
+	defer close(store.done)
+	ticker := time.NewTicker(time.Duration(store.FlushInterval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-store.stop:
+			return
+		case <-ticker.C:
+			errFlush := store.Flush(context.Background())
+			if errFlush != nil {
+				log.Errorf("DatastoreBatchLimitter: FlushFailed, kind=%v, error=%v", store.Kind, errFlush)
+			}
+		}
+	}
+}
+
+// Close stops flushing loop and flushes pending requests
+func (store *DatastoreBatchTrackerStore) Close(ctx context.Context) error {
+	close(store.stop)
+	<-store.done
+	return store.Flush(ctx)
+}
+
+// CreateTrackerKey returns key of tracker of userId and url in namespace of context, same as key of DatastoreTrackerStore of same layout
+func (store *DatastoreBatchTrackerStore) CreateTrackerKey(ctx context.Context, userId string, url string) *datastore.Key {
+	return store.layout().CreateTrackerKey(ctx, userId, url)
+}
+
+func (store *DatastoreBatchTrackerStore) getEntry(ctx context.Context, userId string, url string) *batchTrackerEntry {
+	key := store.CreateTrackerKey(ctx, userId, url)
+	name := key.String()
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	entry, found := store.entries[name]
+	if !found {
+		entry = &batchTrackerEntry{
+			key: key,
+		}
+		store.entries[name] = entry
+	}
+	return entry
+}
+
+// UpdateTracker validates on local tracker in namespace of context, tracker is loaded from datastore if it is not cached or stale
+func (store *DatastoreBatchTrackerStore) UpdateTracker(ctx context.Context, userId string, url string, config *LimitterConfig,
+	validate func(tracker *RequestTracker) error) (*RequestTracker, error) {
+	if errCtx := ctx.Err(); errCtx != nil {
+		return nil, errCtx
+	}
+	entry := store.getEntry(ctx, userId, url)
+	entry.mutex.Lock()
+	for entry.evicted {
+		entry.mutex.Unlock()
+		entry = store.getEntry(ctx, userId, url)
+		entry.mutex.Lock()
+	}
+	defer entry.mutex.Unlock()
+
+	currentTime := config.Now()
+	if !entry.loaded || (!entry.dirty && currentTime.UnixMilli()-entry.refreshedAt >= store.MaxStaleness) {
+		loadCtx, cancel := createTimeoutContext(ctx, config.LoadTimeout)
+		tracker, errLoad := store.loadTracker(loadCtx, entry.key, userId, url)
+		cancel()
+		if errLoad != nil {
+			return nil, errLoad
+		}
+		entry.tracker = *tracker
+		entry.loaded = true
+		entry.pendingRequest = 0
+		entry.refreshedAt = currentTime.UnixMilli()
+	}
+	if entry.tracker.IsExpired(currentTime) {
+		entry.tracker = *NewRequestTrackerWithExpiration(userId, url, config.CreateExpiration(currentTime))
+		entry.pendingRequest = 0
+	}
+
+	tracker := entry.tracker
+	errValidate := validate(&tracker)
+	if errValidate != nil {
+		if tracker.IsPenaltyChanged(&entry.tracker) {
+			entry.tracker = *CreatePenalizedTracker(&entry.tracker, &tracker)
+			entry.dirty = true
+		}
+		return &tracker, errValidate
+	}
+
+	if tracker.WindowNum != entry.tracker.WindowNum {
+		entry.pendingRequest = tracker.WindowRequest
+	} else {
+		entry.pendingRequest += tracker.WindowRequest - entry.tracker.WindowRequest
+	}
+	entry.tracker = tracker
+	entry.dirty = true
+	return &tracker, nil
+}
+
+func (store *DatastoreBatchTrackerStore) loadTracker(ctx context.Context, key *datastore.Key, userId string, url string) (*RequestTracker, error) {
+	tracker := NewRequestTracker(userId, url)
+	errGet := store.Client.Get(ctx, key, tracker)
+	if errGet != nil {
+		if _, isErrorFieldMismatch := errGet.(*datastore.ErrFieldMismatch); isErrorFieldMismatch {
+			return tracker, nil
+		}
+		if errors.Is(errGet, datastore.ErrNoSuchEntity) {
+			return NewRequestTracker(userId, url), nil
+		}
+		return nil, errGet
+	}
+	return tracker, nil
+}
+
+// collectChanges returns snapshots of dirty entries and evicts stale clean entries
+func (store *DatastoreBatchTrackerStore) collectChanges(currentTime time.Time) []*batchTrackerChange {
+	store.mutex.Lock()
+	entries := make(map[string]*batchTrackerEntry, len(store.entries))
+	for name, entry := range store.entries {
+		entries[name] = entry
+	}
+	store.mutex.Unlock()
+
+	changes := []*batchTrackerChange{}
+	for name, entry := range entries {
+		entry.mutex.Lock()
+		if entry.dirty {
+			changes = append(changes, &batchTrackerChange{
+				entry:          entry,
+				tracker:        entry.tracker,
+				pendingRequest: entry.pendingRequest,
+			})
+			entry.pendingRequest = 0
+			entry.dirty = false
+		} else if currentTime.UnixMilli()-entry.refreshedAt >= store.MaxStaleness {
+			store.mutex.Lock()
+			entry.evicted = true
+			delete(store.entries, name)
+			store.mutex.Unlock()
+		}
+		entry.mutex.Unlock()
+	}
+	return changes
+}
+
+// MergeTrackerRequests adds requests accepted locally in window of local to stored tracker, latest ban and violations are kept
+func MergeTrackerRequests(stored *RequestTracker, local *RequestTracker, pendingRequest int64) {
+	if stored.WindowNum == local.WindowNum {
+		stored.WindowRequest += pendingRequest
+	} else if stored.WindowNum < local.WindowNum {
+		stored.WindowNum = local.WindowNum
+		stored.WindowRequest = pendingRequest
+	}
+	if local.LastCall > stored.LastCall {
+		stored.LastCall = local.LastCall
+	}
+	if local.Exp > stored.Exp {
+		stored.Exp = local.Exp
+	}
+	mergeTrackerPenalty(stored, local)
+	stored.RuleWindows = mergeRuleWindows(stored.RuleWindows, local.RuleWindows)
+	stored.UID = local.UID
+	stored.URL = local.URL
+}
+
+// Flush merges pending requests into datastore in batches of DatastoreMaxBatchSize
+func (store *DatastoreBatchTrackerStore) Flush(ctx context.Context) error {
+	changes := store.collectChanges(getClock(store.Clock).Now())
+	var errFlush error
+	for start := 0; start < len(changes); start += DatastoreMaxBatchSize {
+		end := start + DatastoreMaxBatchSize
+		if end > len(changes) {
+			end = len(changes)
+		}
+		if errBatch := store.flushBatch(ctx, changes[start:end]); errBatch != nil {
+			errFlush = errBatch
+		}
+	}
+	if log.IsLevelEnabled(log.TraceLevel) {
+		log.Tracef("DatastoreBatchLimitter: Flushed, kind=%v, trackers=%v, error=%v", store.Kind, len(changes), errFlush)
+	}
+	return errFlush
+}
+
+func (store *DatastoreBatchTrackerStore) flushBatch(ctx context.Context, changes []*batchTrackerChange) error {
+	keys := make([]*datastore.Key, len(changes))
+	for i, change := range changes {
+		keys[i] = change.entry.key
+	}
+	merged := make([]*RequestTracker, len(changes))
+
+	_, errTx := store.Client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		stored := make([]RequestTracker, len(changes))
+		errGet := tx.GetMulti(keys, stored)
+		if multiError, isMultiError := errGet.(datastore.MultiError); isMultiError {
+			for i, errEntity := range multiError {
+				_, isErrorFieldMismatch := errEntity.(*datastore.ErrFieldMismatch)
+				if errEntity == nil || isErrorFieldMismatch {
+					continue
+				}
+				if errors.Is(errEntity, datastore.ErrNoSuchEntity) {
+					stored[i] = RequestTracker{}
+					continue
+				}
+				return errEntity
+			}
+		} else if errGet != nil {
+			return errGet
+		}
+
+		for i, change := range changes {
+			MergeTrackerRequests(&stored[i], &change.tracker, change.pendingRequest)
+			merged[i] = &stored[i]
+		}
+		_, errPut := tx.PutMulti(keys, merged)
+		return errPut
+	})
+
+	refreshedAt := getClock(store.Clock).Now().UnixMilli()
+	for i, change := range changes {
+		entry := change.entry
+		entry.mutex.Lock()
+		if errTx != nil {
+			//Put requests back to retry at next flush
+			if entry.tracker.WindowNum == change.tracker.WindowNum {
+				entry.pendingRequest += change.pendingRequest
+			}
+			entry.dirty = true
+		} else {
+			refreshed := *merged[i]
+			MergeTrackerRequests(&refreshed, &entry.tracker, entry.pendingRequest)
+			if refreshed.WindowNum != entry.tracker.WindowNum {
+				//Other instances moved to a newer window
+				entry.pendingRequest = 0
+			}
+			entry.tracker = refreshed
+			entry.refreshedAt = refreshedAt
+		}
+		entry.mutex.Unlock()
+	}
+	return errTx
+}
+
+// layout returns DatastoreTrackerStore of same client and layout to run admin operations on
+func (store *DatastoreBatchTrackerStore) layout() *DatastoreTrackerStore {
+	return &DatastoreTrackerStore{
+		Client:      store.Client,
+		Kind:        store.Kind,
+		Namespace:   store.Namespace,
+		GroupByUser: store.GroupByUser,
+		KeyCodec:    store.KeyCodec,
+	}
+}
+
+// dropEntries evicts cached entries in namespace of context matched by match, their pending requests are discarded
+func (store *DatastoreBatchTrackerStore) dropEntries(ctx context.Context, match func(entry *batchTrackerEntry) bool) int {
+	namespace := store.layout().getNamespace(ctx)
+	store.mutex.Lock()
+	entries := make(map[string]*batchTrackerEntry, len(store.entries))
+	for name, entry := range store.entries {
+		if entry.key.Namespace == namespace {
+			entries[name] = entry
+		}
+	}
+	store.mutex.Unlock()
+
+	dropped := 0
+	for name, entry := range entries {
+		entry.mutex.Lock()
+		if !entry.evicted && match(entry) {
+			store.mutex.Lock()
+			entry.evicted = true
+			delete(store.entries, name)
+			store.mutex.Unlock()
+			dropped += 1
+		}
+		entry.mutex.Unlock()
+	}
+	return dropped
+}
+
+// dropTrackerEntry evicts cached entry of userId and url in namespace of context
+func (store *DatastoreBatchTrackerStore) dropTrackerEntry(ctx context.Context, userId string, url string) int {
+	name := store.CreateTrackerKey(ctx, userId, url).String()
+	return store.dropEntries(ctx, func(entry *batchTrackerEntry) bool {
+		return entry.key.String() == name
+	})
+}
+
+// dropUserEntries evicts cached entries of userId in namespace of context
+func (store *DatastoreBatchTrackerStore) dropUserEntries(ctx context.Context, userId string) int {
+	return store.dropEntries(ctx, func(entry *batchTrackerEntry) bool {
+		return entry.loaded && entry.tracker.UID == userId
+	})
+}
+
+// GetTracker flushes pending requests and returns tracker from datastore
+func (store *DatastoreBatchTrackerStore) GetTracker(ctx context.Context, userId string, url string) (*RequestTracker, error) {
+	if errFlush := store.Flush(ctx); errFlush != nil {
+		return nil, errFlush
+	}
+	return store.layout().GetTracker(ctx, userId, url)
+}
+
+// GetUserTrackers flushes pending requests and returns trackers of userId from datastore
+func (store *DatastoreBatchTrackerStore) GetUserTrackers(ctx context.Context, userId string) ([]*RequestTracker, error) {
+	if errFlush := store.Flush(ctx); errFlush != nil {
+		return nil, errFlush
+	}
+	return store.layout().GetUserTrackers(ctx, userId)
+}
+
+// ResetTracker drops cached entry with its pending requests and deletes tracker from datastore
+func (store *DatastoreBatchTrackerStore) ResetTracker(ctx context.Context, userId string, url string) error {
+	store.dropTrackerEntry(ctx, userId, url)
+	return store.layout().ResetTracker(ctx, userId, url)
+}
+
+// ResetUserTrackers drops cached entries of userId with their pending requests and deletes trackers from datastore
+func (store *DatastoreBatchTrackerStore) ResetUserTrackers(ctx context.Context, userId string) (int, error) {
+	store.dropUserEntries(ctx, userId)
+	return store.layout().ResetUserTrackers(ctx, userId)
+}
+
+// LiftBan drops cached entry of userId and url and lifts ban in datastore, pending requests of the entry are discarded
+func (store *DatastoreBatchTrackerStore) LiftBan(ctx context.Context, userId string, url string) error {
+	store.dropTrackerEntry(ctx, userId, url)
+	return store.layout().LiftBan(ctx, userId, url)
+}
@@ -0,0 +1,108 @@
+package limitter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestMergeTrackerRequests_SameWindow_Added$ github.com/zeroboo/gin-request-limitter -v
+func TestMergeTrackerRequests_SameWindow_Added(t *testing.T) {
+	stored := &RequestTracker{WindowNum: 5, WindowRequest: 3, LastCall: 100, Exp: 1000}
+	local := &RequestTracker{UID: "uid", URL: "/health", WindowNum: 5, WindowRequest: 4, LastCall: 200, Exp: 2000}
+	MergeTrackerRequests(stored, local, 2)
+
+	assert.Equal(t, int64(5), stored.WindowRequest, "Pending requests added")
+	assert.Equal(t, int64(200), stored.LastCall, "Latest call kept")
+	assert.Equal(t, int64(2000), stored.Exp, "Latest expiration kept")
+	assert.Equal(t, "uid", stored.UID, "UID set")
+}
+
+// go test -timeout 30s -run ^TestMergeTrackerRequests_DifferentWindow_NewerWindowKept$ github.com/zeroboo/gin-request-limitter -v
+func TestMergeTrackerRequests_DifferentWindow_NewerWindowKept(t *testing.T) {
+	stored := &RequestTracker{WindowNum: 5, WindowRequest: 3}
+	MergeTrackerRequests(stored, &RequestTracker{WindowNum: 6, WindowRequest: 2}, 2)
+	assert.Equal(t, int64(6), stored.WindowNum, "Local window is newer")
+	assert.Equal(t, int64(2), stored.WindowRequest, "Count of newer window")
+
+	MergeTrackerRequests(stored, &RequestTracker{WindowNum: 4, WindowRequest: 9}, 9)
+	assert.Equal(t, int64(6), stored.WindowNum, "Stored window is newer")
+	assert.Equal(t, int64(2), stored.WindowRequest, "Requests of old window ignored")
+}
+
+// go test -timeout 30s -run ^TestDatastoreBatchLimitter_Flush_RequestsMergedInDatastore$ github.com/zeroboo/gin-request-limitter -v
+func TestDatastoreBatchLimitter_Flush_RequestsMergedInDatastore(t *testing.T) {
+	client := requireDatastore(t)
+	ctx := context.Background()
+	config := &LimitterConfig{WindowSize: 60000, MaxRequestPerWindow: 3, ExpSec: 600}
+	userId := RandomString(16)
+	store := NewDatastoreBatchTrackerStore(client, DatastoreKindRequestTracker, 60000, 60000)
+	defer store.Close(ctx)
+	validate := func(tracker *RequestTracker) error {
+		return ValidateRequest(tracker, time.Now(), "/health", "", config)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := store.UpdateTracker(ctx, userId, "/health", config, validate)
+		assert.Nil(t, err, "Request accepted locally")
+	}
+	_, err := store.UpdateTracker(ctx, userId, "/health", config, validate)
+	assert.ErrorIs(t, err, ErrorRequestTooFreequently, "Request rejected locally")
+
+	_, trackerBefore, _ := LoadUserTracker(client, DatastoreKindRequestTracker, "/health", userId)
+	assert.Equal(t, int64(0), trackerBefore.WindowRequest, "Nothing written before flush")
+
+	assert.Nil(t, store.Flush(ctx), "Flush success")
+	_, trackerAfter, errLoad := LoadUserTracker(client, DatastoreKindRequestTracker, "/health", userId)
+	assert.Nil(t, errLoad, "Tracker saved")
+	assert.Equal(t, int64(3), trackerAfter.WindowRequest, "Accepted requests written")
+}
+
+// go test -timeout 30s -run ^TestDatastoreBatchLimitter_DropUserEntries_OnlyUserDropped$ github.com/zeroboo/gin-request-limitter -v
+func TestDatastoreBatchLimitter_DropUserEntries_OnlyUserDropped(t *testing.T) {
+	ctx := context.Background()
+	store := NewDatastoreBatchTrackerStore(nil, DatastoreKindRequestTracker, 60000, 60000)
+	defer store.Close(ctx)
+	for _, userId := range []string{"user1", "user2"} {
+		for _, url := range []string{"/health", "/ping"} {
+			entry := store.getEntry(ctx, userId, url)
+			entry.tracker = *NewRequestTracker(userId, url)
+			entry.loaded = true
+		}
+	}
+	dropped := store.getEntry(ctx, "user1", "/health")
+
+	assert.Equal(t, 2, store.dropUserEntries(ctx, "user1"), "Entries of user dropped")
+	assert.True(t, dropped.evicted, "Dropped entry evicted")
+	assert.Equal(t, 2, len(store.entries), "Entries of other user kept")
+	assert.Equal(t, 1, store.dropTrackerEntry(ctx, "user2", "/ping"), "Entry of tracker dropped")
+	assert.Equal(t, 1, len(store.entries), "Other entries kept")
+}
+
+// go test -timeout 30s -run ^TestDatastoreBatchLimitter_ResetTracker_PendingRequestsDropped$ github.com/zeroboo/gin-request-limitter -v
+func TestDatastoreBatchLimitter_ResetTracker_PendingRequestsDropped(t *testing.T) {
+	client := requireDatastore(t)
+	ctx := context.Background()
+	config := &LimitterConfig{WindowSize: 60000, MaxRequestPerWindow: 3, ExpSec: 600}
+	userId := RandomString(16)
+	store := NewDatastoreBatchTrackerStore(client, DatastoreKindRequestTracker, 60000, 60000)
+	defer store.Close(ctx)
+	validate := func(tracker *RequestTracker) error {
+		return ValidateRequest(tracker, time.Now(), "/health", "", config)
+	}
+	for i := 0; i < 3; i++ {
+		store.UpdateTracker(ctx, userId, "/health", config, validate)
+	}
+	assert.Nil(t, store.Flush(ctx), "Flush success")
+	store.UpdateTracker(ctx, userId, "/health", config, validate)
+
+	assert.Nil(t, store.ResetTracker(ctx, userId, "/health"), "Reset success")
+	assert.Nil(t, store.Flush(ctx), "Flush success")
+	_, errGet := store.GetTracker(ctx, userId, "/health")
+	assert.ErrorIs(t, errGet, ErrorTrackerNotFound, "Pending requests not written back after reset")
+
+	_, err := store.UpdateTracker(ctx, userId, "/health", config, validate)
+	assert.Nil(t, err, "Request accepted after reset")
+}
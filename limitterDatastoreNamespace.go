@@ -0,0 +1,140 @@
+/*
+Multi-tenant layout of trackers in datastore: namespaces per tenant and parent keys per user
+*/
+
+package limitter
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/datastore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Parent entities of trackers of kind K are of kind K + DatastoreUserKindSuffix, named by user id
+const DatastoreUserKindSuffix string = "_user"
+
+type trackerNamespaceContextKey struct{}
+
+func CreateUserKind(trackerKind string) string {
+	return trackerKind + DatastoreUserKindSuffix
+}
+
+// WithTrackerNamespace returns a context that stores trackers in given datastore namespace
+func WithTrackerNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, trackerNamespaceContextKey{}, namespace)
+}
+
+// GetTrackerNamespace returns namespace set by WithTrackerNamespace, found is false if not set
+func GetTrackerNamespace(ctx context.Context) (string, bool) {
+	namespace, found := ctx.Value(trackerNamespaceContextKey{}).(string)
+	return namespace, found
+}
+
+// GetNamespaceFromContextByField extracts namespace from a gin context by property name
+func GetNamespaceFromContextByField(namespaceField string) func(c *gin.Context) string {
+	return func(c *gin.Context) string {
+		return c.GetString(namespaceField)
+	}
+}
+
+// CreateNamespaceResolver returns a handler that sets tracker namespace of request, it must run before limitter
+func CreateNamespaceResolver(pNamespaceExtractor func(c *gin.Context) string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(WithTrackerNamespace(c.Request.Context(), pNamespaceExtractor(c)))
+	}
+}
+
+// getNamespace returns namespace of context, store namespace if context has none
+func (store *DatastoreTrackerStore) getNamespace(ctx context.Context) string {
+	if namespace, found := GetTrackerNamespace(ctx); found {
+		return namespace
+	}
+	return store.Namespace
+}
+
+func (store *DatastoreTrackerStore) getKeyCodec() TrackerKeyCodec {
+	if store.KeyCodec != nil {
+		return store.KeyCodec
+	}
+	return HashTrackerKeyCodec{}
+}
+
+// createUserKey returns parent key of trackers of userId in namespace of context, nil if store does not group by user
+// or userId is empty: a key can not have an empty name, so trackers of empty userId are not grouped
+func (store *DatastoreTrackerStore) createUserKey(ctx context.Context, userId string) *datastore.Key {
+	if !store.GroupByUser || len(userId) == 0 {
+		return nil
+	}
+	userKey := datastore.NameKey(CreateUserKind(store.Kind), userId, nil)
+	userKey.Namespace = store.getNamespace(ctx)
+	return userKey
+}
+
+/*
+CreateTrackerKey returns key of tracker of userId and url in namespace of context.
+If store groups by user, key is a child of user key so a user's trackers can be queried by ancestor
+*/
+func (store *DatastoreTrackerStore) CreateTrackerKey(ctx context.Context, userId string, url string) *datastore.Key {
+	trackerKey := datastore.NameKey(store.Kind, store.getKeyCodec().EncodeTrackerName(userId, url), store.createUserKey(ctx, userId))
+	trackerKey.Namespace = store.getNamespace(ctx)
+	return trackerKey
+}
+
+// createUserTrackersQuery returns query of trackers of a kind of userId in namespace of context
+func (store *DatastoreTrackerStore) createUserTrackersQuery(ctx context.Context, kind string, userId string) *datastore.Query {
+	query := datastore.NewQuery(kind).Namespace(store.getNamespace(ctx))
+	if userKey := store.createUserKey(ctx, userId); userKey != nil {
+		return query.Ancestor(userKey)
+	}
+	return query.FilterField("uid", "=", userId)
+}
+
+/*
+ListUserTrackers returns trackers of userId in namespace of context.
+Trackers are queried by ancestor if store groups by user, by indexed uid property otherwise or if userId is empty
+*/
+func (store *DatastoreTrackerStore) ListUserTrackers(ctx context.Context, userId string) ([]*datastore.Key, []*RequestTracker, error) {
+	trackers := []*RequestTracker{}
+	keys, errQuery := store.Client.GetAll(ctx, store.createUserTrackersQuery(ctx, store.Kind, userId), &trackers)
+	if errQuery != nil {
+		if _, isErrorFieldMismatch := errQuery.(*datastore.ErrFieldMismatch); !isErrorFieldMismatch {
+			return nil, nil, errQuery
+		}
+	}
+	return keys, trackers, nil
+}
+
+// DeleteUserTrackers deletes trackers and shards of userId in namespace of context, returns number of deleted entities
+func (store *DatastoreTrackerStore) DeleteUserTrackers(ctx context.Context, userId string) (int, error) {
+	deleted := 0
+	for _, kind := range []string{store.Kind, CreateShardKind(store.Kind)} {
+		kindDeleted, errDelete := deleteQueryKeys(ctx, store.Client, store.createUserTrackersQuery(ctx, kind, userId))
+		deleted += kindDeleted
+		if errDelete != nil {
+			return deleted, errDelete
+		}
+	}
+	log.Infof("DatastoreLimitter: UserTrackersDeleted, kind=%v, namespace=%v, userId=%v, deleted=%v",
+		store.Kind, store.getNamespace(ctx), userId, deleted)
+	return deleted, nil
+}
+
+/*
+ResetTrackerNamespace deletes all trackers, shards and user keys of kind in a namespace.
+It returns number of deleted entities
+*/
+func ResetTrackerNamespace(ctx context.Context, client *datastore.Client, kind string, namespace string) (int, error) {
+	deleted := 0
+	for _, resetKind := range []string{kind, CreateShardKind(kind), CreateUserKind(kind)} {
+		kindDeleted, errDelete := deleteQueryKeys(ctx, client, datastore.NewQuery(resetKind).Namespace(namespace))
+		deleted += kindDeleted
+		if errDelete != nil {
+			return deleted, fmt.Errorf("reset kind %v failed: %w", resetKind, errDelete)
+		}
+	}
+	log.Infof("ResetTrackerNamespace: Finish, kind=%v, namespace=%v, deleted=%v", kind, namespace, deleted)
+	return deleted, nil
+}
@@ -0,0 +1,102 @@
+package limitter
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestCreateTrackerKey_StaticNamespace_KeyInNamespace$ github.com/zeroboo/gin-request-limitter -v
+func TestCreateTrackerKey_StaticNamespace_KeyInNamespace(t *testing.T) {
+	store := &DatastoreTrackerStore{Kind: "tracker", Namespace: "tenant-a"}
+
+	key := store.CreateTrackerKey(context.Background(), "user", "/health")
+	assert.Equal(t, "tenant-a", key.Namespace, "Static namespace")
+	assert.Nil(t, key.Parent, "No parent")
+
+	key = store.CreateTrackerKey(WithTrackerNamespace(context.Background(), "tenant-b"), "user", "/health")
+	assert.Equal(t, "tenant-b", key.Namespace, "Namespace of context overrides static one")
+}
+
+// go test -timeout 30s -run ^TestCreateTrackerKey_GroupByUser_ChildOfUserKey$ github.com/zeroboo/gin-request-limitter -v
+func TestCreateTrackerKey_GroupByUser_ChildOfUserKey(t *testing.T) {
+	store := &DatastoreTrackerStore{Kind: "tracker", GroupByUser: true}
+
+	key := store.CreateTrackerKey(WithTrackerNamespace(context.Background(), "tenant"), "user", "/health")
+	assert.Equal(t, "tracker_user", key.Parent.Kind, "Parent kind")
+	assert.Equal(t, "user", key.Parent.Name, "Parent named by user")
+	assert.Equal(t, "tenant", key.Parent.Namespace, "Parent in same namespace")
+	assert.Equal(t, "tenant", CreateShardKeys(key, 2)[1].Namespace, "Shards in same namespace")
+}
+
+// go test -timeout 30s -run ^TestCreateTrackerKey_GroupByUserEmptyUser_NotGrouped$ github.com/zeroboo/gin-request-limitter -v
+func TestCreateTrackerKey_GroupByUserEmptyUser_NotGrouped(t *testing.T) {
+	store := &DatastoreTrackerStore{Kind: "tracker", GroupByUser: true}
+
+	key := store.CreateTrackerKey(context.Background(), "", "/health")
+	assert.Nil(t, key.Parent, "No parent of empty user")
+	assert.Nil(t, store.createUserKey(context.Background(), ""), "No user key of empty user")
+}
+
+// go test -timeout 30s -run ^TestListUserTrackers_GroupByUserEmptyUser_Listed$ github.com/zeroboo/gin-request-limitter -v
+func TestListUserTrackers_GroupByUserEmptyUser_Listed(t *testing.T) {
+	client := requireDatastore(t)
+	kind := "test_empty_user_" + RandomString(8)
+	config := &LimitterConfig{WindowSize: 60000, MaxRequestPerWindow: 5, ExpSec: 600}
+	store := &DatastoreTrackerStore{Client: client, Kind: kind, GroupByUser: true}
+	validate := func(tracker *RequestTracker) error {
+		return ValidateRequest(tracker, time.Now(), "/health", "", config)
+	}
+	_, err := store.UpdateTracker(context.Background(), "", "/health", config, validate)
+	assert.Nil(t, err, "Tracker of empty user saved")
+
+	_, trackers, errList := store.ListUserTrackers(context.Background(), "")
+	assert.Nil(t, errList, "List success")
+	assert.Equal(t, 1, len(trackers), "Tracker of empty user listed")
+	deleted, errDelete := store.DeleteUserTrackers(context.Background(), "")
+	assert.Nil(t, errDelete, "Delete success")
+	assert.Equal(t, 1, deleted, "Tracker of empty user deleted")
+}
+
+// go test -timeout 30s -run ^TestNamespaceResolver_NamespaceFromGinContext_SetOnRequest$ github.com/zeroboo/gin-request-limitter -v
+func TestNamespaceResolver_NamespaceFromGinContext_SetOnRequest(t *testing.T) {
+	namespace := ""
+	RecordRequest(http.MethodGet,
+		"/health",
+		map[string][]string{},
+		map[string][]string{},
+		func(c *gin.Context) { c.Set("tenant", "tenant-a") },
+		CreateNamespaceResolver(GetNamespaceFromContextByField("tenant")),
+		func(c *gin.Context) { namespace, _ = GetTrackerNamespace(c.Request.Context()) },
+	)
+	assert.Equal(t, "tenant-a", namespace, "Namespace set on request context")
+}
+
+// go test -timeout 30s -run ^TestResetTrackerNamespace_TenantTrackers_Deleted$ github.com/zeroboo/gin-request-limitter -v
+func TestResetTrackerNamespace_TenantTrackers_Deleted(t *testing.T) {
+	client := requireDatastore(t)
+	kind := "test_tenant_" + RandomString(8)
+	config := &LimitterConfig{WindowSize: 60000, MaxRequestPerWindow: 5, ExpSec: 600}
+	store := &DatastoreTrackerStore{Client: client, Kind: kind, GroupByUser: true}
+	validate := func(tracker *RequestTracker) error {
+		return ValidateRequest(tracker, time.Now(), "/health", "", config)
+	}
+	tenantCtx := WithTrackerNamespace(context.Background(), "tenant-a")
+	otherCtx := WithTrackerNamespace(context.Background(), "tenant-b")
+
+	_, err := store.UpdateTracker(tenantCtx, "user", "/health", config, validate)
+	assert.Nil(t, err, "Tracker of tenant saved")
+	_, err = store.UpdateTracker(otherCtx, "user", "/health", config, validate)
+	assert.Nil(t, err, "Tracker of other tenant saved")
+
+	deleted, errReset := ResetTrackerNamespace(context.Background(), client, kind, "tenant-a")
+	assert.Nil(t, errReset, "Reset success")
+	assert.Equal(t, 1, deleted, "Tracker of tenant deleted")
+
+	tracker := RequestTracker{}
+	assert.Nil(t, client.Get(context.Background(), store.CreateTrackerKey(otherCtx, "user", "/health"), &tracker), "Other tenant kept")
+}
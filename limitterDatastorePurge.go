@@ -0,0 +1,147 @@
+/*
+Garbage collection of expired trackers in datastore
+*/
+
+package limitter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
+)
+
+const DefaultSweepIntervalMilis int64 = 3600000
+
+// deleteQueryKeys pages through keys of a keys-only query and deletes each page in a batch, it returns number of deleted keys
+func deleteQueryKeys(ctx context.Context, client *datastore.Client, query *datastore.Query) (int, error) {
+	query = query.KeysOnly().Limit(DatastoreMaxBatchSize)
+	deleted := 0
+	for {
+		it := client.Run(ctx, query)
+		keys := make([]*datastore.Key, 0, DatastoreMaxBatchSize)
+		for {
+			key, errNext := it.Next(nil)
+			if errNext == iterator.Done {
+				break
+			}
+			if errNext != nil {
+				return deleted, fmt.Errorf("query keys failed: %w", errNext)
+			}
+			keys = append(keys, key)
+		}
+		if len(keys) == 0 {
+			return deleted, nil
+		}
+
+		if errDelete := client.DeleteMulti(ctx, keys); errDelete != nil {
+			return deleted, fmt.Errorf("delete keys failed: %w", errDelete)
+		}
+		deleted += len(keys)
+		if len(keys) < DatastoreMaxBatchSize {
+			return deleted, nil
+		}
+
+		cursor, errCursor := it.Cursor()
+		if errCursor != nil {
+			return deleted, errCursor
+		}
+		query = query.Start(cursor)
+	}
+}
+
+/*
+PurgeExpiredTrackers deletes trackers of kind expired before given time and returns number of deleted trackers.
+
+It pages through keys-only queries of DatastoreMaxBatchSize keys and deletes each page in a batch.
+Query filters on exp only so the built-in single-property index is enough,
+exp must not be excluded from indexes.
+Trackers are purged in namespace set by WithTrackerNamespace, default namespace if not set.
+*/
+func PurgeExpiredTrackers(ctx context.Context, client *datastore.Client, kind string, before time.Time) (int, error) {
+	namespace, _ := GetTrackerNamespace(ctx)
+	query := datastore.NewQuery(kind).Namespace(namespace).FilterField("exp", "<", before.UnixMilli())
+	deleted, errDelete := deleteQueryKeys(ctx, client, query)
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debugf("PurgeExpiredTrackers: Finish, kind=%v, namespace=%v, before=%v, deleted=%v, error=%v", kind, namespace, before, deleted, errDelete)
+	}
+	return deleted, errDelete
+}
+
+// TrackerSweeper purges expired trackers and their shards periodically
+type TrackerSweeper struct {
+	Client *datastore.Client
+	Kind   string
+
+	//Interval is time in milisecs between sweeps
+	Interval int64
+
+	//Namespaces to sweep, empty means default namespace only
+	Namespaces []string
+
+	//Clock tells which trackers expired, nil means SystemClock
+	Clock Clock
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartTrackerSweeper starts sweeping trackers of kind in background, Stop must be called to stop it
+func StartTrackerSweeper(client *datastore.Client, kind string, intervalMilis int64) *TrackerSweeper {
+	if intervalMilis <= 0 {
+		intervalMilis = DefaultSweepIntervalMilis
+	}
+	sweeper := &TrackerSweeper{
+		Client:   client,
+		Kind:     kind,
+		Interval: intervalMilis,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go sweeper.run()
+	log.Infof("TrackerSweeper: Start, kind=%v, interval=%v", kind, intervalMilis)
+	return sweeper
+}
+
+func (sweeper *TrackerSweeper) run() {
+	defer close(sweeper.done)
+	ticker := time.NewTicker(time.Duration(sweeper.Interval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sweeper.stop:
+			return
+		case <-ticker.C:
+			sweeper.Sweep(context.Background())
+		}
+	}
+}
+
+// Sweep purges trackers and shards expired before now of clock of sweeper
+func (sweeper *TrackerSweeper) Sweep(ctx context.Context) {
+	now := getClock(sweeper.Clock).Now()
+	namespaces := sweeper.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	for _, namespace := range namespaces {
+		namespaceCtx := WithTrackerNamespace(ctx, namespace)
+		for _, kind := range []string{sweeper.Kind, CreateShardKind(sweeper.Kind)} {
+			deleted, errPurge := PurgeExpiredTrackers(namespaceCtx, sweeper.Client, kind, now)
+			if errPurge != nil {
+				log.Errorf("TrackerSweeper: PurgeFailed, kind=%v, namespace=%v, deleted=%v, error=%v", kind, namespace, deleted, errPurge)
+			} else {
+				log.Infof("TrackerSweeper: Purged, kind=%v, namespace=%v, deleted=%v", kind, namespace, deleted)
+			}
+		}
+	}
+}
+
+// Stop stops sweeping and waits for running sweep
+func (sweeper *TrackerSweeper) Stop() {
+	close(sweeper.stop)
+	<-sweeper.done
+}
@@ -0,0 +1,65 @@
+package limitter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/zeroboo/gin-request-limitter/clocktest"
+)
+
+// go test -timeout 30s -run ^TestPurgeExpiredTrackers_ExpiredTrackers_Deleted$ github.com/zeroboo/gin-request-limitter -v
+func TestPurgeExpiredTrackers_ExpiredTrackers_Deleted(t *testing.T) {
+	client := requireDatastore(t)
+	ctx := context.Background()
+	kind := "test_purge_" + RandomString(8)
+	now := time.Now()
+
+	expiredKeys := []*datastore.Key{}
+	expiredTrackers := []*RequestTracker{}
+	for i := 0; i < DatastoreMaxBatchSize+10; i++ {
+		userId := RandomString(16)
+		expiredKeys = append(expiredKeys, datastore.NameKey(kind, CreateTrackerName(userId, "/health"), nil))
+		expiredTrackers = append(expiredTrackers, NewRequestTrackerWithExpiration(userId, "/health", now.Add(-time.Minute)))
+	}
+	for start := 0; start < len(expiredKeys); start += DatastoreMaxBatchSize {
+		end := start + DatastoreMaxBatchSize
+		if end > len(expiredKeys) {
+			end = len(expiredKeys)
+		}
+		_, errPut := client.PutMulti(ctx, expiredKeys[start:end], expiredTrackers[start:end])
+		assert.Nil(t, errPut, "Put expired trackers")
+	}
+	aliveKey := datastore.NameKey(kind, CreateTrackerName("alive", "/health"), nil)
+	_, errPut := client.Put(ctx, aliveKey, NewRequestTrackerWithExpiration("alive", "/health", now.Add(time.Minute)))
+	assert.Nil(t, errPut, "Put alive tracker")
+
+	deleted, errPurge := PurgeExpiredTrackers(ctx, client, kind, now)
+	assert.Nil(t, errPurge, "Purge success")
+	assert.Equal(t, len(expiredKeys), deleted, "Expired trackers deleted over pages")
+
+	alive := RequestTracker{}
+	assert.Nil(t, client.Get(ctx, aliveKey, &alive), "Alive tracker kept")
+}
+
+// go test -timeout 30s -run ^TestTrackerSweeper_FakeClock_PurgesByClock$ github.com/zeroboo/gin-request-limitter -v
+func TestTrackerSweeper_FakeClock_PurgesByClock(t *testing.T) {
+	client := requireDatastore(t)
+	ctx := context.Background()
+	kind := "test_sweep_" + RandomString(8)
+	clock := clocktest.NewFakeClock(time.Now())
+	sweeper := &TrackerSweeper{Client: client, Kind: kind, Clock: clock}
+	key := datastore.NameKey(kind, CreateTrackerName("user", "/health"), nil)
+	_, errPut := client.Put(ctx, key, NewRequestTrackerWithExpiration("user", "/health", clock.Now().Add(time.Minute)))
+	assert.Nil(t, errPut, "Put tracker")
+
+	sweeper.Sweep(ctx)
+	tracker := RequestTracker{}
+	assert.Nil(t, client.Get(ctx, key, &tracker), "Tracker alive at clock time kept")
+
+	clock.Advance(2 * time.Minute)
+	sweeper.Sweep(ctx)
+	assert.ErrorIs(t, client.Get(ctx, key, &tracker), datastore.ErrNoSuchEntity, "Tracker expired at clock time purged")
+}
@@ -0,0 +1,173 @@
+/*
+Sharded trackers in datastore: a tracker is spread over child entities to avoid write contention of one entity
+*/
+
+package limitter
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	log "github.com/sirupsen/logrus"
+)
+
+// Shards of trackers of kind K are entities of kind K + DatastoreShardKindSuffix
+const DatastoreShardKindSuffix string = "_shard"
+
+func CreateShardKind(trackerKind string) string {
+	return trackerKind + DatastoreShardKindSuffix
+}
+
+// CreateShardKeys returns keys of shards of a tracker, shards are children of tracker key
+func CreateShardKeys(trackerKey *datastore.Key, shardCount int) []*datastore.Key {
+	keys := make([]*datastore.Key, shardCount)
+	for i := 0; i < shardCount; i++ {
+		keys[i] = datastore.NameKey(CreateShardKind(trackerKey.Kind), strconv.Itoa(i), trackerKey)
+		keys[i].Namespace = trackerKey.Namespace
+	}
+	return keys
+}
+
+// AggregateShards returns a tracker of all shards: requests are summed in the latest window, other values are max,
+// ban and violations are the latest ones
+func AggregateShards(userId string, url string, shards []RequestTracker) *RequestTracker {
+	tracker := NewRequestTracker(userId, url)
+	for _, shard := range shards {
+		if shard.WindowNum > tracker.WindowNum {
+			tracker.WindowNum = shard.WindowNum
+			tracker.WindowRequest = 0
+		}
+		if shard.WindowNum == tracker.WindowNum {
+			tracker.WindowRequest += shard.WindowRequest
+		}
+		if shard.LastCall > tracker.LastCall {
+			tracker.LastCall = shard.LastCall
+		}
+		if shard.Exp > tracker.Exp {
+			tracker.Exp = shard.Exp
+		}
+		mergeTrackerPenalty(tracker, &shard)
+		tracker.RuleWindows = mergeRuleWindows(tracker.RuleWindows, shard.RuleWindows)
+	}
+	return tracker
+}
+
+// getShards reads shards by keys, missing shards are empty
+func getShards(ctx context.Context, client *datastore.Client, keys []*datastore.Key) ([]RequestTracker, error) {
+	shards := make([]RequestTracker, len(keys))
+	errGet := client.GetMulti(ctx, keys, shards)
+	if multiError, isMultiError := errGet.(datastore.MultiError); isMultiError {
+		for i, errShard := range multiError {
+			_, isErrorFieldMismatch := errShard.(*datastore.ErrFieldMismatch)
+			if errShard == nil || isErrorFieldMismatch {
+				continue
+			}
+			if errors.Is(errShard, datastore.ErrNoSuchEntity) {
+				shards[i] = RequestTracker{}
+				continue
+			}
+			return nil, errShard
+		}
+	} else if errGet != nil {
+		return nil, errGet
+	}
+	return shards, nil
+}
+
+// LoadShardedTracker returns aggregated tracker of shardCount shards
+func (store *DatastoreTrackerStore) LoadShardedTracker(ctx context.Context, userId string, url string, shardCount int) (*RequestTracker, error) {
+	trackerKey := store.CreateTrackerKey(ctx, userId, url)
+	shards, errGet := getShards(ctx, store.Client, CreateShardKeys(trackerKey, shardCount))
+	if errGet != nil {
+		return nil, errGet
+	}
+	return AggregateShards(userId, url, shards), nil
+}
+
+/*
+updateShardedTracker validates on aggregated shards then adds the request to a random shard in a transaction.
+Concurrent requests can be accepted on the same aggregated count, so limits are approximate.
+An expired aggregated tracker starts over: the request is saved on a new shard and other shards are deleted in the same transaction
+*/
+func (store *DatastoreTrackerStore) updateShardedTracker(ctx context.Context, userId string, url string, config *LimitterConfig,
+	validate func(tracker *RequestTracker) error) (*RequestTracker, error) {
+	trackerKey := store.CreateTrackerKey(ctx, userId, url)
+	shardKeys := CreateShardKeys(trackerKey, config.ShardCount)
+
+	loadCtx, cancelLoad := createTimeoutContext(ctx, config.LoadTimeout)
+	shards, errGet := getShards(loadCtx, store.Client, shardKeys)
+	cancelLoad()
+	if errGet != nil {
+		log.Errorf("LoadShardedTracker: Failed, kind=%v, url=%v, userId=%v, error=%v", store.Kind, url, userId, errGet)
+		return nil, errGet
+	}
+
+	currentTime := config.Now()
+	tracker := AggregateShards(userId, url, shards)
+	expired := tracker.IsExpired(currentTime)
+	if expired {
+		tracker = NewRequestTrackerWithExpiration(userId, url, config.CreateExpiration(currentTime))
+	}
+	loaded := *tracker
+	errValidate := validate(tracker)
+	saved := tracker
+	var pendingRequest int64 = tracker.WindowRequest
+	if tracker.WindowNum == loaded.WindowNum {
+		pendingRequest = tracker.WindowRequest - loaded.WindowRequest
+	}
+	if errValidate != nil {
+		if !tracker.IsPenaltyChanged(&loaded) {
+			return tracker, errValidate
+		}
+		saved = CreatePenalizedTracker(&loaded, tracker)
+		pendingRequest = 0
+	}
+	shardIndex := rand.Intn(len(shardKeys))
+	shardKey := shardKeys[shardIndex]
+
+	saveCtx, cancelSave := createTimeoutContext(ctx, config.SaveTimeout)
+	defer cancelSave()
+	_, errTx := store.Client.RunInTransaction(saveCtx, func(tx *datastore.Transaction) error {
+		shard := RequestTracker{}
+		if expired {
+			expiredKeys := append(append([]*datastore.Key{}, shardKeys[:shardIndex]...), shardKeys[shardIndex+1:]...)
+			if errDelete := tx.DeleteMulti(expiredKeys); errDelete != nil {
+				return errDelete
+			}
+		} else {
+			errShard := tx.Get(shardKey, &shard)
+			if errShard != nil && !errors.Is(errShard, datastore.ErrNoSuchEntity) {
+				if _, isErrorFieldMismatch := errShard.(*datastore.ErrFieldMismatch); !isErrorFieldMismatch {
+					return errShard
+				}
+			}
+		}
+		MergeTrackerRequests(&shard, saved, pendingRequest)
+		_, errPut := tx.Put(shardKey, &shard)
+		return errPut
+	})
+	if errTx != nil {
+		log.Errorf("RequestLimitter: UpdateShardFailed, UID=%v, key=%v, error=%v", userId, shardKey, errTx)
+		return tracker, errTx
+	}
+	return tracker, errValidate
+}
+
+/*
+CleanupShards deletes shards expired before given time in namespace of store or context and returns number of deleted shards.
+Shards no longer used after ShardCount of a policy is lowered are not written anymore, so they expire and are deleted like others.
+Shards of trackers in use are never deleted, whatever shard count their policies have
+*/
+func (store *DatastoreTrackerStore) CleanupShards(ctx context.Context, before time.Time) (int, error) {
+	shardKind := CreateShardKind(store.Kind)
+	deleted, errPurge := PurgeExpiredTrackers(WithTrackerNamespace(ctx, store.getNamespace(ctx)), store.Client, shardKind, before)
+	if errPurge != nil {
+		return deleted, errPurge
+	}
+	log.Infof("DatastoreLimitter: ShardsCleaned, kind=%v, before=%v, deleted=%v", shardKind, before, deleted)
+	return deleted, nil
+}
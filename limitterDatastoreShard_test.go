@@ -0,0 +1,98 @@
+package limitter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/zeroboo/gin-request-limitter/clocktest"
+)
+
+// go test -timeout 30s -run ^TestAggregateShards_RequestsOfLatestWindowSummed$ github.com/zeroboo/gin-request-limitter -v
+func TestAggregateShards_RequestsOfLatestWindowSummed(t *testing.T) {
+	tracker := AggregateShards("uid", "/health", []RequestTracker{
+		{WindowNum: 7, WindowRequest: 2, LastCall: 300, Exp: 900},
+		{WindowNum: 6, WindowRequest: 5, LastCall: 100, Exp: 800},
+		{WindowNum: 7, WindowRequest: 1, LastCall: 200, Exp: 1000},
+		{},
+	})
+
+	assert.Equal(t, int64(7), tracker.WindowNum, "Latest window")
+	assert.Equal(t, int64(3), tracker.WindowRequest, "Requests of old window ignored")
+	assert.Equal(t, int64(300), tracker.LastCall, "Latest call")
+	assert.Equal(t, int64(1000), tracker.Exp, "Latest expiration")
+}
+
+// go test -timeout 30s -run ^TestCreateShardKeys_ChildrenOfTracker$ github.com/zeroboo/gin-request-limitter -v
+func TestCreateShardKeys_ChildrenOfTracker(t *testing.T) {
+	trackerKey := datastore.NameKey("tracker", "abc", nil)
+	keys := CreateShardKeys(trackerKey, 3)
+
+	assert.Equal(t, 3, len(keys), "Key per shard")
+	assert.Equal(t, "tracker_shard", keys[2].Kind, "Shard kind")
+	assert.Equal(t, "2", keys[2].Name, "Shard index")
+	assert.Equal(t, trackerKey, keys[2].Parent, "Shard is child of tracker")
+}
+
+// go test -timeout 30s -run ^TestDatastoreShardedLimitter_ManyRequests_CountedOverShards$ github.com/zeroboo/gin-request-limitter -v
+func TestDatastoreShardedLimitter_ManyRequests_CountedOverShards(t *testing.T) {
+	client := requireDatastore(t)
+	ctx := context.Background()
+	config := &LimitterConfig{WindowSize: 60000, MaxRequestPerWindow: 5, ExpSec: 600, ShardCount: 4}
+	store := NewDatastoreTrackerStore(client, DatastoreKindRequestTracker)
+	userId := RandomString(16)
+	validate := func(tracker *RequestTracker) error {
+		return ValidateRequest(tracker, time.Now(), "/health", "", config)
+	}
+
+	for i := 0; i < 5; i++ {
+		_, err := store.UpdateTracker(ctx, userId, "/health", config, validate)
+		assert.Nil(t, err, "Request accepted")
+	}
+	_, err := store.UpdateTracker(ctx, userId, "/health", config, validate)
+	assert.ErrorIs(t, err, ErrorRequestTooFreequently, "Requests of all shards counted")
+
+	tracker, errLoad := store.LoadShardedTracker(ctx, userId, "/health", config.ShardCount)
+	assert.Nil(t, errLoad, "Load shards success")
+	assert.Equal(t, int64(5), tracker.WindowRequest, "Accepted requests")
+
+	_, errCleanup := store.CleanupShards(ctx, time.Now())
+	assert.Nil(t, errCleanup, "Cleanup success")
+	tracker, _ = store.LoadShardedTracker(ctx, userId, "/health", config.ShardCount)
+	assert.Equal(t, int64(5), tracker.WindowRequest, "Shards in use kept")
+	deleted, errCleanup := store.CleanupShards(ctx, time.Now().Add(time.Duration(config.ExpSec+1)*time.Second))
+	assert.Nil(t, errCleanup, "Cleanup success")
+	assert.GreaterOrEqual(t, deleted, 1, "Expired shards deleted")
+}
+
+// go test -timeout 30s -run ^TestDatastoreShardedLimitter_Expired_StartedOver$ github.com/zeroboo/gin-request-limitter -v
+func TestDatastoreShardedLimitter_Expired_StartedOver(t *testing.T) {
+	client := requireDatastore(t)
+	ctx := context.Background()
+	clock := clocktest.NewFakeClock(time.Now())
+	config := &LimitterConfig{MinRequestInterval: 1000, WindowSize: 60000, MaxRequestPerWindow: 5, ExpSec: 600, ShardCount: 4,
+		BanThreshold: 2, BanDurations: []int64{60000}, Clock: clock}
+	store := NewDatastoreTrackerStore(client, DatastoreKindRequestTracker)
+	userId := RandomString(16)
+	send := func() (*RequestTracker, error) {
+		currentTime := config.Now()
+		return store.UpdateTracker(ctx, userId, "/health", config, func(tracker *RequestTracker) error {
+			return ValidateRequest(tracker, currentTime, "/health", "", config)
+		})
+	}
+	for i := 0; i < 4; i++ {
+		send()
+	}
+
+	clock.Advance(time.Duration(config.ExpSec+1) * time.Second)
+	tracker, err := send()
+	assert.Nil(t, err, "Request of expired tracker allowed")
+	assert.Equal(t, int64(1), tracker.WindowRequest, "Tracker started over")
+	assert.Equal(t, int64(0), tracker.BanLevel, "Ban of expired tracker forgotten")
+
+	loaded, _ := store.LoadShardedTracker(ctx, userId, "/health", config.ShardCount)
+	assert.Equal(t, int64(0), loaded.Violations, "Violations of other shards deleted")
+	assert.Equal(t, int64(1), loaded.WindowRequest, "Requests of other shards deleted")
+}
@@ -21,6 +21,7 @@ const FieldNameUserId string = "userId"
 
 // go test -timeout 30s -run ^TestLimitter_ValidGETRequest_Correct$ github.com/zeroboo/gin-request-limitter -v
 func TestLimitter_ValidGETRequest_Correct(t *testing.T) {
+	requireDatastore(t)
 	recorder := RecordRequest(http.MethodGet,
 		"/health",
 		map[string][]string{},
@@ -48,6 +49,7 @@ var testHandlersSet []gin.HandlerFunc = []gin.HandlerFunc{
 
 // go test -timeout 30s -run ^TestLimitter_MultiRequestTooFast_ResponseError$ github.com/zeroboo/gin-request-limitter -v
 func TestLimitter_MultiRequestTooFast_ResponseError(t *testing.T) {
+	requireDatastore(t)
 	userId := "test-too-fast"
 
 	var minimumIntervalMilisecs int64 = 1000
@@ -92,6 +94,7 @@ func TestLimitter_MultiRequestTooFast_ResponseError(t *testing.T) {
 
 // go test -timeout 30s -run ^TestLimitter_MultiRequestNotTooFast_Success$ github.com/zeroboo/gin-request-limitter -v
 func TestLimitter_MultiRequestNotTooFast_Success(t *testing.T) {
+	requireDatastore(t)
 	userId := fmt.Sprintf("test-too-fast-%v", time.Now().UnixMilli())
 	interval := int64(200)
 	recorder := RecordRequest(http.MethodGet,
@@ -126,6 +129,7 @@ func TestLimitter_MultiRequestNotTooFast_Success(t *testing.T) {
 
 // go test -timeout 30s -run ^TestLimitter_TooFrequentlyRequests_ResponseError$ github.com/zeroboo/gin-request-limitter -v
 func TestLimitter_TooFrequentlyRequests_ResponseError(t *testing.T) {
+	requireDatastore(t)
 	userId := fmt.Sprintf("test-too-fast-%v", time.Now().Unix())
 	interval := int64(10)
 	limitter := CreateDatastoreBackedLimitterHandler(dsClient, DatastoreKindRequestTracker,
@@ -157,6 +161,7 @@ func TestLimitter_TooFrequentlyRequests_ResponseError(t *testing.T) {
 
 // go test -timeout 30s -run ^TestLimitterTooFreequently_NewWindow_RequestSuccess$ github.com/zeroboo/gin-request-limitter -v
 func TestLimitterTooFreequently_NewWindow_RequestSuccess(t *testing.T) {
+	requireDatastore(t)
 	userId := fmt.Sprintf("test-too-fast-%v", time.Now().Unix())
 	interval := int64(10)
 	windowSize := int64(1000)
@@ -0,0 +1,104 @@
+/*
+net/http adapter of limitters, for the standard library, chi and other routers of http.Handler
+*/
+
+package limitter
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// HttpRejectionWriter writes response of a request rejected by decision, headers of decision are already set
+type HttpRejectionWriter func(w http.ResponseWriter, r *http.Request, decision *Decision)
+
+// WriteHttpRejection writes status of decision with no body
+func WriteHttpRejection(w http.ResponseWriter, r *http.Request, decision *Decision) {
+	w.WriteHeader(decision.GetStatus())
+}
+
+// HttpRejectionBody is JSON body written by WriteHttpJSONRejection
+type HttpRejectionBody struct {
+	Error string `json:"error"`
+
+	//RetryAfter is secs to wait before retrying, 0 if unknown
+	RetryAfter int64 `json:"retryAfter,omitempty"`
+}
+
+// WriteHttpJSONRejection writes status of decision and a HttpRejectionBody
+func WriteHttpJSONRejection(w http.ResponseWriter, r *http.Request, decision *Decision) {
+	body := HttpRejectionBody{Error: decision.Err.Error()}
+	body.RetryAfter, _ = strconv.ParseInt(decision.Header.Get("Retry-After"), 10, 64)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(decision.GetStatus())
+	json.NewEncoder(w).Encode(body)
+}
+
+// GetUserIdFromHeader extracts userId from a request header
+func GetUserIdFromHeader(header string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		return r.Header.Get(header)
+	}
+}
+
+// GetUserIdFromContextValue extracts userId from a string value of request context, e.g. set by an authentication middleware
+func GetUserIdFromContextValue(key interface{}) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		userId, _ := r.Context().Value(key).(string)
+		return userId
+	}
+}
+
+// GetUserIdFromQuery extracts userId from a query parameter
+func GetUserIdFromQuery(param string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		return r.URL.Query().Get(param)
+	}
+}
+
+// GetClientIP returns host of remote address of request. Proxies are not trusted, put a real ip middleware before limitter behind one
+func GetClientIP(r *http.Request) string {
+	host, _, errSplit := net.SplitHostPort(r.RemoteAddr)
+	if errSplit != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+/*
+CreateHttpLimitter returns a net/http middleware that persists trackers in given store, see Limitter.Decide.
+
+Headers of decision are set on response. Served requests go to next handler,
+rejected ones are written by writeRejection, nil means WriteHttpRejection
+*/
+func CreateHttpLimitter(pStore TrackerStore,
+	pUserIdExtractor func(r *http.Request) string,
+	pConfig *LimitterConfig,
+	pWriteRejection HttpRejectionWriter) func(next http.Handler) http.Handler {
+	limitter := NewLimitter(pStore, pConfig)
+	if pWriteRejection == nil {
+		pWriteRejection = WriteHttpRejection
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			decision := limitter.Decide(r.Context(), pUserIdExtractor(r), r.URL.Path, GetClientIP(r), r.Header)
+			copyHeader(w.Header(), decision.Header)
+			if !decision.IsAllowed() {
+				pWriteRejection(w, r, decision)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CreateHttpNamespaceResolver returns a net/http middleware that sets tracker namespace of request, it must run before limitter
+func CreateHttpNamespaceResolver(pNamespaceExtractor func(r *http.Request) string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(WithTrackerNamespace(r.Context(), pNamespaceExtractor(r))))
+		})
+	}
+}
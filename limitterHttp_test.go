@@ -0,0 +1,106 @@
+package limitter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveHttp sends a request of userId to path through middleware and returns response, next handler responds OK
+func serveHttp(middleware func(next http.Handler) http.Handler, userId string, path string) *httptest.ResponseRecorder {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("X-User-Id", userId)
+	recorder := httptest.NewRecorder()
+	middleware(next).ServeHTTP(recorder, req)
+	return recorder
+}
+
+// go test -timeout 30s -run ^TestHttpLimitter_SameDecisionsAsGin$ github.com/zeroboo/gin-request-limitter -v
+func TestHttpLimitter_SameDecisionsAsGin(t *testing.T) {
+	config := limitterTestConfigLongWindow
+	config.MinRequestInterval = 0
+	middleware := CreateHttpLimitter(&mapTrackerStore{}, GetUserIdFromHeader("X-User-Id"), &config, nil)
+	handler := CreateStoreBackedLimitter(&mapTrackerStore{}, GetUserIdFromContextByField(FieldNameUserId), &config, true)
+	authHandler := CreateFakeAuthenticationHandler(FieldNameUserId, "user")
+
+	httpStatuses := []int{}
+	ginStatuses := []int{}
+	for i := 0; i < 3; i++ {
+		httpStatuses = append(httpStatuses, serveHttp(middleware, "user", "/health").Code)
+		ginStatuses = append(ginStatuses, RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{},
+			authHandler, handler, HandleHealth).Code)
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, httpStatuses, "Statuses of net/http limitter")
+	assert.Equal(t, ginStatuses, httpStatuses, "Same decisions as gin limitter")
+	assert.Equal(t, http.StatusOK, serveHttp(middleware, "other", "/health").Code, "Other user served")
+}
+
+// go test -timeout 30s -run ^TestHttpLimitter_Rejected_HeadersAndJSONBody$ github.com/zeroboo/gin-request-limitter -v
+func TestHttpLimitter_Rejected_HeadersAndJSONBody(t *testing.T) {
+	config := limitterTestConfigLongWindow
+	config.MinRequestInterval = 0
+	config.MaxRequestPerWindow = 1
+	middleware := CreateHttpLimitter(&mapTrackerStore{}, GetUserIdFromHeader("X-User-Id"), &config, WriteHttpJSONRejection)
+
+	serveHttp(middleware, "user", "/health")
+	recorder := serveHttp(middleware, "user", "/health")
+
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code, "Window full")
+	assert.Equal(t, RULE_NAME_WINDOW, recorder.Header().Get(HeaderLimitRule), "Rule header")
+	assert.NotEmpty(t, recorder.Header().Get("Retry-After"), "Retry header")
+	body := HttpRejectionBody{}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &body), "JSON body")
+	assert.Contains(t, body.Error, ErrorRequestTooFreequently.Error(), "Error in body")
+	assert.Greater(t, body.RetryAfter, int64(0), "Retry time in body")
+}
+
+// go test -timeout 30s -run ^TestHttpLimitter_AccessList_DeniedWithoutStore$ github.com/zeroboo/gin-request-limitter -v
+func TestHttpLimitter_AccessList_DeniedWithoutStore(t *testing.T) {
+	accessList, errList := NewAccessList(AccessRules{DenyCIDRs: []string{"192.0.2.0/24"}})
+	assert.Nil(t, errList, "Access list created")
+	config := limitterTestConfigLongWindow
+	config.AccessList = accessList
+	store := &mapTrackerStore{}
+
+	recorder := serveHttp(CreateHttpLimitter(store, GetUserIdFromHeader("X-User-Id"), &config, nil), "user", "/health")
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code, "Client ip of remote address denied")
+	assert.Equal(t, 0, store.Calls, "Store not called")
+}
+
+type testContextKey struct{}
+
+// go test -timeout 30s -run ^TestHttpUserIdExtractors$ github.com/zeroboo/gin-request-limitter -v
+func TestHttpUserIdExtractors(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/health?uid=query-user", nil)
+	req.Header.Set("X-User-Id", "header-user")
+	req = req.WithContext(context.WithValue(req.Context(), testContextKey{}, "context-user"))
+	req.RemoteAddr = "10.0.0.1:5000"
+
+	assert.Equal(t, "header-user", GetUserIdFromHeader("X-User-Id")(req), "User id of header")
+	assert.Equal(t, "context-user", GetUserIdFromContextValue(testContextKey{})(req), "User id of context")
+	assert.Equal(t, "query-user", GetUserIdFromQuery("uid")(req), "User id of query")
+	assert.Equal(t, "10.0.0.1", GetClientIP(req), "Client ip")
+}
+
+// go test -timeout 30s -run ^TestHttpNamespaceResolver_NamespaceOfRequest$ github.com/zeroboo/gin-request-limitter -v
+func TestHttpNamespaceResolver_NamespaceOfRequest(t *testing.T) {
+	namespace := ""
+	resolver := CreateHttpNamespaceResolver(GetUserIdFromHeader("X-Tenant"))
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace, _ = GetTrackerNamespace(r.Context())
+	})
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set("X-Tenant", "tenant")
+	resolver(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "tenant", namespace, "Namespace set")
+}
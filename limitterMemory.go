@@ -0,0 +1,246 @@
+/*
+In-memory tracker store of current process, used by tests, simulations and client side limits
+*/
+
+package limitter
+
+import (
+	"container/list"
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryTrackerKey is key of a tracker in memory, namespace is empty if context has none
+type memoryTrackerKey struct {
+	namespace string
+	userId    string
+	url       string
+}
+
+func createMemoryTrackerKey(ctx context.Context, userId string, url string) memoryTrackerKey {
+	namespace, _ := GetTrackerNamespace(ctx)
+	return memoryTrackerKey{namespace: namespace, userId: userId, url: url}
+}
+
+type memoryTrackerEntry struct {
+	key     memoryTrackerKey
+	tracker RequestTracker
+}
+
+/*
+MemoryTrackerStore keeps trackers in memory of current process, it follows TrackerStore contract like persistent stores do.
+Trackers are kept per namespace of context set by WithTrackerNamespace, as datastore stores do.
+
+Expired trackers are dropped when accessed or when they are least recently used.
+If MaxTrackers is positive, least recently used trackers over MaxTrackers are dropped too
+*/
+type MemoryTrackerStore struct {
+	//Clock tells expiration of trackers to admin calls, nil means SystemClock. Requests are timed by clock of their config
+	Clock Clock
+
+	//MaxTrackers is max number of kept trackers, 0 means no limit
+	MaxTrackers int
+
+	mutex    sync.Mutex
+	trackers map[memoryTrackerKey]*list.Element
+	lru      *list.List
+	calls    int
+}
+
+func NewMemoryTrackerStore(maxTrackers int) *MemoryTrackerStore {
+	return &MemoryTrackerStore{
+		MaxTrackers: maxTrackers,
+		trackers:    map[memoryTrackerKey]*list.Element{},
+		lru:         list.New(),
+	}
+}
+
+// get returns tracker of key if it has not expired at currentTime and marks it recently used. Caller must hold mutex
+func (store *MemoryTrackerStore) get(key memoryTrackerKey, currentTime time.Time) (RequestTracker, bool) {
+	element, found := store.trackers[key]
+	if !found {
+		return RequestTracker{}, false
+	}
+	entry := element.Value.(*memoryTrackerEntry)
+	if entry.tracker.IsExpired(currentTime) {
+		store.remove(key)
+		return RequestTracker{}, false
+	}
+	store.lru.MoveToFront(element)
+	return entry.tracker, true
+}
+
+// put saves tracker of key as the most recently used one, expired and least recently used trackers over MaxTrackers are dropped.
+// Caller must hold mutex
+func (store *MemoryTrackerStore) put(key memoryTrackerKey, tracker RequestTracker, currentTime time.Time) {
+	if element, found := store.trackers[key]; found {
+		element.Value.(*memoryTrackerEntry).tracker = tracker
+		store.lru.MoveToFront(element)
+	} else {
+		store.trackers[key] = store.lru.PushFront(&memoryTrackerEntry{key: key, tracker: tracker})
+	}
+	for back := store.lru.Back(); back != nil; back = store.lru.Back() {
+		entry := back.Value.(*memoryTrackerEntry)
+		if (store.MaxTrackers <= 0 || store.lru.Len() <= store.MaxTrackers) && !entry.tracker.IsExpired(currentTime) {
+			return
+		}
+		store.remove(entry.key)
+	}
+}
+
+// remove drops tracker of key. Caller must hold mutex
+func (store *MemoryTrackerStore) remove(key memoryTrackerKey) {
+	if element, found := store.trackers[key]; found {
+		store.lru.Remove(element)
+		delete(store.trackers, key)
+	}
+}
+
+// UpdateTracker validates tracker in namespace of context and saves it, rejected requests are not counted, only their penalty is saved
+func (store *MemoryTrackerStore) UpdateTracker(ctx context.Context, userId string, url string, config *LimitterConfig,
+	validate func(tracker *RequestTracker) error) (*RequestTracker, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.calls += 1
+	if errCtx := ctx.Err(); errCtx != nil {
+		return nil, errCtx
+	}
+
+	currentTime := config.Now()
+	key := createMemoryTrackerKey(ctx, userId, url)
+	loaded, found := store.get(key, currentTime)
+	if !found {
+		loaded = *NewRequestTrackerWithExpiration(userId, url, config.CreateExpiration(currentTime))
+	}
+	tracker := loaded
+	errValidate := validate(&tracker)
+	if errValidate != nil {
+		if tracker.IsPenaltyChanged(&loaded) {
+			store.put(key, *CreatePenalizedTracker(&loaded, &tracker), currentTime)
+		}
+		return &tracker, errValidate
+	}
+	store.put(key, tracker, currentTime)
+	return &tracker, nil
+}
+
+// GetCalls returns number of UpdateTracker calls
+func (store *MemoryTrackerStore) GetCalls() int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.calls
+}
+
+// Size returns number of kept trackers, expired ones not dropped yet included
+func (store *MemoryTrackerStore) Size() int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return len(store.trackers)
+}
+
+// SetTracker saves a copy of tracker as is in default namespace, e.g. to prepare a test
+func (store *MemoryTrackerStore) SetTracker(tracker *RequestTracker) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.put(createMemoryTrackerKey(context.Background(), tracker.UID, tracker.URL), *tracker, getClock(store.Clock).Now())
+}
+
+func (store *MemoryTrackerStore) GetTracker(ctx context.Context, userId string, url string) (*RequestTracker, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	tracker, found := store.get(createMemoryTrackerKey(ctx, userId, url), getClock(store.Clock).Now())
+	if !found {
+		return nil, ErrorTrackerNotFound
+	}
+	return &tracker, nil
+}
+
+// listKeys returns keys of trackers not expired matching filter. Caller must hold mutex
+func (store *MemoryTrackerStore) listKeys(filter func(key memoryTrackerKey) bool) []memoryTrackerKey {
+	now := getClock(store.Clock).Now()
+	keys := []memoryTrackerKey{}
+	for key, element := range store.trackers {
+		tracker := element.Value.(*memoryTrackerEntry).tracker
+		if tracker.IsExpired(now) {
+			store.remove(key)
+		} else if filter(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// listTrackers returns copies of trackers matching filter ordered by url then user id. Caller must hold mutex
+func (store *MemoryTrackerStore) listTrackers(filter func(key memoryTrackerKey) bool) []*RequestTracker {
+	trackers := []*RequestTracker{}
+	for _, key := range store.listKeys(filter) {
+		tracker := store.trackers[key].Value.(*memoryTrackerEntry).tracker
+		trackers = append(trackers, &tracker)
+	}
+	sort.Slice(trackers, func(i, j int) bool {
+		if trackers[i].URL != trackers[j].URL {
+			return trackers[i].URL < trackers[j].URL
+		}
+		return trackers[i].UID < trackers[j].UID
+	})
+	return trackers
+}
+
+// isMemoryUserKey returns a filter of trackers of userId in namespace of context
+func isMemoryUserKey(ctx context.Context, userId string) func(key memoryTrackerKey) bool {
+	namespace, _ := GetTrackerNamespace(ctx)
+	return func(key memoryTrackerKey) bool { return key.namespace == namespace && key.userId == userId }
+}
+
+func (store *MemoryTrackerStore) GetUserTrackers(ctx context.Context, userId string) ([]*RequestTracker, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.listTrackers(isMemoryUserKey(ctx, userId)), nil
+}
+
+func (store *MemoryTrackerStore) ResetTracker(ctx context.Context, userId string, url string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.remove(createMemoryTrackerKey(ctx, userId, url))
+	return nil
+}
+
+func (store *MemoryTrackerStore) ResetUserTrackers(ctx context.Context, userId string) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	keys := store.listKeys(isMemoryUserKey(ctx, userId))
+	for _, key := range keys {
+		store.remove(key)
+	}
+	return len(keys), nil
+}
+
+// LiftBan clears ban and violations of tracker, requests and window of tracker are kept
+func (store *MemoryTrackerStore) LiftBan(ctx context.Context, userId string, url string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	key := createMemoryTrackerKey(ctx, userId, url)
+	now := getClock(store.Clock).Now()
+	tracker, found := store.get(key, now)
+	if !found {
+		return ErrorTrackerNotFound
+	}
+	tracker.ClearBan()
+	store.put(key, tracker, now)
+	return nil
+}
+
+// ForEachTracker calls fn on copies of all trackers of all namespaces until fn returns an error, fn can call other methods of store
+func (store *MemoryTrackerStore) ForEachTracker(ctx context.Context, fn func(tracker *RequestTracker) error) error {
+	store.mutex.Lock()
+	trackers := store.listTrackers(func(key memoryTrackerKey) bool { return true })
+	store.mutex.Unlock()
+	for _, tracker := range trackers {
+		if errFn := fn(tracker); errFn != nil {
+			return errFn
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package limitter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zeroboo/gin-request-limitter/clocktest"
+)
+
+// sendMemoryRequest validates a request of url on store at current time of config
+func sendMemoryRequest(store *MemoryTrackerStore, config *LimitterConfig, url string) error {
+	currentTime := config.Now()
+	_, err := store.UpdateTracker(context.Background(), "", url, config, func(tracker *RequestTracker) error {
+		return ValidateRequest(tracker, currentTime, url, "", config)
+	})
+	return err
+}
+
+// go test -timeout 30s -run ^TestMemoryTrackerStore_Expired_Dropped$ github.com/zeroboo/gin-request-limitter -v
+func TestMemoryTrackerStore_Expired_Dropped(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.UnixMilli(1000000))
+	config := &LimitterConfig{WindowSize: 1000, MaxRequestPerWindow: 1, ExpSec: 60, Clock: clock}
+	store := NewMemoryTrackerStore(10)
+
+	sendMemoryRequest(store, config, "host/a")
+	sendMemoryRequest(store, config, "host/b")
+	assert.Equal(t, 2, store.Size(), "Trackers of paths kept")
+
+	clock.Advance(2 * time.Minute)
+	sendMemoryRequest(store, config, "host/c")
+	assert.Equal(t, 1, store.Size(), "Expired trackers dropped")
+}
+
+// go test -timeout 30s -run ^TestMemoryTrackerStore_Full_LeastRecentlyUsedDropped$ github.com/zeroboo/gin-request-limitter -v
+func TestMemoryTrackerStore_Full_LeastRecentlyUsedDropped(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.UnixMilli(1000000))
+	config := &LimitterConfig{WindowSize: 60000, MaxRequestPerWindow: 1, ExpSec: 600, Clock: clock}
+	store := NewMemoryTrackerStore(2)
+
+	sendMemoryRequest(store, config, "host/a")
+	sendMemoryRequest(store, config, "host/b")
+	sendMemoryRequest(store, config, "host/a")
+	sendMemoryRequest(store, config, "host/c")
+
+	assert.Equal(t, 2, store.Size(), "Store bounded")
+	assert.ErrorIs(t, sendMemoryRequest(store, config, "host/a"), ErrorRequestTooFreequently, "Recently used tracker kept")
+	assert.Nil(t, sendMemoryRequest(store, config, "host/b"), "Least recently used tracker dropped")
+}
+
+// go test -timeout 30s -run ^TestMemoryTrackerStore_NoMaxTrackers_AllKept$ github.com/zeroboo/gin-request-limitter -v
+func TestMemoryTrackerStore_NoMaxTrackers_AllKept(t *testing.T) {
+	config := &LimitterConfig{WindowSize: 60000, MaxRequestPerWindow: 1, ExpSec: 600}
+	store := NewMemoryTrackerStore(0)
+
+	for i := 0; i < 100; i++ {
+		sendMemoryRequest(store, config, RandomString(8))
+	}
+	assert.Equal(t, 100, store.Size(), "All trackers kept")
+}
@@ -37,75 +37,296 @@ func CreateRedisTrackerKey(pUserId string, pUrl string) string {
 }
 
 func LoadRedisRequestTracker(ctx context.Context, rClient *redis.Client, userId string, url string) (*RequestTracker, error) {
+	tracker, _, errGetTracker := loadRedisTrackerVersion(ctx, rClient, userId, url)
+	return tracker, errGetTracker
+}
+
+// Field of tracker hash increased by every save, so a save can detect that tracker was changed after it was loaded
+const redisVersionField string = "ver"
+
+// Max attempts of an update of a tracker changed concurrently by other requests
+const RedisMaxUpdateAttempts int = 100
+
+var ErrorTrackerConflict = fmt.Errorf("tracker is changed concurrently")
+
+// loadRedisTrackerVersion loads tracker and its version, version of a missing tracker is "0"
+func loadRedisTrackerVersion(ctx context.Context, rClient *redis.Client, userId string, url string) (*RequestTracker, string, error) {
 	trackerKey := CreateRedisTrackerKey(userId, url)
 	var tracker *RequestTracker = NewRequestTracker(userId, url)
-	errGetTracker := rClient.HGetAll(ctx, trackerKey).Scan(tracker)
-	return tracker, errGetTracker
+	cmd := rClient.HGetAll(ctx, trackerKey)
+	if errGetTracker := cmd.Scan(tracker); errGetTracker != nil {
+		return tracker, "", errGetTracker
+	}
+	version, found := cmd.Val()[redisVersionField]
+	if !found {
+		version = "0"
+	}
+	return tracker, version, nil
 }
 
-func SaveRedisRequestTracker(ctx context.Context, rClient *redis.Client, tracker *RequestTracker, expireSecond int64) error {
-	_, errSetTracker := rdb.Pipelined(ctx, func(rdb redis.Pipeliner) error {
+/*
+redisCompareAndSetScript saves fields of tracker if its version is the loaded one and increases version.
+KEYS: tracker.
+ARGV: loaded version, tracker expiration unix milisec, expiration in seconds if tracker has none, then field-value pairs of tracker.
+Returns 1 if tracker is saved, 0 if it was changed after loaded
+*/
+var redisCompareAndSetScript = redis.NewScript(`
+local version = redis.call('HGET', KEYS[1], 'ver') or '0'
+if version ~= ARGV[1] then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'ver', tonumber(version) + 1, unpack(ARGV, 4))
+local expireAt = tonumber(ARGV[2])
+local expireSecond = tonumber(ARGV[3])
+if expireAt > 0 then
+	redis.call('PEXPIREAT', KEYS[1], expireAt)
+elseif expireSecond > 0 then
+	redis.call('EXPIRE', KEYS[1], expireSecond)
+end
+return 1
+`)
+
+// createRedisTrackerFields returns field-value pairs of tracker that differ from previous, all fields if previous is nil
+func createRedisTrackerFields(previous *RequestTracker, tracker *RequestTracker) []interface{} {
+	fields := make([]interface{}, 0, 22)
+	if previous == nil || previous.UID != tracker.UID {
+		fields = append(fields, "uid", tracker.UID)
+	}
+	if previous == nil || previous.URL != tracker.URL {
+		fields = append(fields, "url", tracker.URL)
+	}
+	if previous == nil || previous.WindowNum != tracker.WindowNum {
+		fields = append(fields, "winNum", tracker.WindowNum)
+	}
+	if previous == nil || previous.WindowRequest != tracker.WindowRequest {
+		fields = append(fields, "winReq", tracker.WindowRequest)
+	}
+	if previous == nil || previous.LastCall != tracker.LastCall {
+		fields = append(fields, "last", tracker.LastCall)
+	}
+	if previous == nil || previous.Exp != tracker.Exp {
+		fields = append(fields, "exp", tracker.Exp)
+	}
+	if previous == nil || previous.Violations != tracker.Violations {
+		fields = append(fields, "vio", tracker.Violations)
+	}
+	if previous == nil || previous.ViolationStart != tracker.ViolationStart {
+		fields = append(fields, "vioStart", tracker.ViolationStart)
+	}
+	if previous == nil || previous.BanLevel != tracker.BanLevel {
+		fields = append(fields, "banLvl", tracker.BanLevel)
+	}
+	if previous == nil || previous.BanUntil != tracker.BanUntil {
+		fields = append(fields, "banUntil", tracker.BanUntil)
+	}
+	if previous == nil || previous.RuleWindows != tracker.RuleWindows {
+		fields = append(fields, "rules", tracker.RuleWindows)
+	}
+	return fields
+}
+
+// saveRedisTrackerFields writes fields by one HSET and expires tracker at tracker.Exp in one round trip.
+// If tracker has no Exp, it expires after expireSecond, 0 means no expiration
+func saveRedisTrackerFields(ctx context.Context, rClient *redis.Client, tracker *RequestTracker, fields []interface{}, expireSecond int64) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	_, errSetTracker := rClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
 		trackerKey := CreateRedisTrackerKey(tracker.UID, tracker.URL)
-		rdb.HSet(ctx, trackerKey, "uid", tracker.UID)
-		rdb.HSet(ctx, trackerKey, "url", tracker.URL)
-		rdb.HSet(ctx, trackerKey, "winNum", tracker.WindowNum)
-		rdb.HSet(ctx, trackerKey, "winReq", tracker.WindowRequest)
-		rdb.HSet(ctx, trackerKey, "last", tracker.LastCall)
-		rdb.HSet(ctx, trackerKey, "exp", tracker.Exp)
-		if expireSecond > 0 {
-			rdb.Expire(ctx, trackerKey, time.Duration(expireSecond)*time.Second)
+		pipe.HSet(ctx, trackerKey, fields...)
+		pipe.HIncrBy(ctx, trackerKey, redisVersionField, 1)
+		if tracker.Exp > 0 {
+			pipe.PExpireAt(ctx, trackerKey, time.UnixMilli(tracker.Exp))
+		} else if expireSecond > 0 {
+			pipe.Expire(ctx, trackerKey, time.Duration(expireSecond)*time.Second)
 		}
-
 		return nil
 	})
 	return errSetTracker
 }
 
-func CreateRedisBackedLimitter(pUserIdExtractor func(c *gin.Context) string,
-	pConfig *LimitterConfig, pIsMiddleware bool) func(c *gin.Context) {
+// SaveRedisRequestTracker writes all fields of tracker
+func SaveRedisRequestTracker(ctx context.Context, rClient *redis.Client, tracker *RequestTracker, expireSecond int64) error {
+	return saveRedisTrackerFields(ctx, rClient, tracker, createRedisTrackerFields(nil, tracker), expireSecond)
+}
+
+// RedisTrackerStore persists trackers as hashes in redis.
+// Client nil means the client created by InitRedis
+type RedisTrackerStore struct {
+	Client *redis.Client
+}
 
-	return func(c *gin.Context) {
+func NewRedisTrackerStore(client *redis.Client) *RedisTrackerStore {
+	return &RedisTrackerStore{
+		Client: client,
+	}
+}
 
-		// Sets the name/ID for the new entity.
-		userId := pUserIdExtractor(c)
-		url := c.Request.URL.Path
-		currentTime := time.Now()
+func (store *RedisTrackerStore) getClient() *redis.Client {
+	if store.Client != nil {
+		return store.Client
+	}
+	return rdb
+}
 
-		//Validate too fast request
-		trackerKey := CreateRedisTrackerKey(userId, url)
+/*
+UpdateTracker loads tracker, validates it and saves changed fields if tracker was not changed since loaded.
+If it was, tracker is loaded and validated again, up to RedisMaxUpdateAttempts times.
+Rejected requests are not counted, only their penalty is saved
+*/
+func (store *RedisTrackerStore) UpdateTracker(ctx context.Context, userId string, url string, config *LimitterConfig,
+	validate func(tracker *RequestTracker) error) (*RequestTracker, error) {
+	if config.HasLevelLimits() {
+		return store.updateHierarchicalTracker(ctx, userId, url, config, validate)
+	}
+	client := store.getClient()
+	trackerKey := CreateRedisTrackerKey(userId, url)
 
-		tracker, errGetTracker := LoadRedisRequestTracker(c.Request.Context(), rdb, userId, url)
+	for attempt := 1; ; attempt++ {
+		loadCtx, cancelLoad := createTimeoutContext(ctx, config.LoadTimeout)
+		tracker, version, errGetTracker := loadRedisTrackerVersion(loadCtx, client, userId, url)
+		cancelLoad()
 		if errGetTracker != nil {
-			log.Errorf("RedisLimitter: userId=%v, url=%v, error=%v", userId, url, errGetTracker)
+			return tracker, errGetTracker
 		}
 
-		errValidate := ValidateRequest(tracker, currentTime, url, c.ClientIP(), pConfig)
-		// if log.IsLevelEnabled(log.TraceLevel) {
-		// 	log.Tracef("TrackerAfter: %v", tracker)
-		// }
-
-		errSetTracker := SaveRedisRequestTracker(c.Request.Context(), rdb, tracker, pConfig.ExpSec)
-		if errValidate == nil {
-			if errSetTracker != nil && pConfig.AbortOnFail {
-				log.Errorf("RedisLimitter: SaveTrackerFailed, userId=%v, key=%v, error=%v", userId, trackerKey, errSetTracker)
-				errValidate = errSetTracker
+		loaded := *tracker
+		var previous *RequestTracker
+		if tracker.LastCall > 0 {
+			previous = &loaded
+		}
+		errValidate := validate(tracker)
+		saved := tracker
+		if errValidate != nil {
+			if !tracker.IsPenaltyChanged(&loaded) {
+				return tracker, errValidate
 			}
-		} else {
-			log.Errorf("RedisLimitter: ValidateTrackerFailed, userId=%v, key=%v, sinceLastCall=%v, error=%v",
-				userId, trackerKey, currentTime.UnixMilli()-tracker.LastCall, errValidate)
+			saved = CreatePenalizedTracker(&loaded, tracker)
+		}
+
+		args := append([]interface{}{version, saved.Exp, config.ExpSec}, createRedisTrackerFields(previous, saved)...)
+		saveCtx, cancelSave := createTimeoutContext(ctx, config.SaveTimeout)
+		isSaved, errSetTracker := redisCompareAndSetScript.Run(saveCtx, client, []string{trackerKey}, args...).Int()
+		cancelSave()
+		if errSetTracker != nil {
+			return tracker, errSetTracker
+		}
+		if isSaved == 1 {
+			return tracker, errValidate
+		}
+		if attempt >= RedisMaxUpdateAttempts {
+			log.Errorf("RedisRequestLimitter: UpdateConflicted, UID=%v, url=%v, attempts=%v", userId, url, attempt)
+			return tracker, ErrorTrackerConflict
+		}
+	}
+}
+
+func CreateRedisBackedLimitter(pUserIdExtractor func(c *gin.Context) string,
+	pConfig *LimitterConfig, pIsMiddleware bool) func(c *gin.Context) {
+	return CreateStoreBackedLimitter(NewRedisTrackerStore(nil), pUserIdExtractor, pConfig, pIsMiddleware)
+}
+
+// escapeRedisPattern escapes glob characters of redis SCAN pattern
+func escapeRedisPattern(value string) string {
+	escaped := make([]rune, 0, len(value))
+	for _, char := range value {
+		switch char {
+		case '*', '?', '[', ']', '\\':
+			escaped = append(escaped, '\\')
+		}
+		escaped = append(escaped, char)
+	}
+	return string(escaped)
+}
+
+// scanUserTrackerKeys returns keys of trackers of userId
+func (store *RedisTrackerStore) scanUserTrackerKeys(ctx context.Context, userId string) ([]string, error) {
+	pattern := escapeRedisPattern(CreateRedisTrackerKey(userId, "")) + "*"
+	keys := []string{}
+	iter := store.getClient().Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+func (store *RedisTrackerStore) GetTracker(ctx context.Context, userId string, url string) (*RequestTracker, error) {
+	tracker, err := LoadRedisRequestTracker(ctx, store.getClient(), userId, url)
+	if err != nil {
+		return nil, err
+	}
+	if tracker.LastCall == 0 {
+		return nil, ErrorTrackerNotFound
+	}
+	return tracker, nil
+}
+
+// GetUserTrackers scans trackers of userId, trackers of users whose id has userId as prefix are filtered out
+func (store *RedisTrackerStore) GetUserTrackers(ctx context.Context, userId string) ([]*RequestTracker, error) {
+	keys, errScan := store.scanUserTrackerKeys(ctx, userId)
+	if errScan != nil {
+		return nil, errScan
+	}
+	trackers := []*RequestTracker{}
+	for _, key := range keys {
+		tracker := &RequestTracker{}
+		if errGet := store.getClient().HGetAll(ctx, key).Scan(tracker); errGet != nil {
+			return nil, errGet
 		}
+		if tracker.UID == userId {
+			trackers = append(trackers, tracker)
+		}
+	}
+	return trackers, nil
+}
+
+func (store *RedisTrackerStore) ResetTracker(ctx context.Context, userId string, url string) error {
+	return store.getClient().Del(ctx, CreateRedisTrackerKey(userId, url)).Err()
+}
 
-		ProcessValidateResult(errValidate, c, pIsMiddleware)
-		if log.IsLevelEnabled(log.TraceLevel) {
-			log.Tracef("RequestLimitter: ValidateFinish, UID=%v, url=%v, IP=%v, calls=%v|%v, window=%v/%v|%v, key=%v, errValidate=%v",
-				tracker.UID,
-				url,
-				c.ClientIP(),
-				currentTime.UnixMilli()-tracker.LastCall, pConfig.MinRequestInterval,
-				tracker.WindowRequest, pConfig.MaxRequestPerWindow, tracker.WindowNum,
-				trackerKey,
-				errValidate,
-			)
+// LiftBan clears ban and violations of tracker, requests and window of tracker are kept
+func (store *RedisTrackerStore) LiftBan(ctx context.Context, userId string, url string) error {
+	tracker, errGet := store.GetTracker(ctx, userId, url)
+	if errGet != nil {
+		return errGet
+	}
+	loaded := *tracker
+	tracker.ClearBan()
+	return saveRedisTrackerFields(ctx, store.getClient(), tracker, createRedisTrackerFields(&loaded, tracker), 0)
+}
+
+func (store *RedisTrackerStore) ResetUserTrackers(ctx context.Context, userId string) (int, error) {
+	trackers, errGet := store.GetUserTrackers(ctx, userId)
+	if errGet != nil {
+		return 0, errGet
+	}
+	keys := make([]string, len(trackers))
+	for i, tracker := range trackers {
+		keys[i] = CreateRedisTrackerKey(tracker.UID, tracker.URL)
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	deleted, errDelete := store.getClient().Del(ctx, keys...).Result()
+	return int(deleted), errDelete
+}
+
+// ForEachTracker scans all trackers of current prefix and environment and calls fn on each of them until fn returns an error
+func (store *RedisTrackerStore) ForEachTracker(ctx context.Context, fn func(tracker *RequestTracker) error) error {
+	pattern := escapeRedisPattern(fmt.Sprintf("%v:%v:", keyPrefix, environment)) + "*"
+	iter := store.getClient().Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		tracker := &RequestTracker{}
+		if errGet := store.getClient().HGetAll(ctx, iter.Val()).Scan(tracker); errGet != nil {
+			return errGet
+		}
+		if tracker.LastCall == 0 {
+			//Expired between scan and get
+			continue
+		}
+		if errFn := fn(tracker); errFn != nil {
+			return errFn
 		}
 	}
+	return iter.Err()
 }
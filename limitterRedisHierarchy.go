@@ -0,0 +1,261 @@
+/*
+Hierarchical limits in redis: a request is checked against global, tenant and user buckets and charged only if all admit it
+*/
+
+package limitter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+const LIMIT_LEVEL_GLOBAL string = "global"
+const LIMIT_LEVEL_TENANT string = "tenant"
+
+var ErrorLevelLimitExceeded = fmt.Errorf("request exceeds limit of level")
+
+// LevelLimit is a fixed window limit of a bucket shared by many users
+type LevelLimit struct {
+	//Window frame in milisec. Value 0 means no limit
+	WindowSize int64 `json:"windowSize"`
+
+	//Max requests per window of all users of bucket
+	MaxRequestPerWindow int64 `json:"maxRequestPerWindow"`
+}
+
+func (limit *LevelLimit) isEnabled() bool {
+	return limit.WindowSize > 0
+}
+
+// HasLevelLimits returns true if policy has a global or tenant limit
+func (config *LimitterConfig) HasLevelLimits() bool {
+	return config.GlobalLimit.isEnabled() || config.TenantLimit.isEnabled()
+}
+
+// CreateRedisLevelKey returns key of bucket of a level, id is tenant of tenant bucket and empty for global bucket
+func CreateRedisLevelKey(level string, id string, url string) string {
+	return fmt.Sprintf("%v#%v:%v:%v:%v", keyPrefix, level, environment, id, url)
+}
+
+// redisLevelBucket is a level bucket of a request
+type redisLevelBucket struct {
+	level string
+	key   string
+	limit LevelLimit
+}
+
+// createRedisLevelBuckets returns enabled buckets of a request, from global to tenant.
+// Tenant is namespace of context set by WithTrackerNamespace, tenant limit is skipped if there is no tenant
+func createRedisLevelBuckets(ctx context.Context, url string, config *LimitterConfig) []redisLevelBucket {
+	buckets := make([]redisLevelBucket, 0, 2)
+	if config.GlobalLimit.isEnabled() {
+		buckets = append(buckets, redisLevelBucket{
+			level: LIMIT_LEVEL_GLOBAL,
+			key:   CreateRedisLevelKey(LIMIT_LEVEL_GLOBAL, "", url),
+			limit: config.GlobalLimit,
+		})
+	}
+	if tenant, found := GetTrackerNamespace(ctx); found && len(tenant) > 0 && config.TenantLimit.isEnabled() {
+		buckets = append(buckets, redisLevelBucket{
+			level: LIMIT_LEVEL_TENANT,
+			key:   CreateRedisLevelKey(LIMIT_LEVEL_TENANT, tenant, url),
+			limit: config.TenantLimit,
+		})
+	}
+	return buckets
+}
+
+/*
+redisChargeScript saves user tracker if its version is the loaded one, then checks user window and all level buckets,
+then charges buckets and saves user tracker. Nothing is written if tracker was changed, user window or a bucket is full.
+KEYS: level buckets then user tracker.
+ARGV: now, number of buckets, window size and max requests of each bucket, loaded version of tracker, user window size and max requests,
+tracker expiration unix milisec, expiration in seconds if tracker has none, then field-value pairs of tracker.
+Returns 0 if request is charged, index of the first full bucket, REDIS_CHARGE_CONFLICT or REDIS_CHARGE_USER_FULL otherwise
+*/
+var redisChargeScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local count = tonumber(ARGV[2])
+local offset = 3 + 2 * count
+local trackerKey = KEYS[count + 1]
+local version = redis.call('HGET', trackerKey, 'ver') or '0'
+if version ~= ARGV[offset] then
+	return -1
+end
+local userSize = tonumber(ARGV[offset + 1])
+if userSize > 0 then
+	local user = redis.call('HMGET', trackerKey, 'winNum', 'winReq')
+	if tonumber(user[1]) == math.floor(now / userSize) and (tonumber(user[2]) or 0) + 1 > tonumber(ARGV[offset + 2]) then
+		return -2
+	end
+end
+local windows = {}
+for i = 1, count do
+	local size = tonumber(ARGV[1 + 2 * i])
+	local max = tonumber(ARGV[2 + 2 * i])
+	local window = math.floor(now / size)
+	local bucket = redis.call('HMGET', KEYS[i], 'winNum', 'winReq')
+	local request = 0
+	if tonumber(bucket[1]) == window then
+		request = tonumber(bucket[2]) or 0
+	end
+	if request + 1 > max then
+		return i
+	end
+	windows[i] = {window, request + 1}
+end
+for i = 1, count do
+	local size = tonumber(ARGV[1 + 2 * i])
+	redis.call('HSET', KEYS[i], 'winNum', windows[i][1], 'winReq', windows[i][2])
+	redis.call('PEXPIREAT', KEYS[i], (windows[i][1] + 2) * size)
+end
+redis.call('HSET', trackerKey, 'ver', tonumber(version) + 1, unpack(ARGV, offset + 5))
+local expireAt = tonumber(ARGV[offset + 3])
+local expireSecond = tonumber(ARGV[offset + 4])
+if expireAt > 0 then
+	redis.call('PEXPIREAT', trackerKey, expireAt)
+elseif expireSecond > 0 then
+	redis.call('EXPIRE', trackerKey, expireSecond)
+end
+return 0
+`)
+
+// Results of redisChargeScript other than a full bucket
+const REDIS_CHARGE_CONFLICT int = -1
+const REDIS_CHARGE_USER_FULL int = -2
+
+// getLevelBucketRequest returns requests of bucket in window of currentTime
+func getLevelBucketRequest(values []interface{}, bucket redisLevelBucket, currentTime time.Time) int64 {
+	if len(values) != 2 || values[0] == nil || values[1] == nil {
+		return 0
+	}
+	windowNum, _ := strconv.ParseInt(fmt.Sprint(values[0]), 10, 64)
+	if windowNum != currentTime.UnixMilli()/bucket.limit.WindowSize {
+		return 0
+	}
+	windowRequest, _ := strconv.ParseInt(fmt.Sprint(values[1]), 10, 64)
+	return windowRequest
+}
+
+// createLevelLimitError returns a rejection by bucket named by its level, retry time is start of next window of bucket
+func createLevelLimitError(bucket redisLevelBucket, currentTime time.Time) error {
+	windowSize := bucket.limit.WindowSize
+	return &RuleLimitError{
+		Rule:      bucket.level,
+		Err:       ErrorLevelLimitExceeded,
+		RetryTime: (currentTime.UnixMilli()/windowSize + 1) * windowSize,
+	}
+}
+
+/*
+updateHierarchicalTracker loads user tracker with its version and level buckets in one pipelined round trip and validates user tracker.
+Accepted request is charged to all buckets and user tracker in one atomic script call, which saves tracker only if its version
+is the loaded one and checks user window and buckets again. If tracker was changed concurrently, it is loaded and validated again,
+up to RedisMaxUpdateAttempts times. A request rejected by a level is not counted in user tracker nor in any bucket.
+
+A request costs 2 round trips per attempt: the load and the script call, only a request rejected by a full bucket at load costs 1.
+The script call takes one more round trip when redis does not have the script cached yet.
+
+The script touches global, tenant and user keys which hash to different slots, so level limits need a single redis node:
+Redis Cluster rejects the script with CROSSSLOT
+*/
+func (store *RedisTrackerStore) updateHierarchicalTracker(ctx context.Context, userId string, url string, config *LimitterConfig,
+	validate func(tracker *RequestTracker) error) (*RequestTracker, error) {
+	client := store.getClient()
+	currentTime := config.Now()
+	buckets := createRedisLevelBuckets(ctx, url, config)
+	trackerKey := CreateRedisTrackerKey(userId, url)
+
+	for attempt := 1; ; attempt++ {
+		loadCtx, cancelLoad := createTimeoutContext(ctx, config.LoadTimeout)
+		pipe := client.Pipeline()
+		trackerCmd := pipe.HGetAll(loadCtx, trackerKey)
+		bucketCmds := make([]*redis.SliceCmd, len(buckets))
+		for i, bucket := range buckets {
+			bucketCmds[i] = pipe.HMGet(loadCtx, bucket.key, "winNum", "winReq")
+		}
+		_, errLoad := pipe.Exec(loadCtx)
+		cancelLoad()
+		if errLoad != nil && errLoad != redis.Nil {
+			return nil, errLoad
+		}
+		tracker := NewRequestTracker(userId, url)
+		if errScan := trackerCmd.Scan(tracker); errScan != nil {
+			return nil, errScan
+		}
+		version, found := trackerCmd.Val()[redisVersionField]
+		if !found {
+			version = "0"
+		}
+
+		//Full buckets reject without writing
+		for i, bucket := range buckets {
+			if getLevelBucketRequest(bucketCmds[i].Val(), bucket, currentTime)+1 > bucket.limit.MaxRequestPerWindow {
+				return tracker, createLevelLimitError(bucket, currentTime)
+			}
+		}
+
+		loaded := *tracker
+		var previous *RequestTracker
+		if tracker.LastCall > 0 {
+			previous = &loaded
+		}
+		errValidate := validate(tracker)
+		if errValidate != nil {
+			if !tracker.IsPenaltyChanged(&loaded) {
+				return tracker, errValidate
+			}
+			penalized := CreatePenalizedTracker(&loaded, tracker)
+			args := append([]interface{}{version, penalized.Exp, config.ExpSec}, createRedisTrackerFields(previous, penalized)...)
+			saveCtx, cancelSave := createTimeoutContext(ctx, config.SaveTimeout)
+			isSaved, errSave := redisCompareAndSetScript.Run(saveCtx, client, []string{trackerKey}, args...).Int()
+			cancelSave()
+			if errSave != nil {
+				return tracker, errSave
+			}
+			if isSaved == 1 {
+				return tracker, errValidate
+			}
+		} else {
+			keys := make([]string, 0, len(buckets)+1)
+			args := make([]interface{}, 0, 7+2*len(buckets)+22)
+			args = append(args, currentTime.UnixMilli(), len(buckets))
+			for _, bucket := range buckets {
+				keys = append(keys, bucket.key)
+				args = append(args, bucket.limit.WindowSize, bucket.limit.MaxRequestPerWindow)
+			}
+			keys = append(keys, trackerKey)
+			args = append(args, version, config.WindowSize, config.MaxRequestPerWindow, tracker.Exp, config.ExpSec)
+			args = append(args, createRedisTrackerFields(previous, tracker)...)
+
+			saveCtx, cancelSave := createTimeoutContext(ctx, config.SaveTimeout)
+			result, errCharge := redisChargeScript.Run(saveCtx, client, keys, args...).Int()
+			cancelSave()
+			if errCharge != nil {
+				log.Errorf("RequestLimitter: ChargeFailed, UID=%v, url=%v, error=%v", userId, url, errCharge)
+				return tracker, errCharge
+			}
+			switch {
+			case result == 0:
+				return tracker, nil
+			case result > 0:
+				return &loaded, createLevelLimitError(buckets[result-1], currentTime)
+			case result == REDIS_CHARGE_USER_FULL:
+				return &loaded, &RuleLimitError{
+					Rule:      RULE_NAME_WINDOW,
+					Err:       ErrorRequestTooFreequently,
+					RetryTime: (currentTime.UnixMilli()/config.WindowSize + 1) * config.WindowSize,
+				}
+			}
+		}
+		if attempt >= RedisMaxUpdateAttempts {
+			log.Errorf("RedisRequestLimitter: UpdateConflicted, UID=%v, url=%v, attempts=%v", userId, url, attempt)
+			return tracker, ErrorTrackerConflict
+		}
+	}
+}
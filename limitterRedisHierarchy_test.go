@@ -0,0 +1,110 @@
+package limitter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+var limitterTestConfigLevels LimitterConfig = LimitterConfig{
+	MinRequestInterval:  200,
+	WindowSize:          6000000,
+	MaxRequestPerWindow: 10,
+	ExpSec:              600,
+	GlobalLimit:         LevelLimit{WindowSize: 6000000, MaxRequestPerWindow: 3},
+	TenantLimit:         LevelLimit{WindowSize: 6000000, MaxRequestPerWindow: 2},
+}
+
+func getRedisLevelRequest(level string, id string, url string) string {
+	return rdb.HGet(context.Background(), CreateRedisLevelKey(level, id, url), "winReq").Val()
+}
+
+// go test -timeout 30s -run ^TestRedisHierarchy_GlobalLimit_SharedByUsers$ github.com/zeroboo/gin-request-limitter -v
+func TestRedisHierarchy_GlobalLimit_SharedByUsers(t *testing.T) {
+	url := "/" + RandomString(16)
+	config := limitterTestConfigLevels
+	config.TenantLimit = LevelLimit{}
+	handler := CreateRedisBackedLimitter(GetUserIdFromContextByField(FieldNameUserId), &config, false)
+
+	codes := []int{}
+	userIds := []string{}
+	var recorder *httptest.ResponseRecorder
+	for i := 0; i < 4; i++ {
+		userIds = append(userIds, RandomString(16))
+		recorder = RecordRequest(http.MethodGet, url, map[string][]string{}, map[string][]string{},
+			CreateFakeAuthenticationHandler(FieldNameUserId, userIds[i]), handler, HandleHealth)
+		codes = append(codes, recorder.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes, "Global limit reached")
+	assert.Equal(t, "3", getRedisLevelRequest(LIMIT_LEVEL_GLOBAL, "", url), "Rejected request not charged")
+	assert.Equal(t, LIMIT_LEVEL_GLOBAL, recorder.Header().Get(HeaderLimitRule), "Level reported as rule")
+	assert.NotEmpty(t, recorder.Header().Get("Retry-After"), "Retry time of level reported")
+	_, errGet := NewRedisTrackerStore(nil).GetTracker(context.Background(), userIds[3], url)
+	assert.Equal(t, ErrorTrackerNotFound, errGet, "Request rejected by level not counted for user")
+}
+
+// go test -timeout 30s -run ^TestRedisHierarchy_TenantLimit_SeparatedByTenant$ github.com/zeroboo/gin-request-limitter -v
+func TestRedisHierarchy_TenantLimit_SeparatedByTenant(t *testing.T) {
+	url := "/" + RandomString(16)
+	config := limitterTestConfigLevels
+	config.GlobalLimit = LevelLimit{WindowSize: 6000000, MaxRequestPerWindow: 100}
+	handler := CreateRedisBackedLimitter(GetUserIdFromContextByField(FieldNameUserId), &config, false)
+	tenantA, tenantB := RandomString(8), RandomString(8)
+
+	send := func(tenant string) int {
+		resolver := CreateNamespaceResolver(func(c *gin.Context) string { return tenant })
+		recorder := RecordRequest(http.MethodGet, url, map[string][]string{}, map[string][]string{},
+			CreateFakeAuthenticationHandler(FieldNameUserId, RandomString(16)), resolver, handler, HandleHealth)
+		return recorder.Code
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests, http.StatusOK},
+		[]int{send(tenantA), send(tenantA), send(tenantA), send(tenantB)}, "Tenant limit per tenant")
+	assert.Equal(t, "2", getRedisLevelRequest(LIMIT_LEVEL_TENANT, tenantA, url), "Tenant bucket charged")
+	assert.Equal(t, "3", getRedisLevelRequest(LIMIT_LEVEL_GLOBAL, "", url), "Global bucket charged by accepted requests")
+}
+
+// go test -timeout 30s -run ^TestRedisHierarchy_UserRejected_LevelsNotCharged$ github.com/zeroboo/gin-request-limitter -v
+func TestRedisHierarchy_UserRejected_LevelsNotCharged(t *testing.T) {
+	url := "/" + RandomString(16)
+	handler := CreateRedisBackedLimitter(GetUserIdFromContextByField(FieldNameUserId), &limitterTestConfigLevels, false)
+	authHandler := CreateFakeAuthenticationHandler(FieldNameUserId, RandomString(16))
+
+	recorder := RecordRequest(http.MethodGet, url, map[string][]string{}, map[string][]string{}, authHandler, handler, HandleHealth)
+	assert.Equal(t, http.StatusOK, recorder.Code, "First request accepted")
+	recorder = RecordRequest(http.MethodGet, url, map[string][]string{}, map[string][]string{}, authHandler, handler, HandleHealth)
+	assert.Equal(t, http.StatusTooEarly, recorder.Code, "User limit rejects")
+
+	assert.Equal(t, "1", getRedisLevelRequest(LIMIT_LEVEL_GLOBAL, "", url), "Request rejected by user not charged")
+}
+
+// go test -timeout 30s -run ^TestRedisHierarchy_AcceptedRequest_SameRoundTripsAsUserLimit$ github.com/zeroboo/gin-request-limitter -v
+func TestRedisHierarchy_AcceptedRequest_SameRoundTripsAsUserLimit(t *testing.T) {
+	counter := &redisCommandCounter{}
+	client := redis.NewClient(rdb.Options())
+	client.AddHook(counter)
+	defer client.Close()
+	store := NewRedisTrackerStore(client)
+	config := limitterTestConfigLevels
+	config.MinRequestInterval = 0
+	config.GlobalLimit.MaxRequestPerWindow = 100
+	url := "/" + RandomString(16)
+	validate := func(tracker *RequestTracker) error {
+		return ValidateRequest(tracker, time.Now(), url, "", &config)
+	}
+
+	_, errUpdate := store.UpdateTracker(WithTrackerNamespace(context.Background(), "tenant"), RandomString(16), url, &config, validate)
+	assert.Nil(t, errUpdate, "First request loads script")
+	counter.RoundTrips = 0
+	_, errUpdate = store.UpdateTracker(WithTrackerNamespace(context.Background(), "tenant"), RandomString(16), url, &config, validate)
+
+	assert.Nil(t, errUpdate, "Request accepted")
+	assert.Equal(t, int64(2), counter.RoundTrips, "One round trip to load all levels, one to charge all levels")
+}
@@ -1,12 +1,15 @@
 package limitter
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"testing"
 	"time"
 
-	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
+	"github.com/zeroboo/gin-request-limitter/clocktest"
 )
 
 var limitterTestConfig LimitterConfig = LimitterConfig{
@@ -28,8 +31,6 @@ var limitterTestConfigShortWindow LimitterConfig = LimitterConfig{
 	MaxRequestPerWindow: 1,
 	ExpSec:              600,
 }
-var limitterHandlerShortWindow func(c *gin.Context) = CreateRedisBackedLimitter(GetUserIdFromContextByField(FieldNameUserId), &limitterTestConfigShortWindow, false)
-var limitterHandlerLongWindow func(c *gin.Context) = CreateRedisBackedLimitter(GetUserIdFromContextByField(FieldNameUserId), &limitterTestConfigLongWindow, false)
 
 // go.exe test -timeout 30s -run ^TestRedisLimitter_FirstRequest_HasError$ github.com/zeroboo/gin-request-limitter -v
 func TestRedisLimitter_FirstRequest_HasError(t *testing.T) {
@@ -75,36 +76,40 @@ func TestRedisLimitter_RequestTooFast_HasError(t *testing.T) {
 // go.exe test -timeout 30s -run ^TestRedisLimitter_MultipleRequestsObeyInterval_NoError$ github.com/zeroboo/gin-request-limitter -v
 func TestRedisLimitter_MultipleRequestsObeyInterval_NoError(t *testing.T) {
 	userId := RandomString(16)
+	clock := clocktest.NewFakeClock(time.Now())
+	config := limitterTestConfig
+	config.Clock = clock
+	handler := CreateRedisBackedLimitter(GetUserIdFromContextByField(FieldNameUserId), &config, false)
 	recorder := RecordRequest(http.MethodGet,
 		"/health",
 		map[string][]string{},
 		map[string][]string{},
 		CreateFakeAuthenticationHandler(FieldNameUserId, userId),
-		CreateRedisBackedLimitter(GetUserIdFromContextByField(FieldNameUserId), &limitterTestConfig, false),
+		handler,
 		HandleHealth,
 	)
 	assert.Equal(t, http.StatusOK, recorder.Code, "Response success")
 	assert.Equal(t, "OK", recorder.Body.String(), "Response body success")
 
-	time.Sleep(time.Duration(limitterTestConfig.MinRequestInterval+100) * time.Millisecond)
+	clock.Advance(time.Duration(config.MinRequestInterval+100) * time.Millisecond)
 	recorder2 := RecordRequest(http.MethodGet,
 		"/health",
 		map[string][]string{},
 		map[string][]string{},
 		CreateFakeAuthenticationHandler(FieldNameUserId, userId),
-		CreateRedisBackedLimitter(GetUserIdFromContextByField(FieldNameUserId), &limitterTestConfig, false),
+		handler,
 		HandleHealth,
 	)
 	assert.Equal(t, http.StatusOK, recorder2.Code, "Response success")
 	assert.Equal(t, "OK", recorder2.Body.String(), "Response body success")
 
-	time.Sleep(time.Duration(limitterTestConfig.MinRequestInterval+100) * time.Millisecond)
+	clock.Advance(time.Duration(config.MinRequestInterval+100) * time.Millisecond)
 	recorder3 := RecordRequest(http.MethodGet,
 		"/health",
 		map[string][]string{},
 		map[string][]string{},
 		CreateFakeAuthenticationHandler(FieldNameUserId, userId),
-		CreateRedisBackedLimitter(GetUserIdFromContextByField(FieldNameUserId), &limitterTestConfig, false),
+		handler,
 		HandleHealth,
 	)
 	assert.Equal(t, http.StatusOK, recorder3.Code, "Response success")
@@ -114,37 +119,41 @@ func TestRedisLimitter_MultipleRequestsObeyInterval_NoError(t *testing.T) {
 // go.exe test -timeout 30s -run ^TestRedisLimitter_RequestTooFreequently_HasError$ github.com/zeroboo/gin-request-limitter -v
 func TestRedisLimitter_RequestTooFreequently_HasError(t *testing.T) {
 	userId := RandomString(16)
+	clock := clocktest.NewFakeClock(time.Now())
+	config := limitterTestConfigLongWindow
+	config.Clock = clock
+	handler := CreateRedisBackedLimitter(GetUserIdFromContextByField(FieldNameUserId), &config, false)
 
 	recorder := RecordRequest(http.MethodGet,
 		"/health",
 		map[string][]string{},
 		map[string][]string{},
 		CreateFakeAuthenticationHandler(FieldNameUserId, userId),
-		limitterHandlerLongWindow,
+		handler,
 		HandleHealth,
 	)
 	assert.Equal(t, http.StatusOK, recorder.Code, "Response success")
 	assert.Equal(t, "OK", recorder.Body.String(), "Response body success")
 
-	time.Sleep(time.Duration(limitterTestConfigLongWindow.MinRequestInterval+100) * time.Millisecond)
+	clock.Advance(time.Duration(config.MinRequestInterval+100) * time.Millisecond)
 	recorder2 := RecordRequest(http.MethodGet,
 		"/health",
 		map[string][]string{},
 		map[string][]string{},
 		CreateFakeAuthenticationHandler(FieldNameUserId, userId),
-		limitterHandlerLongWindow,
+		handler,
 		HandleHealth,
 	)
 	assert.Equal(t, http.StatusOK, recorder2.Code, "Response success")
 	assert.Equal(t, "OK", recorder2.Body.String(), "Response body success")
 
-	time.Sleep(time.Duration(limitterTestConfigLongWindow.MinRequestInterval+100) * time.Millisecond)
+	clock.Advance(time.Duration(config.MinRequestInterval+100) * time.Millisecond)
 	recorder3 := RecordRequest(http.MethodGet,
 		"/health",
 		map[string][]string{},
 		map[string][]string{},
 		CreateFakeAuthenticationHandler(FieldNameUserId, userId),
-		limitterHandlerLongWindow,
+		handler,
 		HandleHealth,
 	)
 	assert.Equal(t, http.StatusTooManyRequests, recorder3.Code, "Response success")
@@ -154,13 +163,17 @@ func TestRedisLimitter_RequestTooFreequently_HasError(t *testing.T) {
 // go.exe test -timeout 30s -run ^TestRedisLimitter_RequestTooFreequentlyAndWaitForNextWindow_Success$ github.com/zeroboo/gin-request-limitter -v
 func TestRedisLimitter_RequestTooFreequentlyAndWaitForNextWindow_Success(t *testing.T) {
 	userId := RandomString(16)
+	clock := clocktest.NewFakeClock(time.Now())
+	config := limitterTestConfigShortWindow
+	config.Clock = clock
+	handler := CreateRedisBackedLimitter(GetUserIdFromContextByField(FieldNameUserId), &config, false)
 
 	recorder := RecordRequest(http.MethodGet,
 		"/health",
 		map[string][]string{},
 		map[string][]string{},
 		CreateFakeAuthenticationHandler(FieldNameUserId, userId),
-		limitterHandlerShortWindow,
+		handler,
 		HandleHealth,
 	)
 	assert.Equal(t, http.StatusOK, recorder.Code, "Response success")
@@ -171,20 +184,132 @@ func TestRedisLimitter_RequestTooFreequentlyAndWaitForNextWindow_Success(t *test
 		map[string][]string{},
 		map[string][]string{},
 		CreateFakeAuthenticationHandler(FieldNameUserId, userId),
-		limitterHandlerShortWindow,
+		handler,
 		HandleHealth,
 	)
 	assert.Equal(t, http.StatusTooManyRequests, recorder2.Code, "Response too many request")
 	assert.Equal(t, "", recorder2.Body.String(), "Response body empty")
-	time.Sleep(time.Duration(limitterTestConfigShortWindow.WindowSize) * time.Millisecond)
+	clock.Advance(time.Duration(config.WindowSize) * time.Millisecond)
 	recorder3 := RecordRequest(http.MethodGet,
 		"/health",
 		map[string][]string{},
 		map[string][]string{},
 		CreateFakeAuthenticationHandler(FieldNameUserId, userId),
-		limitterHandlerShortWindow,
+		handler,
 		HandleHealth,
 	)
 	assert.Equal(t, http.StatusOK, recorder3.Code, "Response success")
 	assert.Equal(t, "OK", recorder3.Body.String(), "Response body success")
 }
+
+// go.exe test -timeout 30s -run ^TestRedisLimitter_RejectedRequest_TrackerNotSaved$ github.com/zeroboo/gin-request-limitter -v
+func TestRedisLimitter_RejectedRequest_TrackerNotSaved(t *testing.T) {
+	userId := RandomString(16)
+	handler := CreateRedisBackedLimitter(GetUserIdFromContextByField(FieldNameUserId), &limitterTestConfig, false)
+	RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{},
+		CreateFakeAuthenticationHandler(FieldNameUserId, userId), handler, HandleHealth)
+	trackerBefore, _ := LoadRedisRequestTracker(context.Background(), rdb, userId, "/health")
+
+	recorder := RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{},
+		CreateFakeAuthenticationHandler(FieldNameUserId, userId), handler, HandleHealth)
+	assert.Equal(t, http.StatusTooEarly, recorder.Code, "Request too fast")
+
+	trackerAfter, _ := LoadRedisRequestTracker(context.Background(), rdb, userId, "/health")
+	assert.Equal(t, trackerBefore, trackerAfter, "Rejected request does not change tracker")
+	ttl := rdb.PTTL(context.Background(), CreateRedisTrackerKey(userId, "/health")).Val()
+	assert.Greater(t, ttl, time.Duration(0), "Tracker expires")
+}
+
+// redisCommandCounter counts round trips and bytes of command arguments sent to redis
+type redisCommandCounter struct {
+	RoundTrips int64
+	Bytes      int64
+}
+
+func (counter *redisCommandCounter) countArgs(cmd redis.Cmder) {
+	for _, arg := range cmd.Args() {
+		counter.Bytes += int64(len(fmt.Sprint(arg)))
+	}
+}
+
+func (counter *redisCommandCounter) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (counter *redisCommandCounter) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		counter.RoundTrips += 1
+		counter.countArgs(cmd)
+		return next(ctx, cmd)
+	}
+}
+
+func (counter *redisCommandCounter) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		counter.RoundTrips += 1
+		for _, cmd := range cmds {
+			counter.countArgs(cmd)
+		}
+		return next(ctx, cmds)
+	}
+}
+
+// saveRedisRequestTrackerPerField saves tracker the way limitter did before: one HSET per field then EXPIRE
+func saveRedisRequestTrackerPerField(ctx context.Context, rClient *redis.Client, tracker *RequestTracker, expireSecond int64) error {
+	_, errSetTracker := rClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		trackerKey := CreateRedisTrackerKey(tracker.UID, tracker.URL)
+		pipe.HSet(ctx, trackerKey, "uid", tracker.UID)
+		pipe.HSet(ctx, trackerKey, "url", tracker.URL)
+		pipe.HSet(ctx, trackerKey, "winNum", tracker.WindowNum)
+		pipe.HSet(ctx, trackerKey, "winReq", tracker.WindowRequest)
+		pipe.HSet(ctx, trackerKey, "last", tracker.LastCall)
+		pipe.HSet(ctx, trackerKey, "exp", tracker.Exp)
+		pipe.Expire(ctx, trackerKey, time.Duration(expireSecond)*time.Second)
+		return nil
+	})
+	return errSetTracker
+}
+
+// benchmarkRedisRequests sends requests of one user as fast as possible, most of them are rejected
+func benchmarkRedisRequests(b *testing.B, request func(ctx context.Context, client *redis.Client, userId string)) {
+	counter := &redisCommandCounter{}
+	client := redis.NewClient(rdb.Options())
+	client.AddHook(counter)
+	defer client.Close()
+
+	ctx := context.Background()
+	userId := RandomString(16)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		request(ctx, client, userId)
+	}
+	b.ReportMetric(float64(counter.RoundTrips)/float64(b.N), "roundtrips/op")
+	b.ReportMetric(float64(counter.Bytes)/float64(b.N), "bytes-sent/op")
+}
+
+// go.exe test -run ^$ -bench ^BenchmarkRedisLimitter_SaveEveryFieldOnEveryRequest$ github.com/zeroboo/gin-request-limitter
+func BenchmarkRedisLimitter_SaveEveryFieldOnEveryRequest(b *testing.B) {
+	config := &limitterTestConfigLongWindow
+	benchmarkRedisRequests(b, func(ctx context.Context, client *redis.Client, userId string) {
+		tracker, _ := LoadRedisRequestTracker(ctx, client, userId, "/health")
+		ValidateRequest(tracker, time.Now(), "/health", "", config)
+		if err := saveRedisRequestTrackerPerField(ctx, client, tracker, config.ExpSec); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+// go.exe test -run ^$ -bench ^BenchmarkRedisLimitter_TrackerStore$ github.com/zeroboo/gin-request-limitter
+func BenchmarkRedisLimitter_TrackerStore(b *testing.B) {
+	config := &limitterTestConfigLongWindow
+	store := NewRedisTrackerStore(nil)
+	benchmarkRedisRequests(b, func(ctx context.Context, client *redis.Client, userId string) {
+		store.Client = client
+		_, err := store.UpdateTracker(ctx, userId, "/health", config, func(tracker *RequestTracker) error {
+			return ValidateRequest(tracker, time.Now(), "/health", "", config)
+		})
+		if err != nil && !IsValidateError(err) {
+			b.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,270 @@
+/*
+Package limitterclient provides an http.RoundTripper for services calling endpoints protected by limitters.
+It throttles outgoing requests locally by the policy of the server, then retries rejected ones when server headers tell
+*/
+
+package limitterclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	limitter "github.com/zeroboo/gin-request-limitter"
+)
+
+const DefaultMaxRetries int = 3
+const DefaultMaxWait time.Duration = 30 * time.Second
+const DefaultBackoff time.Duration = time.Second
+const DefaultJitter float64 = 0.1
+const DefaultMaxTrackers int = 10000
+
+// Headers of IETF RateLimit fields, reset is in secs from now
+const HeaderRateLimit string = "RateLimit"
+const HeaderRateLimitReset string = "RateLimit-Reset"
+
+var ErrorWaitBudgetExceeded = fmt.Errorf("wait for local limit exceeds budget")
+
+// Transport throttles requests by a policy before sending them by Base, and retries requests rejected with 429 or 425
+type Transport struct {
+	//Base sends requests, nil means http.DefaultTransport
+	Base http.RoundTripper
+
+	//Policy of server, requests wait until it admits them. Trackers are keyed on host and path of requests.
+	//Bans and shadow mode of policy do not apply, nil means no local throttling
+	Policy *limitter.LimitterConfig
+
+	//MaxRetries of a request rejected by server
+	MaxRetries int
+
+	//MaxWait is budget of time a request waits for local throttling and retries, a request is not retried after it
+	MaxWait time.Duration
+
+	//Backoff is wait before first retry of a rejection without retry headers, it doubles at each retry
+	Backoff time.Duration
+
+	//Jitter is max fraction of a wait added randomly, so rejected clients do not retry together
+	Jitter float64
+
+	//Clock tells current time, nil means limitter.SystemClock
+	Clock limitter.Clock
+
+	//Sleep waits for duration or until ctx is done, nil means a timer
+	Sleep func(ctx context.Context, duration time.Duration) error
+
+	//MaxTrackers is max number of hosts and paths throttled locally, least recently used ones are dropped.
+	//Expired trackers are dropped anyway, 0 means DefaultMaxTrackers
+	MaxTrackers int
+
+	once        sync.Once
+	engine      *limitter.Limitter
+	randMux     sync.Mutex
+	random      *rand.Rand
+	localPolicy limitter.LimitterConfig
+}
+
+// NewTransport returns a transport of policy over base with default retries, budget, backoff and jitter
+func NewTransport(base http.RoundTripper, policy *limitter.LimitterConfig) *Transport {
+	return &Transport{
+		Base:        base,
+		Policy:      policy,
+		MaxRetries:  DefaultMaxRetries,
+		MaxWait:     DefaultMaxWait,
+		Backoff:     DefaultBackoff,
+		Jitter:      DefaultJitter,
+		MaxTrackers: DefaultMaxTrackers,
+	}
+}
+
+// NewClient returns an http client sending requests by a transport of policy over http.DefaultTransport
+func NewClient(policy *limitter.LimitterConfig) *http.Client {
+	return &http.Client{Transport: NewTransport(nil, policy)}
+}
+
+func (transport *Transport) now() time.Time {
+	if transport.Clock == nil {
+		return limitter.SystemClock.Now()
+	}
+	return transport.Clock.Now()
+}
+
+func (transport *Transport) init() {
+	transport.once.Do(func() {
+		transport.random = rand.New(rand.NewSource(time.Now().UnixNano()))
+		if transport.Policy == nil {
+			return
+		}
+		transport.localPolicy = *transport.Policy
+		transport.localPolicy.BanThreshold = 0
+		transport.localPolicy.Shadow = false
+		transport.localPolicy.Candidate = nil
+		transport.localPolicy.AccessList = nil
+		transport.localPolicy.Overrides = nil
+		if transport.Clock != nil {
+			transport.localPolicy.Clock = transport.Clock
+		}
+		maxTrackers := transport.MaxTrackers
+		if maxTrackers <= 0 {
+			maxTrackers = DefaultMaxTrackers
+		}
+		transport.engine = limitter.NewLimitter(limitter.NewMemoryTrackerStore(maxTrackers), &transport.localPolicy)
+	})
+}
+
+func (transport *Transport) sleep(ctx context.Context, duration time.Duration) error {
+	if transport.Sleep != nil {
+		return transport.Sleep(ctx, duration)
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// addJitter returns wait increased by a random fraction up to Jitter
+func (transport *Transport) addJitter(wait time.Duration) time.Duration {
+	if transport.Jitter <= 0 {
+		return wait
+	}
+	transport.randMux.Lock()
+	defer transport.randMux.Unlock()
+	return wait + time.Duration(transport.random.Float64()*transport.Jitter*float64(wait))
+}
+
+// throttle waits until local policy admits request, ErrorWaitBudgetExceeded if it would wait after deadline
+func (transport *Transport) throttle(req *http.Request, deadline time.Time) error {
+	if transport.engine == nil {
+		return nil
+	}
+	for {
+		decision := transport.engine.Decide(req.Context(), req.URL.Host, req.URL.Path, "", nil)
+		if decision.IsAllowed() {
+			return nil
+		}
+		wait := decision.GetRetryAfter()
+		if wait <= 0 {
+			wait = time.Millisecond
+		}
+		if transport.now().Add(wait).After(deadline) {
+			return fmt.Errorf("%w: host=%v, path=%v, wait=%v", ErrorWaitBudgetExceeded, req.URL.Host, req.URL.Path, wait)
+		}
+		if errSleep := transport.sleep(req.Context(), wait); errSleep != nil {
+			return errSleep
+		}
+	}
+}
+
+// rewindRequest returns a copy of req with a new body to send it again, error if body can not be read again
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	retry := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return retry, nil
+	}
+	if req.GetBody == nil {
+		return nil, fmt.Errorf("body of request can not be read again")
+	}
+	body, errBody := req.GetBody()
+	if errBody != nil {
+		return nil, errBody
+	}
+	retry.Body = body
+	return retry, nil
+}
+
+// IsRetryable returns true if server rejected a request for now: http.StatusTooManyRequests or http.StatusTooEarly
+func IsRetryable(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusTooEarly
+}
+
+/*
+RoundTrip waits until local policy admits request and sends it by Base. Requests rejected by server with
+429 or 425 are sent again after the longest wait told by GetRetryAfter, or after Backoff, plus jitter.
+The last response is returned when retries or budget are used up, or body of request can not be read again
+*/
+func (transport *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport.init()
+	base := transport.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	deadline := transport.now().Add(transport.MaxWait)
+	backoff := transport.Backoff
+	for attempt := 0; ; attempt++ {
+		if errThrottle := transport.throttle(req, deadline); errThrottle != nil {
+			return nil, errThrottle
+		}
+		response, errSend := base.RoundTrip(req)
+		if errSend != nil || !IsRetryable(response.StatusCode) || attempt >= transport.MaxRetries {
+			return response, errSend
+		}
+
+		wait, found := GetRetryAfter(response.Header, transport.now())
+		if !found {
+			wait = backoff
+			backoff *= 2
+		}
+		wait = transport.addJitter(wait)
+		if transport.now().Add(wait).After(deadline) {
+			return response, nil
+		}
+		retry, errRewind := rewindRequest(req)
+		if errRewind != nil {
+			return response, nil
+		}
+		log.Debugf("LimitterClient: Retry, host=%v, path=%v, status=%v, attempt=%v, wait=%v",
+			req.URL.Host, req.URL.Path, response.StatusCode, attempt+1, wait)
+		io.Copy(io.Discard, response.Body)
+		response.Body.Close()
+		if errSleep := transport.sleep(req.Context(), wait); errSleep != nil {
+			return nil, errSleep
+		}
+		req = retry
+	}
+}
+
+/*
+GetRetryAfter returns the longest wait told by headers of a response: Retry-After in secs or http date,
+RateLimit-Reset in secs, or reset of RateLimit field. Found is false if no header tells
+*/
+func GetRetryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	wait := time.Duration(0)
+	found := false
+	tell := func(told time.Duration) {
+		found = true
+		if told > wait {
+			wait = told
+		}
+	}
+
+	if retryAfter := strings.TrimSpace(header.Get("Retry-After")); len(retryAfter) > 0 {
+		if secs, errSecs := strconv.ParseInt(retryAfter, 10, 64); errSecs == nil {
+			tell(time.Duration(secs) * time.Second)
+		} else if retryTime, errTime := http.ParseTime(retryAfter); errTime == nil {
+			tell(retryTime.Sub(now))
+		}
+	}
+	if secs, errSecs := strconv.ParseInt(strings.TrimSpace(header.Get(HeaderRateLimitReset)), 10, 64); errSecs == nil {
+		tell(time.Duration(secs) * time.Second)
+	}
+	for _, item := range strings.Split(header.Get(HeaderRateLimit), ",") {
+		key, value, isPair := strings.Cut(strings.TrimSpace(item), "=")
+		if !isPair || key != "reset" {
+			continue
+		}
+		if secs, errSecs := strconv.ParseInt(value, 10, 64); errSecs == nil {
+			tell(time.Duration(secs) * time.Second)
+		}
+	}
+	return wait, found
+}
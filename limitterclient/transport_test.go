@@ -0,0 +1,200 @@
+package limitterclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	limitter "github.com/zeroboo/gin-request-limitter"
+	"github.com/zeroboo/gin-request-limitter/clocktest"
+	"github.com/zeroboo/gin-request-limitter/limittertest"
+)
+
+var testStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// createTestTransport returns a transport of policy with a fake clock that sleeps by advancing it, waits are appended to waits
+func createTestTransport(policy *limitter.LimitterConfig, waits *[]time.Duration) (*Transport, *clocktest.FakeClock) {
+	clock := clocktest.NewFakeClock(testStart)
+	transport := NewTransport(nil, policy)
+	transport.Jitter = 0
+	transport.Clock = clock
+	transport.Sleep = func(ctx context.Context, duration time.Duration) error {
+		*waits = append(*waits, duration)
+		clock.Advance(duration)
+		return nil
+	}
+	return transport, clock
+}
+
+// go test -timeout 30s -run ^TestTransport_LocalPolicy_WaitsMinInterval$ github.com/zeroboo/gin-request-limitter/limitterclient -v
+func TestTransport_LocalPolicy_WaitsMinInterval(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	waits := []time.Duration{}
+	transport, _ := createTestTransport(&limitter.LimitterConfig{MinRequestInterval: 500, ExpSec: 60}, &waits)
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 3; i++ {
+		response, errGet := client.Get(server.URL + "/health")
+		assert.Nil(t, errGet, "Sent")
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Served")
+		response.Body.Close()
+	}
+
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}, waits, "Waited min interval before each later request")
+}
+
+// go test -timeout 30s -run ^TestTransport_LocalPolicy_WaitOverBudget_Error$ github.com/zeroboo/gin-request-limitter/limitterclient -v
+func TestTransport_LocalPolicy_WaitOverBudget_Error(t *testing.T) {
+	sent := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { atomic.AddInt32(&sent, 1) }))
+	defer server.Close()
+	waits := []time.Duration{}
+	transport, _ := createTestTransport(&limitter.LimitterConfig{WindowSize: 60000, MaxRequestPerWindow: 1, ExpSec: 60}, &waits)
+	transport.MaxWait = time.Second
+	client := &http.Client{Transport: transport}
+
+	response, _ := client.Get(server.URL + "/health")
+	response.Body.Close()
+	_, errGet := client.Get(server.URL + "/health")
+
+	assert.True(t, errors.Is(errGet, ErrorWaitBudgetExceeded), "Window ends after budget")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&sent), "Second request not sent")
+	assert.Empty(t, waits, "Did not wait")
+}
+
+// go test -timeout 30s -run ^TestTransport_TooManyRequests_RetriedAfterHeader$ github.com/zeroboo/gin-request-limitter/limitterclient -v
+func TestTransport_TooManyRequests_RetriedAfterHeader(t *testing.T) {
+	sent := int32(0)
+	bodies := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if atomic.AddInt32(&sent, 1) <= 2 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+	waits := []time.Duration{}
+	transport, _ := createTestTransport(nil, &waits)
+	client := &http.Client{Transport: transport}
+
+	response, errPost := client.Post(server.URL+"/orders", "text/plain", strings.NewReader("order"))
+
+	assert.Nil(t, errPost, "Sent")
+	assert.Equal(t, http.StatusOK, response.StatusCode, "Served after retries")
+	assert.Equal(t, []time.Duration{2 * time.Second, 2 * time.Second}, waits, "Waited Retry-After")
+	assert.Equal(t, []string{"order", "order", "order"}, bodies, "Body sent again")
+}
+
+// go test -timeout 30s -run ^TestTransport_RetriesUsedUp_LastResponseReturned$ github.com/zeroboo/gin-request-limitter/limitterclient -v
+func TestTransport_RetriesUsedUp_LastResponseReturned(t *testing.T) {
+	sent := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&sent, 1)
+		w.WriteHeader(http.StatusTooEarly)
+	}))
+	defer server.Close()
+	waits := []time.Duration{}
+	transport, _ := createTestTransport(nil, &waits)
+	transport.MaxRetries = 2
+	client := &http.Client{Transport: transport}
+
+	response, errGet := client.Get(server.URL + "/health")
+
+	assert.Nil(t, errGet, "Sent")
+	assert.Equal(t, http.StatusTooEarly, response.StatusCode, "Rejection returned")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&sent), "Sent once and retried twice")
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, waits, "Backoff doubled without headers")
+}
+
+// go test -timeout 30s -run ^TestTransport_RetryAfterOverBudget_NotRetried$ github.com/zeroboo/gin-request-limitter/limitterclient -v
+func TestTransport_RetryAfterOverBudget_NotRetried(t *testing.T) {
+	sent := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&sent, 1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	waits := []time.Duration{}
+	transport, _ := createTestTransport(nil, &waits)
+	client := &http.Client{Transport: transport}
+
+	response, errGet := client.Get(server.URL + "/health")
+
+	assert.Nil(t, errGet, "Sent")
+	assert.Equal(t, http.StatusTooManyRequests, response.StatusCode, "Rejection returned")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&sent), "Not retried")
+	assert.Empty(t, waits, "Did not wait")
+}
+
+// go test -timeout 30s -run ^TestGetRetryAfter_Headers_LongestWait$ github.com/zeroboo/gin-request-limitter/limitterclient -v
+func TestGetRetryAfter_Headers_LongestWait(t *testing.T) {
+	now := testStart
+	testCases := []struct {
+		name   string
+		header http.Header
+		wait   time.Duration
+		found  bool
+	}{
+		{"None", http.Header{}, 0, false},
+		{"RetryAfterSecs", http.Header{"Retry-After": {"5"}}, 5 * time.Second, true},
+		{"RetryAfterDate", http.Header{"Retry-After": {now.Add(10 * time.Second).Format(http.TimeFormat)}}, 10 * time.Second, true},
+		{"RateLimitReset", http.Header{"Ratelimit-Reset": {"7"}}, 7 * time.Second, true},
+		{"RateLimitField", http.Header{"Ratelimit": {"limit=10, remaining=0, reset=12"}}, 12 * time.Second, true},
+		{"Longest", http.Header{"Retry-After": {"3"}, "Ratelimit-Reset": {"8"}}, 8 * time.Second, true},
+		{"Invalid", http.Header{"Retry-After": {"soon"}}, 0, false},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			wait, found := GetRetryAfter(testCase.header, now)
+			assert.Equal(t, testCase.wait, wait, "Wait")
+			assert.Equal(t, testCase.found, found, "Found")
+		})
+	}
+}
+
+// go test -timeout 30s -run ^TestTransport_SamePolicyAsServer_NoRejection$ github.com/zeroboo/gin-request-limitter/limitterclient -v
+func TestTransport_SamePolicyAsServer_NoRejection(t *testing.T) {
+	policy := &limitter.LimitterConfig{MinRequestInterval: 100, WindowSize: 1000, MaxRequestPerWindow: 5, ExpSec: 60}
+	waits := []time.Duration{}
+	transport, clock := createTestTransport(policy, &waits)
+	serverPolicy := *policy
+	serverPolicy.Clock = clock
+	store := limittertest.NewMemoryStore()
+	store.Clock = clock
+	rejected := int32(0)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	limitted := limitter.CreateHttpLimitter(store, limitter.GetUserIdFromHeader("X-User-Id"), &serverPolicy, nil)(next)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := httptest.NewRecorder()
+		limitted.ServeHTTP(recorder, r)
+		if recorder.Code != http.StatusOK {
+			atomic.AddInt32(&rejected, 1)
+		}
+		w.WriteHeader(recorder.Code)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 20; i++ {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/health", nil)
+		req.Header.Set("X-User-Id", "user")
+		response, errDo := client.Do(req)
+		assert.Nil(t, errDo, "Sent")
+		assert.Equal(t, http.StatusOK, response.StatusCode, "Served")
+		response.Body.Close()
+	}
+
+	assert.Equal(t, int32(0), atomic.LoadInt32(&rejected), "Server rejected nothing")
+	assert.Equal(t, 3400*time.Millisecond, clock.Now().Sub(testStart), "Last window started at 3s, its requests 100ms apart")
+}
@@ -0,0 +1,167 @@
+/*
+Package limittergrpc provides gRPC server interceptors limiting requests by the same policies and stores as gin and net/http limitters.
+Trackers are keyed on user id and full method name, e.g. /package.Service/Method
+*/
+
+package limittergrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	limitter "github.com/zeroboo/gin-request-limitter"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// ErrorInfoDomain is domain of ErrorInfo details of rejections
+const ErrorInfoDomain string = "github.com/zeroboo/gin-request-limitter"
+
+// Reasons of ErrorInfo details of rejections
+const REASON_TOO_FAST string = "REQUEST_TOO_FAST"
+const REASON_TOO_MANY string = "REQUEST_TOO_MANY"
+const REASON_BANNED string = "REQUEST_BANNED"
+const REASON_DENIED string = "REQUEST_DENIED"
+
+// GetUserIdFromMetadata extracts userId from first value of an incoming metadata key, e.g. x-user-id
+func GetUserIdFromMetadata(key string) func(ctx context.Context) string {
+	return func(ctx context.Context) string {
+		incoming, _ := metadata.FromIncomingContext(ctx)
+		values := incoming.Get(key)
+		if len(values) == 0 {
+			return ""
+		}
+		return values[0]
+	}
+}
+
+// GetUserIdFromContextValue extracts userId from a string value of context, e.g. set by an authentication interceptor
+func GetUserIdFromContextValue(key interface{}) func(ctx context.Context) string {
+	return func(ctx context.Context) string {
+		userId, _ := ctx.Value(key).(string)
+		return userId
+	}
+}
+
+// GetClientIP returns host of peer address of context, empty if there is no peer
+func GetClientIP(ctx context.Context) string {
+	client, found := peer.FromContext(ctx)
+	if !found || client.Addr == nil {
+		return ""
+	}
+	address := client.Addr.String()
+	host, _, errSplit := net.SplitHostPort(address)
+	if errSplit != nil {
+		return address
+	}
+	return host
+}
+
+// getHeader returns incoming metadata as http headers, for access lists matching headers and API keys
+func getHeader(ctx context.Context) http.Header {
+	header := http.Header{}
+	incoming, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range incoming {
+		header[http.CanonicalHeaderKey(key)] = values
+	}
+	return header
+}
+
+// getMetadata returns headers of decision as metadata with lower case keys
+func getMetadata(decision *limitter.Decision) metadata.MD {
+	md := metadata.MD{}
+	for key, values := range decision.Header {
+		md.Set(strings.ToLower(key), values...)
+	}
+	return md
+}
+
+/*
+CreateRejectionStatus returns status of a rejected decision: codes.ResourceExhausted for limits and bans,
+codes.PermissionDenied for denials and codes.Unavailable for backend failures.
+Rejections have an ErrorInfo detail telling reason, rule and ban level. Rejections by limits and bans also have a RetryInfo detail
+*/
+func CreateRejectionStatus(decision *limitter.Decision) *status.Status {
+	reason := REASON_TOO_MANY
+	switch {
+	case errors.Is(decision.Err, limitter.ErrorRequestDenied):
+		denial := status.New(codes.PermissionDenied, decision.Err.Error())
+		if detailed, errDetails := denial.WithDetails(&errdetails.ErrorInfo{Reason: REASON_DENIED, Domain: ErrorInfoDomain}); errDetails == nil {
+			return detailed
+		}
+		return denial
+	case errors.Is(decision.Err, limitter.ErrorRequestBanned):
+		reason = REASON_BANNED
+	case errors.Is(decision.Err, limitter.ErrorRequestTooFast):
+		reason = REASON_TOO_FAST
+	case !limitter.IsValidateError(decision.Err):
+		return status.New(codes.Unavailable, decision.Err.Error())
+	}
+
+	rejection := status.New(codes.ResourceExhausted, decision.Err.Error())
+	info := &errdetails.ErrorInfo{Reason: reason, Domain: ErrorInfoDomain, Metadata: map[string]string{}}
+	if rule := decision.Header.Get(limitter.HeaderLimitRule); len(rule) > 0 {
+		info.Metadata["rule"] = rule
+	}
+	if banLevel := decision.Header.Get(limitter.HeaderBanLevel); len(banLevel) > 0 {
+		info.Metadata["banLevel"] = banLevel
+	}
+	detailed, errDetails := rejection.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(decision.GetRetryAfter())}, info)
+	if errDetails != nil {
+		return rejection
+	}
+	return detailed
+}
+
+// decide decides a call of method, user id is extracted from ctx
+func decide(ctx context.Context, engine *limitter.Limitter, userIdExtractor func(ctx context.Context) string, fullMethod string) *limitter.Decision {
+	return engine.Decide(ctx, userIdExtractor(ctx), fullMethod, GetClientIP(ctx), getHeader(ctx))
+}
+
+/*
+CreateUnaryServerInterceptor returns an interceptor that persists trackers in given store, see Limitter.Decide.
+Headers of decision are sent as header metadata, rejected calls fail with status of CreateRejectionStatus
+*/
+func CreateUnaryServerInterceptor(pStore limitter.TrackerStore,
+	pUserIdExtractor func(ctx context.Context) string,
+	pConfig *limitter.LimitterConfig) grpc.UnaryServerInterceptor {
+	engine := limitter.NewLimitter(pStore, pConfig)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		decision := decide(ctx, engine, pUserIdExtractor, info.FullMethod)
+		if len(decision.Header) > 0 {
+			grpc.SetHeader(ctx, getMetadata(decision))
+		}
+		if !decision.IsAllowed() {
+			return nil, CreateRejectionStatus(decision).Err()
+		}
+		return handler(ctx, req)
+	}
+}
+
+/*
+CreateStreamServerInterceptor returns an interceptor deciding a stream once when it opens, like CreateUnaryServerInterceptor.
+Messages of an open stream are not limited
+*/
+func CreateStreamServerInterceptor(pStore limitter.TrackerStore,
+	pUserIdExtractor func(ctx context.Context) string,
+	pConfig *limitter.LimitterConfig) grpc.StreamServerInterceptor {
+	engine := limitter.NewLimitter(pStore, pConfig)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		decision := decide(stream.Context(), engine, pUserIdExtractor, info.FullMethod)
+		if len(decision.Header) > 0 {
+			stream.SetHeader(getMetadata(decision))
+		}
+		if !decision.IsAllowed() {
+			return CreateRejectionStatus(decision).Err()
+		}
+		return handler(srv, stream)
+	}
+}
@@ -0,0 +1,145 @@
+package limittergrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	limitter "github.com/zeroboo/gin-request-limitter"
+	"github.com/zeroboo/gin-request-limitter/clocktest"
+	"github.com/zeroboo/gin-request-limitter/limittertest"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testMethodCheck string = "/grpc.health.v1.Health/Check"
+
+// startTestServer serves health service limited by interceptors of config on store through bufconn, returns a client of it
+func startTestServer(t *testing.T, store limitter.TrackerStore, config *limitter.LimitterConfig) grpc_health_v1.HealthClient {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(CreateUnaryServerInterceptor(store, GetUserIdFromMetadata("x-user-id"), config)),
+		grpc.StreamInterceptor(CreateStreamServerInterceptor(store, GetUserIdFromMetadata("x-user-id"), config)),
+	)
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(listener)
+
+	conn, errDial := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, errDial, "Dialed")
+	t.Cleanup(func() {
+		conn.Close()
+		server.Stop()
+	})
+	return grpc_health_v1.NewHealthClient(conn)
+}
+
+func createTestConfig() *limitter.LimitterConfig {
+	return &limitter.LimitterConfig{
+		WindowSize:          60000,
+		MaxRequestPerWindow: 2,
+		ExpSec:              600,
+		Clock:               clocktest.NewFakeClock(time.UnixMilli(60000 * 1000)),
+	}
+}
+
+func createUserContext(userId string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "x-user-id", userId)
+}
+
+// go test -timeout 30s -run ^TestUnaryInterceptor_WindowFull_ResourceExhaustedWithRetryInfo$ github.com/zeroboo/gin-request-limitter/limittergrpc -v
+func TestUnaryInterceptor_WindowFull_ResourceExhaustedWithRetryInfo(t *testing.T) {
+	config := createTestConfig()
+	store := limittertest.NewMemoryStore()
+	store.Clock = config.Clock
+	client := startTestServer(t, store, config)
+
+	codesOfCalls := []codes.Code{}
+	var header metadata.MD
+	var errCall error
+	for i := 0; i < 3; i++ {
+		_, errCall = client.Check(createUserContext("user"), &grpc_health_v1.HealthCheckRequest{}, grpc.Header(&header))
+		codesOfCalls = append(codesOfCalls, status.Code(errCall))
+	}
+
+	assert.Equal(t, []codes.Code{codes.OK, codes.OK, codes.ResourceExhausted}, codesOfCalls, "Third call rejected")
+	assert.Equal(t, []string{limitter.RULE_NAME_WINDOW}, header.Get("x-ratelimit-rule"), "Rule in header metadata")
+	details := status.Convert(errCall).Details()
+	assert.Equal(t, 2, len(details), "Retry and error info")
+	retryInfo := details[0].(*errdetails.RetryInfo)
+	assert.Equal(t, 60*time.Second, retryInfo.RetryDelay.AsDuration(), "Retry at next window")
+	errorInfo := details[1].(*errdetails.ErrorInfo)
+	assert.Equal(t, REASON_TOO_MANY, errorInfo.Reason, "Reason")
+	assert.Equal(t, limitter.RULE_NAME_WINDOW, errorInfo.Metadata["rule"], "Rule")
+
+	_, errOther := client.Check(createUserContext("other"), &grpc_health_v1.HealthCheckRequest{})
+	assert.Nil(t, errOther, "Other user served")
+	tracker, errGet := store.GetTracker(context.Background(), "user", testMethodCheck)
+	assert.Nil(t, errGet, "Tracker keyed on full method name")
+	assert.Equal(t, int64(2), tracker.WindowRequest, "Rejected call not counted")
+}
+
+// go test -timeout 30s -run ^TestStreamInterceptor_WindowFull_StreamRejected$ github.com/zeroboo/gin-request-limitter/limittergrpc -v
+func TestStreamInterceptor_WindowFull_StreamRejected(t *testing.T) {
+	config := createTestConfig()
+	config.MaxRequestPerWindow = 1
+	client := startTestServer(t, limittertest.NewMemoryStore(), config)
+
+	ctx, cancel := context.WithCancel(createUserContext("user"))
+	defer cancel()
+	first, errWatch := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+	assert.Nil(t, errWatch, "First stream opened")
+	_, errRecv := first.Recv()
+	assert.Nil(t, errRecv, "Messages of open stream served")
+
+	second, errWatch := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+	assert.Nil(t, errWatch, "Second stream created")
+	_, errRecv = second.Recv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(errRecv), "Second stream rejected")
+}
+
+// go test -timeout 30s -run ^TestUnaryInterceptor_Banned_ReasonAndBanLevel$ github.com/zeroboo/gin-request-limitter/limittergrpc -v
+func TestUnaryInterceptor_Banned_ReasonAndBanLevel(t *testing.T) {
+	config := createTestConfig()
+	config.MaxRequestPerWindow = 1
+	config.BanThreshold = 1
+	config.BanDurations = []int64{300000}
+	client := startTestServer(t, limittertest.NewMemoryStore(), config)
+
+	client.Check(createUserContext("user"), &grpc_health_v1.HealthCheckRequest{})
+	_, errCall := client.Check(createUserContext("user"), &grpc_health_v1.HealthCheckRequest{})
+
+	assert.Equal(t, codes.ResourceExhausted, status.Code(errCall), "Banned")
+	details := status.Convert(errCall).Details()
+	assert.Equal(t, 300*time.Second, details[0].(*errdetails.RetryInfo).RetryDelay.AsDuration(), "Retry when ban ends")
+	assert.Equal(t, REASON_BANNED, details[1].(*errdetails.ErrorInfo).Reason, "Reason")
+	assert.Equal(t, "1", details[1].(*errdetails.ErrorInfo).Metadata["banLevel"], "Ban level")
+}
+
+// go test -timeout 30s -run ^TestUnaryInterceptor_DeniedAndFailures$ github.com/zeroboo/gin-request-limitter/limittergrpc -v
+func TestUnaryInterceptor_DeniedAndFailures(t *testing.T) {
+	accessList, _ := limitter.NewAccessList(limitter.AccessRules{DenyUserIds: []string{"denied"}})
+	config := createTestConfig()
+	config.AccessList = accessList
+	config.AbortOnFail = true
+	store := limittertest.NewFaultStore(limittertest.NewMemoryStore())
+	client := startTestServer(t, store, config)
+
+	_, errDenied := client.Check(createUserContext("denied"), &grpc_health_v1.HealthCheckRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(errDenied), "Denied user")
+	assert.Equal(t, REASON_DENIED, status.Convert(errDenied).Details()[0].(*errdetails.ErrorInfo).Reason, "Reason")
+
+	store.Inject(limittertest.Fault{Kind: limittertest.FAULT_ERROR}, 1)
+	_, errFailed := client.Check(createUserContext("user"), &grpc_health_v1.HealthCheckRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(errFailed), "Backend failure aborts call")
+}
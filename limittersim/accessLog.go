@@ -0,0 +1,180 @@
+package limittersim
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const LOG_FORMAT_COMBINED string = "combined"
+const LOG_FORMAT_JSON string = "json"
+
+// CombinedLogTimeLayout is layout of times of combined logs, e.g. 10/Oct/2000:13:55:36 -0700
+const CombinedLogTimeLayout string = "02/Jan/2006:15:04:05 -0700"
+
+var ErrorInvalidLogLine = fmt.Errorf("invalid access log line")
+
+// Fields of JSON lines tried in order when parser has no field configured, they cover gin, nginx and common loggers
+var DefaultTimeFields []string = []string{"time", "timestamp", "ts", "@timestamp", "time_iso8601", "time_local"}
+var DefaultUserFields []string = []string{"userId", "user_id", "uid", "user", "remote_user", "clientIP", "client_ip", "remote_addr", "ip"}
+var DefaultPathFields []string = []string{"path", "uri", "request_uri", "url"}
+
+// combinedLogPattern matches host ident authuser [time] "method target protocol" status ..., referer and agent are ignored
+var combinedLogPattern *regexp.Regexp = regexp.MustCompile(`^(\S+) \S+ (\S+) \[([^\]]+)\] "\S+ (\S+)[^"]*" \d{3}`)
+
+// AccessLogParser reads requests from access logs
+type AccessLogParser struct {
+	//Format is LOG_FORMAT_COMBINED or LOG_FORMAT_JSON
+	Format string
+
+	//UserField is JSON field of user id, empty means first of DefaultUserFields found.
+	//Combined logs use authenticated user, remote host if there is none
+	UserField string
+
+	//TimeField is JSON field of request time, empty means first of DefaultTimeFields found.
+	//Times are RFC3339, combined log times or unix times in secs or milisecs
+	TimeField string
+
+	//PathField is JSON field of request path, empty means first of DefaultPathFields found
+	PathField string
+}
+
+func NewAccessLogParser(format string) *AccessLogParser {
+	return &AccessLogParser{Format: format}
+}
+
+/*
+Parse reads requests of lines of reader ordered as in log, blank lines are ignored.
+It returns number of lines that are not requests, error if format is unknown or reader fails
+*/
+func (parser *AccessLogParser) Parse(reader io.Reader) ([]Request, int, error) {
+	if parser.Format != LOG_FORMAT_COMBINED && parser.Format != LOG_FORMAT_JSON {
+		return nil, 0, fmt.Errorf("unknown log format %v", parser.Format)
+	}
+	requests := []Request{}
+	invalid := 0
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		request, errParse := parser.ParseLine(line)
+		if errParse != nil {
+			invalid += 1
+			continue
+		}
+		requests = append(requests, request)
+	}
+	return requests, invalid, scanner.Err()
+}
+
+// ParseLine returns request of a log line, ErrorInvalidLogLine if line is not a request of format
+func (parser *AccessLogParser) ParseLine(line string) (Request, error) {
+	switch parser.Format {
+	case LOG_FORMAT_COMBINED:
+		return parseCombinedLogLine(line)
+	case LOG_FORMAT_JSON:
+		return parser.parseJSONLine(line)
+	}
+	return Request{}, fmt.Errorf("unknown log format %v", parser.Format)
+}
+
+func parseCombinedLogLine(line string) (Request, error) {
+	match := combinedLogPattern.FindStringSubmatch(line)
+	if match == nil {
+		return Request{}, fmt.Errorf("%w: %v", ErrorInvalidLogLine, line)
+	}
+	requestTime, errTime := time.Parse(CombinedLogTimeLayout, match[3])
+	if errTime != nil {
+		return Request{}, fmt.Errorf("%w: %v", ErrorInvalidLogLine, errTime)
+	}
+	userId := match[2]
+	if userId == "-" {
+		userId = match[1]
+	}
+	return Request{Time: requestTime, UserId: userId, URL: getPath(match[4])}, nil
+}
+
+func (parser *AccessLogParser) parseJSONLine(line string) (Request, error) {
+	fields := map[string]interface{}{}
+	if errJSON := json.Unmarshal([]byte(line), &fields); errJSON != nil {
+		return Request{}, fmt.Errorf("%w: %v", ErrorInvalidLogLine, errJSON)
+	}
+	rawTime := getField(fields, parser.TimeField, DefaultTimeFields)
+	userId, userFound := getString(getField(fields, parser.UserField, DefaultUserFields))
+	path, pathFound := getString(getField(fields, parser.PathField, DefaultPathFields))
+	if rawTime == nil || !userFound || !pathFound {
+		return Request{}, fmt.Errorf("%w: missing time, user or path: %v", ErrorInvalidLogLine, line)
+	}
+	requestTime, errTime := parseLogTime(rawTime)
+	if errTime != nil {
+		return Request{}, fmt.Errorf("%w: %v", ErrorInvalidLogLine, errTime)
+	}
+	return Request{Time: requestTime, UserId: userId, URL: getPath(path)}, nil
+}
+
+// getField returns value of field, first non empty value of candidates if field is empty
+func getField(fields map[string]interface{}, field string, candidates []string) interface{} {
+	if len(field) > 0 {
+		return fields[field]
+	}
+	for _, candidate := range candidates {
+		if value, found := fields[candidate]; found && value != nil && value != "" && value != "-" {
+			return value
+		}
+	}
+	return nil
+}
+
+// getString returns value of a string or number field, false if there is none
+func getString(value interface{}) (string, bool) {
+	switch typed := value.(type) {
+	case string:
+		return typed, len(typed) > 0
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+// parseLogTime parses RFC3339 or combined log time strings and unix times in secs or milisecs
+func parseLogTime(value interface{}) (time.Time, error) {
+	switch typed := value.(type) {
+	case float64:
+		return parseUnixTime(typed), nil
+	case string:
+		if number, errNumber := strconv.ParseFloat(typed, 64); errNumber == nil {
+			return parseUnixTime(number), nil
+		}
+		if parsed, errTime := time.Parse(time.RFC3339Nano, typed); errTime == nil {
+			return parsed, nil
+		}
+		return time.Parse(CombinedLogTimeLayout, typed)
+	}
+	return time.Time{}, fmt.Errorf("unknown time %v", value)
+}
+
+// parseUnixTime returns time of unix secs, or milisecs if number is too large to be secs
+func parseUnixTime(number float64) time.Time {
+	if number >= 1e11 {
+		return time.UnixMilli(int64(number))
+	}
+	return time.UnixMilli(int64(number * 1000))
+}
+
+// getPath returns path of a request target without query, like limitters key trackers
+func getPath(target string) string {
+	parsed, errParse := url.ParseRequestURI(target)
+	if errParse != nil {
+		return target
+	}
+	return parsed.Path
+}
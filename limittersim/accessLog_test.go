@@ -0,0 +1,65 @@
+package limittersim
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestAccessLogParser_Combined_UserOrHost$ github.com/zeroboo/gin-request-limitter/limittersim -v
+func TestAccessLogParser_Combined_UserOrHost(t *testing.T) {
+	log := `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif?x=1 HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"
+10.0.0.2 - - [10/Oct/2000:13:55:37 -0700] "POST /items HTTP/1.1" 201 15 "-" "curl/7.68.0"
+
+not a log line
+`
+	requests, invalid, err := NewAccessLogParser(LOG_FORMAT_COMBINED).Parse(strings.NewReader(log))
+
+	assert.Nil(t, err, "Parsed")
+	assert.Equal(t, 1, invalid, "Invalid lines")
+	zone := time.FixedZone("", -7*3600)
+	assert.Equal(t, 2, len(requests), "Requests")
+	assert.Equal(t, "frank", requests[0].UserId, "Authenticated user")
+	assert.Equal(t, "/apache_pb.gif", requests[0].URL, "Path without query")
+	assert.True(t, time.Date(2000, 10, 10, 13, 55, 36, 0, zone).Equal(requests[0].Time), "Time")
+	assert.Equal(t, "10.0.0.2", requests[1].UserId, "Remote host without user")
+	assert.Equal(t, "/items", requests[1].URL, "Path")
+}
+
+// go test -timeout 30s -run ^TestAccessLogParser_JSON_CommonFieldsAndTimes$ github.com/zeroboo/gin-request-limitter/limittersim -v
+func TestAccessLogParser_JSON_CommonFieldsAndTimes(t *testing.T) {
+	log := `{"time":"2024-01-01T00:00:01Z","userId":"a","path":"/health"}
+{"ts":1704067202.5,"user_id":42,"uri":"/items?page=2"}
+{"@timestamp":"1704067203000","remote_user":"-","remote_addr":"10.0.0.3","request_uri":"/health"}
+{"time_local":"01/Jan/2024:00:00:04 +0000","uid":"c","url":"/health"}
+{"time":"2024-01-01T00:00:05Z","path":"/health"}
+{"time":"yesterday","userId":"a","path":"/health"}
+`
+	requests, invalid, err := NewAccessLogParser(LOG_FORMAT_JSON).Parse(strings.NewReader(log))
+
+	assert.Nil(t, err, "Parsed")
+	assert.Equal(t, 2, invalid, "Lines without user or with bad time")
+	assert.Equal(t, []Request{
+		{Time: time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC), UserId: "a", URL: "/health"},
+		{Time: time.UnixMilli(1704067202500), UserId: "42", URL: "/items"},
+		{Time: time.UnixMilli(1704067203000), UserId: "10.0.0.3", URL: "/health"},
+	}, requests[:3], "Requests of common fields")
+	assert.True(t, time.Date(2024, 1, 1, 0, 0, 4, 0, time.UTC).Equal(requests[3].Time), "Combined log time")
+}
+
+// go test -timeout 30s -run ^TestAccessLogParser_JSON_ConfiguredFields$ github.com/zeroboo/gin-request-limitter/limittersim -v
+func TestAccessLogParser_JSON_ConfiguredFields(t *testing.T) {
+	parser := &AccessLogParser{Format: LOG_FORMAT_JSON, UserField: "account", TimeField: "at", PathField: "route"}
+	request, err := parser.ParseLine(`{"at":"2024-01-01T00:00:01Z","account":"a","userId":"b","route":"/items","path":"/health"}`)
+
+	assert.Nil(t, err, "Parsed")
+	assert.Equal(t, "a", request.UserId, "Configured user field")
+	assert.Equal(t, "/items", request.URL, "Configured path field")
+
+	_, err = parser.ParseLine(`{"time":"2024-01-01T00:00:01Z","userId":"b","path":"/health"}`)
+	assert.ErrorIs(t, err, ErrorInvalidLogLine, "Configured fields required")
+	_, _, err = NewAccessLogParser("xml").Parse(strings.NewReader("<log/>"))
+	assert.NotNil(t, err, "Unknown format")
+}
@@ -0,0 +1,126 @@
+package limittersim
+
+import (
+	"sort"
+	"time"
+
+	limitter "github.com/zeroboo/gin-request-limitter"
+)
+
+// Throttle tells when requests of a user to a route were rejected
+type Throttle struct {
+	UserId string
+	URL    string
+
+	//Requests is number of requests of user to route
+	Requests int
+
+	//Rejected is number of them rejected by policy
+	Rejected int
+
+	//First and Last are times of first and last rejected requests
+	First time.Time
+	Last  time.Time
+
+	//Statuses counts rejections by status code
+	Statuses map[int]int
+}
+
+// RouteThrottle counts rejections of requests to a route
+type RouteThrottle struct {
+	URL      string
+	Requests int
+	Rejected int
+
+	//Users is number of users with a rejected request
+	Users int
+}
+
+// ReplayReport is outcome of replaying recorded requests, Throttles and Routes are ordered by most rejections first
+type ReplayReport struct {
+	*Report
+
+	Throttles []*Throttle
+	Routes    []*RouteThrottle
+}
+
+/*
+Replay decides recorded requests by a copy of config in virtual time at their recorded times, nil store means a store in memory.
+Curve of report has buckets of step, 0 means DefaultStep
+*/
+func Replay(store limitter.TrackerStore, config *limitter.LimitterConfig, requests []Request, step time.Duration) *ReplayReport {
+	recorded := make([]Request, len(requests))
+	copy(recorded, requests)
+	sort.SliceStable(recorded, func(i, j int) bool { return recorded[i].Time.Before(recorded[j].Time) })
+	if step <= 0 {
+		step = DefaultStep
+	}
+	start := time.Time{}
+	duration := time.Duration(0)
+	if len(recorded) > 0 {
+		start = recorded[0].Time
+		duration = recorded[len(recorded)-1].Time.Sub(start) + time.Millisecond
+	}
+
+	sim := NewSimulation(store, config, start)
+	report := newReport(start, duration, step, &sim.Config)
+	throttles := map[string]*Throttle{}
+	routes := map[string]*RouteThrottle{}
+	for _, request := range recorded {
+		decision := sim.Decide(request)
+		report.add(decision)
+
+		key := limitter.CreateTrackerName(request.UserId, request.URL)
+		throttle, found := throttles[key]
+		if !found {
+			throttle = &Throttle{UserId: request.UserId, URL: request.URL, Statuses: map[int]int{}}
+			throttles[key] = throttle
+		}
+		route, found := routes[request.URL]
+		if !found {
+			route = &RouteThrottle{URL: request.URL}
+			routes[request.URL] = route
+		}
+		throttle.Requests += 1
+		route.Requests += 1
+		if !decision.Rejected {
+			continue
+		}
+		if throttle.Rejected == 0 {
+			throttle.First = request.Time
+			route.Users += 1
+		}
+		throttle.Rejected += 1
+		throttle.Last = request.Time
+		throttle.Statuses[decision.Status] += 1
+		route.Rejected += 1
+	}
+	report.finish()
+
+	replay := &ReplayReport{Report: report, Throttles: []*Throttle{}, Routes: []*RouteThrottle{}}
+	for _, throttle := range throttles {
+		if throttle.Rejected > 0 {
+			replay.Throttles = append(replay.Throttles, throttle)
+		}
+	}
+	sort.Slice(replay.Throttles, func(i, j int) bool {
+		left, right := replay.Throttles[i], replay.Throttles[j]
+		if left.Rejected != right.Rejected {
+			return left.Rejected > right.Rejected
+		}
+		if left.UserId != right.UserId {
+			return left.UserId < right.UserId
+		}
+		return left.URL < right.URL
+	})
+	for _, route := range routes {
+		replay.Routes = append(replay.Routes, route)
+	}
+	sort.Slice(replay.Routes, func(i, j int) bool {
+		if replay.Routes[i].Rejected != replay.Routes[j].Rejected {
+			return replay.Routes[i].Rejected > replay.Routes[j].Rejected
+		}
+		return replay.Routes[i].URL < replay.Routes[j].URL
+	})
+	return replay
+}
@@ -0,0 +1,54 @@
+package limittersim
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	limitter "github.com/zeroboo/gin-request-limitter"
+)
+
+// go test -timeout 30s -run ^TestReplay_ThrottledUsersAndRoutes$ github.com/zeroboo/gin-request-limitter/limittersim -v
+func TestReplay_ThrottledUsersAndRoutes(t *testing.T) {
+	config := limitter.LimitterConfig{MinRequestInterval: 1000, WindowSize: 60000, MaxRequestPerWindow: 2, ExpSec: 600}
+	requests := []Request{
+		{Time: testStart.Add(3 * time.Second), UserId: "a", URL: "/health"},
+		{Time: testStart, UserId: "a", URL: "/health"},
+		{Time: testStart.Add(100 * time.Millisecond), UserId: "a", URL: "/health"},
+		{Time: testStart.Add(5 * time.Second), UserId: "a", URL: "/health"},
+		{Time: testStart.Add(time.Second), UserId: "b", URL: "/items"},
+		{Time: testStart.Add(61 * time.Second), UserId: "a", URL: "/health"},
+	}
+
+	report := Replay(nil, &config, requests, 0)
+
+	assert.Equal(t, testStart, report.Start, "Replayed at recorded times")
+	assert.Equal(t, 6, report.Requests, "Requests")
+	assert.Equal(t, 2, report.Rejected, "Rejected")
+	assert.Equal(t, 1, len(report.Throttles), "Throttled users")
+	assert.Equal(t, &Throttle{
+		UserId:   "a",
+		URL:      "/health",
+		Requests: 5,
+		Rejected: 2,
+		First:    testStart.Add(100 * time.Millisecond),
+		Last:     testStart.Add(5 * time.Second),
+		Statuses: map[int]int{http.StatusTooEarly: 1, http.StatusTooManyRequests: 1},
+	}, report.Throttles[0], "Throttle of user")
+	assert.Equal(t, []*RouteThrottle{
+		{URL: "/health", Requests: 5, Rejected: 2, Users: 1},
+		{URL: "/items", Requests: 1, Rejected: 0, Users: 0},
+	}, report.Routes, "Routes by rejections")
+	assert.Equal(t, testStart, requests[1].Time, "Recorded requests kept")
+}
+
+// go test -timeout 30s -run ^TestReplay_NoRequest$ github.com/zeroboo/gin-request-limitter/limittersim -v
+func TestReplay_NoRequest(t *testing.T) {
+	config := limitter.LimitterConfig{WindowSize: 60000, MaxRequestPerWindow: 2}
+	report := Replay(nil, &config, nil, 0)
+
+	assert.Equal(t, 0, report.Requests, "No request")
+	assert.Empty(t, report.Throttles, "No throttle")
+	assert.Empty(t, report.Curve, "No curve")
+}
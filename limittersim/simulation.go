@@ -0,0 +1,220 @@
+package limittersim
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	limitter "github.com/zeroboo/gin-request-limitter"
+	"github.com/zeroboo/gin-request-limitter/clocktest"
+)
+
+// DefaultStep is width of buckets of curves of simulations with no step
+const DefaultStep time.Duration = time.Minute
+
+// Simulation decides requests by a limitter of a policy in virtual time
+type Simulation struct {
+	//Config is policy under simulation, its clock is Clock
+	Config limitter.LimitterConfig
+
+	//Store keeps trackers of simulated users
+	Store limitter.TrackerStore
+
+	//Clock is virtual time, it is moved to time of each request
+	Clock *clocktest.FakeClock
+
+	//Step is width of buckets of curves of reports, 0 means DefaultStep
+	Step time.Duration
+
+	engine *limitter.Limitter
+}
+
+/*
+NewSimulation returns a simulation of a copy of config on store starting at start, nil store means a store in memory.
+Requests are decided by Limitter.Decide like gin and net/http limitters, so access lists, overrides, bans and shadow mode apply.
+Stores must time trackers by clock of config, stores expiring trackers by wall clock keep them as long as wall clock says
+*/
+func NewSimulation(store limitter.TrackerStore, config *limitter.LimitterConfig, start time.Time) *Simulation {
+	if store == nil {
+		store = limitter.NewMemoryTrackerStore(0)
+	}
+	sim := &Simulation{
+		Config: *config,
+		Store:  store,
+		Clock:  clocktest.NewFakeClock(start),
+	}
+	sim.Config.Clock = sim.Clock
+	sim.engine = limitter.NewLimitter(store, &sim.Config)
+	return sim
+}
+
+// Decision is what limitter of a simulation did to a request
+type Decision struct {
+	Request
+
+	//Status of response, http.StatusOK if request was served
+	Status int
+
+	//Rejected is true if policy rejected request, also in shadow mode where it is served
+	Rejected bool
+}
+
+// Decide moves virtual time to time of request and lets limitter decide it
+func (sim *Simulation) Decide(request Request) Decision {
+	sim.Clock.Set(request.Time)
+	decision := sim.engine.Decide(context.Background(), request.UserId, request.URL, "", http.Header{})
+	return Decision{
+		Request:  request,
+		Status:   decision.GetStatus(),
+		Rejected: !decision.IsAllowed() || decision.Header.Get(limitter.HeaderDecision) == limitter.DECISION_REJECT,
+	}
+}
+
+// Run decides requests of traffic for duration from current virtual time, then moves virtual time to end of run.
+// Requests of traffic out of the run are dropped
+func (sim *Simulation) Run(traffic Traffic, duration time.Duration) *Report {
+	start := sim.Clock.Now()
+	step := sim.Step
+	if step <= 0 {
+		step = DefaultStep
+	}
+	report := newReport(start, duration, step, &sim.Config)
+	end := start.Add(duration)
+	for _, request := range traffic.Generate(start, duration) {
+		if request.Time.Before(start) || !request.Time.Before(end) {
+			continue
+		}
+		report.add(sim.Decide(request))
+	}
+	report.finish()
+	sim.Clock.Set(start.Add(duration))
+	return report
+}
+
+// CurvePoint counts requests of a bucket of a report
+type CurvePoint struct {
+	//Offset is start of bucket from start of simulation
+	Offset time.Duration
+
+	Offered  int
+	Accepted int
+	Rejected int
+}
+
+// Report is outcome of a simulation run
+type Report struct {
+	Start    time.Time
+	Duration time.Duration
+	Step     time.Duration
+
+	Requests int
+	Accepted int
+	Rejected int
+
+	//Statuses counts responses by status code
+	Statuses map[int]int
+
+	//Curve counts requests by buckets of Step
+	Curve []CurvePoint
+
+	//MaxAcceptedPerWindow is most requests of a user to an url accepted in any period as long as a window, 0 if policy has no window.
+	//Fixed windows accept up to twice MaxRequestPerWindow around a window boundary
+	MaxAcceptedPerWindow int
+
+	//MaxAcceptedAt is time of first request of that period
+	MaxAcceptedAt time.Time
+
+	//BurstRatio is MaxAcceptedPerWindow over MaxRequestPerWindow of policy, 0 if policy has no window
+	BurstRatio float64
+
+	window              time.Duration
+	maxRequestPerWindow int64
+	acceptedTimes       map[string][]time.Time
+}
+
+func newReport(start time.Time, duration time.Duration, step time.Duration, config *limitter.LimitterConfig) *Report {
+	buckets := int((duration + step - 1) / step)
+	report := &Report{
+		Start:               start,
+		Duration:            duration,
+		Step:                step,
+		Statuses:            map[int]int{},
+		Curve:               make([]CurvePoint, buckets),
+		window:              time.Duration(config.WindowSize) * time.Millisecond,
+		maxRequestPerWindow: config.MaxRequestPerWindow,
+		acceptedTimes:       map[string][]time.Time{},
+	}
+	for i := range report.Curve {
+		report.Curve[i].Offset = time.Duration(i) * step
+	}
+	return report
+}
+
+func (report *Report) add(decision Decision) {
+	report.Requests += 1
+	report.Statuses[decision.Status] += 1
+	//Request out of curve is counted in totals only
+	point := &CurvePoint{}
+	if index := int(decision.Time.Sub(report.Start) / report.Step); decision.Time.Sub(report.Start) >= 0 && index < len(report.Curve) {
+		point = &report.Curve[index]
+	}
+	point.Offered += 1
+	if decision.Rejected {
+		report.Rejected += 1
+		point.Rejected += 1
+		return
+	}
+	report.Accepted += 1
+	point.Accepted += 1
+	if report.window > 0 {
+		key := limitter.CreateTrackerName(decision.UserId, decision.URL)
+		report.acceptedTimes[key] = append(report.acceptedTimes[key], decision.Time)
+	}
+}
+
+// finish finds the window long period with most accepted requests of a key
+func (report *Report) finish() {
+	for _, times := range report.acceptedTimes {
+		first := 0
+		for last := range times {
+			for times[last].Sub(times[first]) >= report.window {
+				first += 1
+			}
+			if accepted := last - first + 1; accepted > report.MaxAcceptedPerWindow {
+				report.MaxAcceptedPerWindow = accepted
+				report.MaxAcceptedAt = times[first]
+			}
+		}
+	}
+	if report.maxRequestPerWindow > 0 {
+		report.BurstRatio = float64(report.MaxAcceptedPerWindow) / float64(report.maxRequestPerWindow)
+	}
+	report.acceptedTimes = nil
+}
+
+// GetAcceptRatio returns ratio of accepted requests, 1 if there is no request
+func (report *Report) GetAcceptRatio() float64 {
+	if report.Requests == 0 {
+		return 1
+	}
+	return float64(report.Accepted) / float64(report.Requests)
+}
+
+// WriteCurve writes curve as csv with header offsetMs,offered,accepted,rejected
+func (report *Report) WriteCurve(writer io.Writer) error {
+	csvWriter := csv.NewWriter(writer)
+	csvWriter.Write([]string{"offsetMs", "offered", "accepted", "rejected"})
+	for _, point := range report.Curve {
+		csvWriter.Write([]string{
+			strconv.FormatInt(point.Offset.Milliseconds(), 10),
+			strconv.Itoa(point.Offered),
+			strconv.Itoa(point.Accepted),
+			strconv.Itoa(point.Rejected),
+		})
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
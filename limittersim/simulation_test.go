@@ -0,0 +1,109 @@
+package limittersim
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	limitter "github.com/zeroboo/gin-request-limitter"
+)
+
+// go test -timeout 30s -run ^TestSimulation_FixedWindow_AcceptedCappedPerWindow$ github.com/zeroboo/gin-request-limitter/limittersim -v
+func TestSimulation_FixedWindow_AcceptedCappedPerWindow(t *testing.T) {
+	config := limitter.LimitterConfig{WindowSize: 60000, MaxRequestPerWindow: 30, ExpSec: 600}
+	sim := NewSimulation(nil, &config, testStart)
+
+	report := sim.Run(PoissonTraffic{Rate: 1}, 10*time.Minute)
+
+	assert.Equal(t, 10, len(report.Curve), "A point per minute")
+	assert.Equal(t, report.Requests, report.Accepted+report.Rejected, "Every request decided")
+	for _, point := range report.Curve {
+		assert.LessOrEqual(t, point.Accepted, 30, "Accepted capped by window")
+		assert.Equal(t, point.Offered, point.Accepted+point.Rejected, "Every request of point decided")
+	}
+	assert.Equal(t, report.Accepted, report.Statuses[http.StatusOK], "Accepted requests served")
+	assert.Equal(t, report.Rejected, report.Statuses[http.StatusTooManyRequests], "Rejected requests too many")
+	assert.InDelta(t, 0.5, report.GetAcceptRatio(), 0.1, "Half of requests accepted")
+	assert.Equal(t, testStart.Add(10*time.Minute), sim.Clock.Now(), "Clock at end of run")
+}
+
+// go test -timeout 30s -run ^TestSimulation_BurstAtWindowBoundary_TwiceMaxAccepted$ github.com/zeroboo/gin-request-limitter/limittersim -v
+func TestSimulation_BurstAtWindowBoundary_TwiceMaxAccepted(t *testing.T) {
+	config := limitter.LimitterConfig{WindowSize: 60000, MaxRequestPerWindow: 10, ExpSec: 600}
+	sim := NewSimulation(nil, &config, testStart)
+	sim.Step = time.Second
+
+	report := sim.Run(BurstTraffic{BurstRate: 100, BurstDuration: 2 * time.Second, BurstOffset: 59 * time.Second}, 2*time.Minute)
+
+	assert.Equal(t, 20, report.Accepted, "Both windows accepted")
+	assert.Equal(t, 20, report.MaxAcceptedPerWindow, "Window long period around boundary accepted twice max")
+	assert.InDelta(t, 2.0, report.BurstRatio, 0.0001, "Burst ratio")
+	assert.True(t, report.MaxAcceptedAt.Before(testStart.Add(time.Minute)), "Burst starts before boundary")
+	assert.Equal(t, 10, report.Curve[59].Accepted, "Accepted before boundary")
+	assert.Equal(t, 10, report.Curve[60].Accepted, "Accepted after boundary")
+}
+
+// go test -timeout 30s -run ^TestSimulation_Shadow_RejectedServed$ github.com/zeroboo/gin-request-limitter/limittersim -v
+func TestSimulation_Shadow_RejectedServed(t *testing.T) {
+	config := limitter.LimitterConfig{WindowSize: 60000, MaxRequestPerWindow: 5, ExpSec: 600, Shadow: true}
+	sim := NewSimulation(nil, &config, testStart)
+
+	report := sim.Run(PoissonTraffic{Rate: 1}, time.Minute)
+
+	assert.Equal(t, report.Requests, report.Statuses[http.StatusOK], "All requests served")
+	assert.Equal(t, 5, report.Accepted, "Requests over limit rejected in shadow")
+	assert.Equal(t, report.Requests-5, report.Rejected, "Rejected in shadow")
+}
+
+// go test -timeout 30s -run ^TestReport_WriteCurve$ github.com/zeroboo/gin-request-limitter/limittersim -v
+func TestReport_WriteCurve(t *testing.T) {
+	config := limitter.LimitterConfig{WindowSize: 60000, MaxRequestPerWindow: 1, ExpSec: 600}
+	sim := NewSimulation(nil, &config, testStart)
+	traffic := RecordedTraffic{Requests: []Request{
+		{Time: testStart, UserId: "a", URL: "/health"},
+		{Time: testStart.Add(time.Second), UserId: "a", URL: "/health"},
+		{Time: testStart.Add(time.Minute), UserId: "a", URL: "/health"},
+	}}
+
+	var output bytes.Buffer
+	assert.Nil(t, sim.Run(traffic, 2*time.Minute).WriteCurve(&output), "Curve written")
+	assert.Equal(t, "offsetMs,offered,accepted,rejected\n0,2,1,1\n60000,1,1,0\n", output.String(), "Curve")
+}
+
+// trafficFunc generates requests by a function
+type trafficFunc func(start time.Time, duration time.Duration) []Request
+
+func (traffic trafficFunc) Generate(start time.Time, duration time.Duration) []Request {
+	return traffic(start, duration)
+}
+
+// go test -timeout 30s -run ^TestSimulation_RequestsOutOfRun_Dropped$ github.com/zeroboo/gin-request-limitter/limittersim -v
+func TestSimulation_RequestsOutOfRun_Dropped(t *testing.T) {
+	config := limitter.LimitterConfig{WindowSize: 60000, MaxRequestPerWindow: 30, ExpSec: 600}
+	sim := NewSimulation(nil, &config, testStart)
+	traffic := trafficFunc(func(start time.Time, duration time.Duration) []Request {
+		return []Request{
+			{Time: start.Add(-time.Second), UserId: GetUserId(0), URL: DefaultURL},
+			{Time: start, UserId: GetUserId(0), URL: DefaultURL},
+			{Time: start.Add(duration), UserId: GetUserId(0), URL: DefaultURL},
+		}
+	})
+
+	report := sim.Run(traffic, time.Minute)
+
+	assert.Equal(t, 1, report.Requests, "Requests out of run dropped")
+	assert.Equal(t, 1, report.Curve[0].Offered, "Request of run in curve")
+}
+
+// go test -timeout 30s -run ^TestReport_RequestOutOfCurve_CountedInTotals$ github.com/zeroboo/gin-request-limitter/limittersim -v
+func TestReport_RequestOutOfCurve_CountedInTotals(t *testing.T) {
+	config := limitter.LimitterConfig{WindowSize: 60000, MaxRequestPerWindow: 30, ExpSec: 600}
+	report := newReport(testStart, time.Minute, time.Minute, &config)
+
+	report.add(Decision{Request: Request{Time: testStart.Add(time.Minute), UserId: GetUserId(0), URL: DefaultURL}, Status: http.StatusOK})
+
+	assert.Equal(t, 1, report.Accepted, "Request counted")
+	assert.Equal(t, 0, report.Curve[0].Offered, "Request out of curve")
+}
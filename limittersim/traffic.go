@@ -0,0 +1,204 @@
+/*
+Package limittersim replays synthetic or recorded traffic against limitter policies in virtual time,
+to size policies before shipping them
+*/
+
+package limittersim
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// DefaultDiurnalPeriod is period of DiurnalTraffic with no period
+const DefaultDiurnalPeriod time.Duration = 24 * time.Hour
+
+// DefaultURL is url of generated requests with no url
+const DefaultURL string = "/"
+
+// Request is a request of a user to an url at a virtual time
+type Request struct {
+	Time   time.Time
+	UserId string
+	URL    string
+}
+
+// Traffic generates requests ordered by time in [start, start+duration)
+type Traffic interface {
+	Generate(start time.Time, duration time.Duration) []Request
+}
+
+// Population tells who sends generated requests and where to
+type Population struct {
+	//Users is number of users sending requests, each request is sent by a random one. 0 means 1 user
+	Users int
+
+	//URL of requests, empty means DefaultURL
+	URL string
+
+	//Seed of random generator, same seed generates same requests
+	Seed int64
+}
+
+// GetUserId returns id of n-th user
+func GetUserId(n int) string {
+	return fmt.Sprintf("user-%d", n)
+}
+
+func (population Population) createRequest(random *rand.Rand, at time.Time) Request {
+	url := population.URL
+	if len(url) == 0 {
+		url = DefaultURL
+	}
+	user := 0
+	if population.Users > 1 {
+		user = random.Intn(population.Users)
+	}
+	return Request{Time: at, UserId: GetUserId(user), URL: url}
+}
+
+/*
+generateArrivals returns arrivals of a poisson process whose rate per second at offset from start is rate(offset),
+by thinning a process of maxRate
+*/
+func (population Population) generateArrivals(start time.Time, duration time.Duration, maxRate float64,
+	rate func(offset time.Duration) float64) []Request {
+	requests := []Request{}
+	if maxRate <= 0 {
+		return requests
+	}
+	random := rand.New(rand.NewSource(population.Seed))
+	offset := time.Duration(0)
+	for {
+		offset += time.Duration(random.ExpFloat64() / maxRate * float64(time.Second))
+		if offset >= duration {
+			return requests
+		}
+		if random.Float64()*maxRate < rate(offset) {
+			requests = append(requests, population.createRequest(random, start.Add(offset)))
+		}
+	}
+}
+
+// PoissonTraffic sends requests independently at a constant average rate
+type PoissonTraffic struct {
+	Population
+
+	//Rate is average requests per second of all users
+	Rate float64
+}
+
+func (traffic PoissonTraffic) Generate(start time.Time, duration time.Duration) []Request {
+	return traffic.generateArrivals(start, duration, traffic.Rate, func(offset time.Duration) float64 {
+		return traffic.Rate
+	})
+}
+
+// BurstTraffic sends requests at Rate, raised to BurstRate for BurstDuration every BurstInterval
+type BurstTraffic struct {
+	Population
+
+	//Rate is average requests per second of all users out of bursts
+	Rate float64
+
+	//BurstRate is average requests per second of all users in bursts
+	BurstRate float64
+
+	//BurstDuration is length of a burst
+	BurstDuration time.Duration
+
+	//BurstInterval is time between starts of bursts, 0 means a single burst
+	BurstInterval time.Duration
+
+	//BurstOffset is start of first burst after start of traffic, e.g. just before a window boundary
+	BurstOffset time.Duration
+}
+
+// isBursting returns true if offset from start of traffic is in a burst
+func (traffic BurstTraffic) isBursting(offset time.Duration) bool {
+	if offset < traffic.BurstOffset {
+		return false
+	}
+	sinceBurst := offset - traffic.BurstOffset
+	if traffic.BurstInterval > 0 {
+		sinceBurst = sinceBurst % traffic.BurstInterval
+	}
+	return sinceBurst < traffic.BurstDuration
+}
+
+func (traffic BurstTraffic) Generate(start time.Time, duration time.Duration) []Request {
+	return traffic.generateArrivals(start, duration, math.Max(traffic.Rate, traffic.BurstRate), func(offset time.Duration) float64 {
+		if traffic.isBursting(offset) {
+			return traffic.BurstRate
+		}
+		return traffic.Rate
+	})
+}
+
+// DiurnalTraffic sends requests at a rate rising from MinRate to MaxRate and back every Period, like daily traffic
+type DiurnalTraffic struct {
+	Population
+
+	//MinRate is average requests per second of all users at the quietest time
+	MinRate float64
+
+	//MaxRate is average requests per second of all users at peak
+	MaxRate float64
+
+	//Period of traffic, 0 means DefaultDiurnalPeriod
+	Period time.Duration
+
+	//PeakOffset is time of first peak after start of traffic
+	PeakOffset time.Duration
+}
+
+// GetRate returns average requests per second at offset from start of traffic
+func (traffic DiurnalTraffic) GetRate(offset time.Duration) float64 {
+	period := traffic.Period
+	if period <= 0 {
+		period = DefaultDiurnalPeriod
+	}
+	phase := 2 * math.Pi * float64(offset-traffic.PeakOffset) / float64(period)
+	return traffic.MinRate + (traffic.MaxRate-traffic.MinRate)*(1+math.Cos(phase))/2
+}
+
+func (traffic DiurnalTraffic) Generate(start time.Time, duration time.Duration) []Request {
+	return traffic.generateArrivals(start, duration, math.Max(traffic.MinRate, traffic.MaxRate), traffic.GetRate)
+}
+
+// RecordedTraffic replays recorded requests, shifted so the first one is sent at start of traffic
+type RecordedTraffic struct {
+	Requests []Request
+}
+
+func (traffic RecordedTraffic) Generate(start time.Time, duration time.Duration) []Request {
+	recorded := make([]Request, len(traffic.Requests))
+	copy(recorded, traffic.Requests)
+	sort.SliceStable(recorded, func(i, j int) bool { return recorded[i].Time.Before(recorded[j].Time) })
+
+	requests := []Request{}
+	for _, request := range recorded {
+		offset := request.Time.Sub(recorded[0].Time)
+		if offset >= duration {
+			break
+		}
+		request.Time = start.Add(offset)
+		requests = append(requests, request)
+	}
+	return requests
+}
+
+// MergedTraffic sends requests of all its traffics, e.g. steady traffic of many users and bursts of a few
+type MergedTraffic []Traffic
+
+func (traffics MergedTraffic) Generate(start time.Time, duration time.Duration) []Request {
+	requests := []Request{}
+	for _, traffic := range traffics {
+		requests = append(requests, traffic.Generate(start, duration)...)
+	}
+	sort.SliceStable(requests, func(i, j int) bool { return requests[i].Time.Before(requests[j].Time) })
+	return requests
+}
@@ -0,0 +1,96 @@
+package limittersim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testStart time.Time = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// countBetween returns number of requests in [from, to) from start
+func countBetween(requests []Request, from time.Duration, to time.Duration) int {
+	count := 0
+	for _, request := range requests {
+		offset := request.Time.Sub(testStart)
+		if offset >= from && offset < to {
+			count += 1
+		}
+	}
+	return count
+}
+
+// go test -timeout 30s -run ^TestPoissonTraffic_AverageRateOrderedAndRepeatable$ github.com/zeroboo/gin-request-limitter/limittersim -v
+func TestPoissonTraffic_AverageRateOrderedAndRepeatable(t *testing.T) {
+	traffic := PoissonTraffic{Population: Population{Users: 5, Seed: 7}, Rate: 10}
+	requests := traffic.Generate(testStart, 1000*time.Second)
+
+	assert.InDelta(t, 10000, len(requests), 500, "Average rate")
+	users := map[string]bool{}
+	for i, request := range requests {
+		users[request.UserId] = true
+		assert.Equal(t, DefaultURL, request.URL, "Default url")
+		if i > 0 {
+			assert.False(t, request.Time.Before(requests[i-1].Time), "Ordered by time")
+		}
+	}
+	assert.Equal(t, 5, len(users), "Requests of all users")
+	assert.Equal(t, requests, traffic.Generate(testStart, 1000*time.Second), "Same seed generates same requests")
+}
+
+// go test -timeout 30s -run ^TestBurstTraffic_BurstsRaiseRate$ github.com/zeroboo/gin-request-limitter/limittersim -v
+func TestBurstTraffic_BurstsRaiseRate(t *testing.T) {
+	traffic := BurstTraffic{Rate: 1, BurstRate: 100, BurstDuration: 10 * time.Second, BurstInterval: time.Minute, BurstOffset: 30 * time.Second}
+	requests := traffic.Generate(testStart, 2*time.Minute)
+
+	assert.InDelta(t, 1000, countBetween(requests, 30*time.Second, 40*time.Second), 150, "First burst")
+	assert.InDelta(t, 1000, countBetween(requests, 90*time.Second, 100*time.Second), 150, "Second burst")
+	assert.Less(t, countBetween(requests, 0, 30*time.Second), 60, "Before first burst")
+	assert.Less(t, countBetween(requests, 40*time.Second, 90*time.Second), 90, "Between bursts")
+}
+
+// go test -timeout 30s -run ^TestDiurnalTraffic_PeakAndTrough$ github.com/zeroboo/gin-request-limitter/limittersim -v
+func TestDiurnalTraffic_PeakAndTrough(t *testing.T) {
+	traffic := DiurnalTraffic{MinRate: 1, MaxRate: 9, Period: time.Hour, PeakOffset: 15 * time.Minute}
+	assert.InDelta(t, 9, traffic.GetRate(15*time.Minute), 0.0001, "Rate at peak")
+	assert.InDelta(t, 1, traffic.GetRate(45*time.Minute), 0.0001, "Rate at trough")
+	assert.InDelta(t, 9, traffic.GetRate(75*time.Minute), 0.0001, "Rate at next peak")
+	assert.InDelta(t, 5, DiurnalTraffic{MinRate: 1, MaxRate: 9}.GetRate(6*time.Hour), 0.0001, "Default period is a day")
+
+	requests := traffic.Generate(testStart, time.Hour)
+	peak := countBetween(requests, 10*time.Minute, 20*time.Minute)
+	trough := countBetween(requests, 40*time.Minute, 50*time.Minute)
+	assert.Greater(t, peak, 3*trough, "More requests around peak")
+}
+
+// go test -timeout 30s -run ^TestRecordedTraffic_ShiftedToStartAndCut$ github.com/zeroboo/gin-request-limitter/limittersim -v
+func TestRecordedTraffic_ShiftedToStartAndCut(t *testing.T) {
+	recordedAt := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	traffic := RecordedTraffic{Requests: []Request{
+		{Time: recordedAt.Add(2 * time.Second), UserId: "b", URL: "/items"},
+		{Time: recordedAt, UserId: "a", URL: "/health"},
+		{Time: recordedAt.Add(time.Minute), UserId: "c", URL: "/health"},
+	}}
+
+	requests := traffic.Generate(testStart, time.Minute)
+	assert.Equal(t, []Request{
+		{Time: testStart, UserId: "a", URL: "/health"},
+		{Time: testStart.Add(2 * time.Second), UserId: "b", URL: "/items"},
+	}, requests, "Requests shifted to start, ones after duration cut")
+	assert.Equal(t, "b", traffic.Requests[0].UserId, "Recorded requests kept")
+}
+
+// go test -timeout 30s -run ^TestMergedTraffic_Ordered$ github.com/zeroboo/gin-request-limitter/limittersim -v
+func TestMergedTraffic_Ordered(t *testing.T) {
+	traffic := MergedTraffic{
+		PoissonTraffic{Population: Population{URL: "/a", Seed: 1}, Rate: 5},
+		PoissonTraffic{Population: Population{URL: "/b", Seed: 2}, Rate: 5},
+	}
+	requests := traffic.Generate(testStart, time.Minute)
+
+	assert.InDelta(t, 600, len(requests), 100, "Requests of all traffics")
+	for i := 1; i < len(requests); i++ {
+		assert.False(t, requests[i].Time.Before(requests[i-1].Time), "Ordered by time")
+	}
+}
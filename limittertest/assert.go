@@ -0,0 +1,84 @@
+package limittertest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	limitter "github.com/zeroboo/gin-request-limitter"
+)
+
+// AssertStatus asserts status code of response
+func AssertStatus(t testing.TB, recorder *httptest.ResponseRecorder, expected int, msgAndArgs ...interface{}) bool {
+	t.Helper()
+	return assert.Equal(t, expected, recorder.Code, msgAndArgs...)
+}
+
+// AssertStatuses asserts status codes of responses in order, e.g. result of Driver.SendN
+func AssertStatuses(t testing.TB, codes []int, expected ...int) bool {
+	t.Helper()
+	return assert.Equal(t, expected, codes, "Status codes")
+}
+
+// AssertAllowed asserts request was served
+func AssertAllowed(t testing.TB, recorder *httptest.ResponseRecorder) bool {
+	t.Helper()
+	return assert.Less(t, recorder.Code, http.StatusBadRequest, "Request allowed")
+}
+
+// AssertTooFast asserts request was rejected by min request interval
+func AssertTooFast(t testing.TB, recorder *httptest.ResponseRecorder) bool {
+	t.Helper()
+	return AssertStatus(t, recorder, http.StatusTooEarly, "Request too fast")
+}
+
+// AssertTooMany asserts request was rejected by a window limit
+func AssertTooMany(t testing.TB, recorder *httptest.ResponseRecorder) bool {
+	t.Helper()
+	return AssertStatus(t, recorder, http.StatusTooManyRequests, "Request too freequently")
+}
+
+// AssertHeader asserts value of a response header
+func AssertHeader(t testing.TB, recorder *httptest.ResponseRecorder, header string, expected string) bool {
+	t.Helper()
+	return assert.Equal(t, expected, recorder.Header().Get(header), "Header %v", header)
+}
+
+// AssertRetryAfter asserts Retry-After header in seconds is in [minSec, maxSec]
+func AssertRetryAfter(t testing.TB, recorder *httptest.ResponseRecorder, minSec int64, maxSec int64) bool {
+	t.Helper()
+	retryAfterSec, errParse := strconv.ParseInt(recorder.Header().Get("Retry-After"), 10, 64)
+	if !assert.Nil(t, errParse, "Retry-After is seconds") {
+		return false
+	}
+	return assert.GreaterOrEqual(t, retryAfterSec, minSec, "Retry-After") &&
+		assert.LessOrEqual(t, retryAfterSec, maxSec, "Retry-After")
+}
+
+// AssertLimitRule asserts request was rejected by named rule, see limitter.LimitRule
+func AssertLimitRule(t testing.TB, recorder *httptest.ResponseRecorder, rule string) bool {
+	t.Helper()
+	return AssertHeader(t, recorder, limitter.HeaderLimitRule, rule)
+}
+
+// AssertBanned asserts request was rejected by a ban of given level
+func AssertBanned(t testing.TB, recorder *httptest.ResponseRecorder, banLevel int64) bool {
+	t.Helper()
+	return AssertStatus(t, recorder, http.StatusForbidden, "Request banned") &&
+		AssertHeader(t, recorder, limitter.HeaderBanLevel, strconv.FormatInt(banLevel, 10)) &&
+		assert.NotEmpty(t, recorder.Header().Get(limitter.HeaderBanUntil), "Ban end reported")
+}
+
+// AssertDecision asserts decision of shadow mode, one of limitter.DECISION_XXX
+func AssertDecision(t testing.TB, recorder *httptest.ResponseRecorder, decision string) bool {
+	t.Helper()
+	return AssertHeader(t, recorder, limitter.HeaderDecision, decision)
+}
+
+// AssertCandidateDecision asserts decision of candidate policy, one of limitter.DECISION_XXX
+func AssertCandidateDecision(t testing.TB, recorder *httptest.ResponseRecorder, decision string) bool {
+	t.Helper()
+	return AssertHeader(t, recorder, limitter.HeaderCandidateDecision, decision)
+}
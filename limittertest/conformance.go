@@ -0,0 +1,266 @@
+package limittertest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	limitter "github.com/zeroboo/gin-request-limitter"
+	"github.com/zeroboo/gin-request-limitter/clocktest"
+)
+
+// ConformanceBackend is a store under test of RunStoreConformance with hooks on its backend
+type ConformanceBackend struct {
+	Store limitter.TrackerStore
+
+	//Expire moves time of backend forward by duration so trackers idle for it expire, e.g. TTL of redis keys.
+	//Stores expiring trackers by clock of config give a no-op, nil skips expiry cases
+	Expire func(duration time.Duration)
+
+	//SharedNamespaces is true if backend keeps trackers of all namespaces together, e.g. redis. It skips namespace cases
+	SharedNamespaces bool
+}
+
+// StoreFactory creates a backend for a conformance case, it can skip test if backend is not available
+type StoreFactory func(t *testing.T) ConformanceBackend
+
+// Concurrent case: ConformanceGoroutines send requests together, each of them sends ConformanceRequestsPerGoroutine
+const ConformanceGoroutines int = 8
+const ConformanceRequestsPerGoroutine int = 10
+
+// ConformanceConfig is policy of conformance cases. Its windows divide a minute
+var ConformanceConfig limitter.LimitterConfig = limitter.LimitterConfig{
+	MinRequestInterval:  1000,
+	WindowSize:          60000,
+	MaxRequestPerWindow: 2,
+	ExpSec:              600,
+	BanThreshold:        3,
+	BanPeriod:           60000,
+	BanDurations:        []int64{60000},
+}
+
+// conformanceCase is a behavior every store must have
+type conformanceCase struct {
+	name string
+	run  func(t *testing.T, backend ConformanceBackend)
+}
+
+/*
+RunStoreConformance runs cases every TrackerStore must pass against stores of factory, each case gets a new store.
+Cases use random user ids so stores can keep trackers of previous cases. They cover:
+
+  - min request interval, window rollover and expiry of trackers
+
+  - trackers of namespaces set by limitter.WithTrackerNamespace isolated
+
+  - rejected requests not counted, violations and bans saved
+
+  - requests of many goroutines counted without loss
+
+  - errors of validate returned as is, failing calls not counted
+*/
+func RunStoreConformance(t *testing.T, factory StoreFactory) {
+	for _, testCase := range conformanceCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.run(t, factory(t))
+		})
+	}
+}
+
+// createConformanceConfig returns ConformanceConfig with a fake clock at start of current minute, so windows of a case start together.
+// Clock is close to wall clock so backends expiring trackers at wall clock time keep them as long as the case needs
+func createConformanceConfig() (*limitter.LimitterConfig, *clocktest.FakeClock) {
+	clock := clocktest.NewFakeClock(time.UnixMilli(time.Now().UnixMilli() / 60000 * 60000))
+	config := ConformanceConfig
+	config.Clock = clock
+	return &config, clock
+}
+
+// SendRequest validates a request of userId to url at current time of config the way limitters do
+func SendRequest(ctx context.Context, store limitter.TrackerStore, config *limitter.LimitterConfig, userId string, url string) (*limitter.RequestTracker, error) {
+	currentTime := config.Now()
+	return store.UpdateTracker(ctx, userId, url, config, func(tracker *limitter.RequestTracker) error {
+		return limitter.ValidateRequest(tracker, currentTime, url, "", config)
+	})
+}
+
+func sendRequest(store limitter.TrackerStore, config *limitter.LimitterConfig, userId string, url string) (*limitter.RequestTracker, error) {
+	return SendRequest(context.Background(), store, config, userId, url)
+}
+
+func millis(milis int64) time.Duration {
+	return time.Duration(milis) * time.Millisecond
+}
+
+var conformanceCases []conformanceCase = []conformanceCase{
+	{"MinInterval_TooFastRejectedNotCounted", func(t *testing.T, backend ConformanceBackend) {
+		config, clock := createConformanceConfig()
+		userId := RandomString(16)
+
+		tracker, err := sendRequest(backend.Store, config, userId, "/health")
+		assert.Nil(t, err, "First request allowed")
+		assert.Equal(t, int64(1), tracker.WindowRequest, "First request counted")
+
+		clock.Advance(100 * time.Millisecond)
+		_, err = sendRequest(backend.Store, config, userId, "/health")
+		assert.ErrorIs(t, err, limitter.ErrorRequestTooFast, "Request too fast")
+
+		clock.Advance(millis(config.MinRequestInterval))
+		tracker, err = sendRequest(backend.Store, config, userId, "/health")
+		assert.Nil(t, err, "Request after interval allowed")
+		assert.Equal(t, int64(2), tracker.WindowRequest, "Rejected request not counted")
+	}},
+	{"Window_FullRejectedAndRolledOver", func(t *testing.T, backend ConformanceBackend) {
+		config, clock := createConformanceConfig()
+		userId := RandomString(16)
+
+		for i := 0; i < 2; i++ {
+			_, err := sendRequest(backend.Store, config, userId, "/health")
+			assert.Nil(t, err, "Request in window allowed")
+			clock.Advance(millis(config.MinRequestInterval))
+		}
+		_, err := sendRequest(backend.Store, config, userId, "/health")
+		assert.ErrorIs(t, err, limitter.ErrorRequestTooFreequently, "Window full")
+
+		clock.Advance(millis(config.WindowSize))
+		tracker, err := sendRequest(backend.Store, config, userId, "/health")
+		assert.Nil(t, err, "Request of next window allowed")
+		assert.Equal(t, int64(1), tracker.WindowRequest, "Window rolled over")
+	}},
+	{"Tracker_ExpiredStartedOver", func(t *testing.T, backend ConformanceBackend) {
+		if backend.Expire == nil {
+			t.Skip("Backend time can not be moved")
+		}
+		config, clock := createConformanceConfig()
+		config.BanPeriod = 0
+		userId := RandomString(16)
+
+		sendRequest(backend.Store, config, userId, "/health")
+		sendRequest(backend.Store, config, userId, "/health")
+		sendRequest(backend.Store, config, userId, "/health")
+
+		idle := time.Duration(config.ExpSec)*time.Second + time.Second
+		clock.Advance(idle)
+		backend.Expire(idle)
+		sendRequest(backend.Store, config, userId, "/health")
+		tracker, err := sendRequest(backend.Store, config, userId, "/health")
+		assert.ErrorIs(t, err, limitter.ErrorRequestTooFast, "Violation of new tracker is not a ban")
+		assert.Equal(t, int64(1), tracker.Violations, "Violations of expired tracker forgotten")
+	}},
+	{"Keys_Isolated", func(t *testing.T, backend ConformanceBackend) {
+		config, _ := createConformanceConfig()
+		userId := RandomString(16)
+
+		sendRequest(backend.Store, config, userId, "/health")
+		_, err := sendRequest(backend.Store, config, userId, "/items")
+		assert.Nil(t, err, "Other url of user allowed")
+		_, err = sendRequest(backend.Store, config, RandomString(16), "/health")
+		assert.Nil(t, err, "Other user allowed")
+	}},
+	{"Namespaces_Isolated", func(t *testing.T, backend ConformanceBackend) {
+		if backend.SharedNamespaces {
+			t.Skip("Backend keeps namespaces together")
+		}
+		config, _ := createConformanceConfig()
+		config.MinRequestInterval = 0
+		config.BanThreshold = 0
+		userId := RandomString(16)
+		first := limitter.WithTrackerNamespace(context.Background(), RandomString(8))
+		second := limitter.WithTrackerNamespace(context.Background(), RandomString(8))
+
+		for i := 0; i < 2; i++ {
+			_, err := SendRequest(first, backend.Store, config, userId, "/health")
+			assert.Nil(t, err, "Request in window of first namespace allowed")
+		}
+		_, err := SendRequest(second, backend.Store, config, userId, "/health")
+		assert.Nil(t, err, "Same user in other namespace allowed")
+		_, err = SendRequest(first, backend.Store, config, userId, "/health")
+		assert.ErrorIs(t, err, limitter.ErrorRequestTooFreequently, "Window of first namespace full")
+		tracker, err := SendRequest(second, backend.Store, config, userId, "/health")
+		assert.Nil(t, err, "Window of other namespace not full")
+		assert.Equal(t, int64(2), tracker.WindowRequest, "Requests of namespaces counted apart")
+		_, err = SendRequest(second, backend.Store, config, userId, "/health")
+		assert.ErrorIs(t, err, limitter.ErrorRequestTooFreequently, "Window of other namespace full")
+	}},
+	{"Violations_SavedUntilBan", func(t *testing.T, backend ConformanceBackend) {
+		config, clock := createConformanceConfig()
+		userId := RandomString(16)
+
+		sendRequest(backend.Store, config, userId, "/health")
+		errs := []error{}
+		for i := 0; i < 3; i++ {
+			_, err := sendRequest(backend.Store, config, userId, "/health")
+			errs = append(errs, err)
+		}
+		assert.ErrorIs(t, errs[0], limitter.ErrorRequestTooFast, "First violation")
+		assert.ErrorIs(t, errs[2], limitter.ErrorRequestBanned, "Violations saved until ban")
+
+		clock.Advance(millis(config.MinRequestInterval))
+		_, err := sendRequest(backend.Store, config, userId, "/health")
+		assert.ErrorIs(t, err, limitter.ErrorRequestBanned, "Ban saved")
+	}},
+	{"Concurrent_IncrementsNotLost", func(t *testing.T, backend ConformanceBackend) {
+		config, _ := createConformanceConfig()
+		config.MinRequestInterval = 0
+		config.MaxRequestPerWindow = 1000
+		userId := RandomString(16)
+
+		var wait sync.WaitGroup
+		var mutex sync.Mutex
+		errs := []error{}
+		for i := 0; i < ConformanceGoroutines; i++ {
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+				for j := 0; j < ConformanceRequestsPerGoroutine; j++ {
+					if _, err := sendRequest(backend.Store, config, userId, "/health"); err != nil {
+						mutex.Lock()
+						errs = append(errs, err)
+						mutex.Unlock()
+					}
+				}
+			}()
+		}
+		wait.Wait()
+
+		assert.Empty(t, errs, "All requests allowed")
+		tracker, err := sendRequest(backend.Store, config, userId, "/health")
+		assert.Nil(t, err, "Last request allowed")
+		assert.Equal(t, int64(ConformanceGoroutines*ConformanceRequestsPerGoroutine+1), tracker.WindowRequest, "No request lost")
+	}},
+	{"Failure_ValidateErrorReturnedAsIs", func(t *testing.T, backend ConformanceBackend) {
+		config, _ := createConformanceConfig()
+		userId := RandomString(16)
+		errRule := &limitter.RuleLimitError{Rule: "custom", Err: limitter.ErrorRequestTooFreequently}
+
+		_, err := backend.Store.UpdateTracker(context.Background(), userId, "/health", config, func(tracker *limitter.RequestTracker) error {
+			tracker.UpdateRequest(config.Now(), config)
+			return errRule
+		})
+		var errLimit *limitter.RuleLimitError
+		assert.True(t, errors.As(err, &errLimit), "Error of validate returned")
+		assert.Equal(t, "custom", errLimit.Rule, "Error of validate returned as is")
+
+		tracker, err := sendRequest(backend.Store, config, userId, "/health")
+		assert.Nil(t, err, "Next request allowed")
+		assert.Equal(t, int64(1), tracker.WindowRequest, "Rejected request not counted")
+	}},
+	{"Failure_ContextCanceledNotCounted", func(t *testing.T, backend ConformanceBackend) {
+		config, _ := createConformanceConfig()
+		userId := RandomString(16)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := SendRequest(ctx, backend.Store, config, userId, "/health")
+		assert.NotNil(t, err, "Canceled call failed")
+		assert.False(t, limitter.IsValidateError(err), "Failure is not a rejection")
+
+		tracker, err := sendRequest(backend.Store, config, userId, "/health")
+		assert.Nil(t, err, "Next request allowed")
+		assert.Equal(t, int64(1), tracker.WindowRequest, "Failed request not counted")
+	}},
+}
@@ -0,0 +1,20 @@
+package limittertest
+
+import (
+	"testing"
+	"time"
+)
+
+// go test -timeout 30s -run ^TestRunStoreConformance_MemoryStore$ github.com/zeroboo/gin-request-limitter/limittertest -v
+func TestRunStoreConformance_MemoryStore(t *testing.T) {
+	RunStoreConformance(t, func(t *testing.T) ConformanceBackend {
+		return ConformanceBackend{Store: NewMemoryStore(), Expire: func(duration time.Duration) {}}
+	})
+}
+
+// go test -timeout 30s -run ^TestRunStoreConformance_FaultStoreWithoutFaults$ github.com/zeroboo/gin-request-limitter/limittertest -v
+func TestRunStoreConformance_FaultStoreWithoutFaults(t *testing.T) {
+	RunStoreConformance(t, func(t *testing.T) ConformanceBackend {
+		return ConformanceBackend{Store: NewFaultStore(NewMemoryStore()), Expire: func(duration time.Duration) {}}
+	})
+}
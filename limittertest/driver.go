@@ -0,0 +1,133 @@
+package limittertest
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FieldNameUserId is gin context field user id is set in by authentication handlers of this package
+const FieldNameUserId string = "userId"
+
+// HeaderUserId is header NewRouter reads user id from
+const HeaderUserId string = "X-Test-User-Id"
+
+// CreateRequest returns a forged http request, params are sent as url encoded form
+func CreateRequest(method string, urlPath string, headers map[string][]string, params map[string][]string) *http.Request {
+	requestParams := url.Values{}
+	for paramKey, paramValues := range params {
+		for _, paramValue := range paramValues {
+			requestParams.Add(paramKey, paramValue)
+		}
+	}
+	payload := requestParams.Encode()
+
+	req, _ := http.NewRequest(method, urlPath, strings.NewReader(payload))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Content-Length", strconv.Itoa(len(payload)))
+	for headerKey, headerValues := range headers {
+		for _, headerValue := range headerValues {
+			req.Header.Add(headerKey, headerValue)
+		}
+	}
+	return req
+}
+
+// RecordRequest serves a forged request by a new router running handlers and returns the response
+func RecordRequest(method string, urlPath string, headers map[string][]string, params map[string][]string, handlers ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Handle(method, urlPath, handlers...)
+	return ServeRequest(router, CreateRequest(method, urlPath, headers, params))
+}
+
+// ServeRequest serves request by handler and returns the response
+func ServeRequest(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// HandleHealth responds OK
+func HandleHealth(c *gin.Context) {
+	c.String(http.StatusOK, "OK")
+}
+
+// CreateFakeAuthenticationHandler returns a handler accepts all requests as userIdValue
+func CreateFakeAuthenticationHandler(fieldNameUserId string, userIdValue string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(fieldNameUserId, userIdValue)
+	}
+}
+
+// CreateHeaderAuthenticationHandler returns a handler accepts all requests as user id in given header
+func CreateHeaderAuthenticationHandler(fieldNameUserId string, header string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(fieldNameUserId, c.GetHeader(header))
+	}
+}
+
+// NewRouter returns a gin router in test mode serving method and path by handlers, user id is read from HeaderUserId to FieldNameUserId
+func NewRouter(method string, path string, handlers ...gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Handle(method, path, append([]gin.HandlerFunc{CreateHeaderAuthenticationHandler(FieldNameUserId, HeaderUserId)}, handlers...)...)
+	return router
+}
+
+// Driver sends requests of users to a router
+type Driver struct {
+	Handler http.Handler
+	Method  string
+	Path    string
+
+	//Headers are added to every request
+	Headers map[string][]string
+
+	//RemoteAddr of requests, empty means default of httptest
+	RemoteAddr string
+}
+
+func NewDriver(handler http.Handler, method string, path string) *Driver {
+	return &Driver{
+		Handler: handler,
+		Method:  method,
+		Path:    path,
+		Headers: map[string][]string{},
+	}
+}
+
+// Send sends a request of userId and returns the response
+func (driver *Driver) Send(userId string) *httptest.ResponseRecorder {
+	req := CreateRequest(driver.Method, driver.Path, driver.Headers, nil)
+	req.Header.Set(HeaderUserId, userId)
+	if len(driver.RemoteAddr) > 0 {
+		req.RemoteAddr = driver.RemoteAddr
+	}
+	return ServeRequest(driver.Handler, req)
+}
+
+// SendN sends n requests of userId one after another and returns their status codes
+func (driver *Driver) SendN(userId string, n int) []int {
+	codes := make([]int, n)
+	for i := range codes {
+		codes[i] = driver.Send(userId).Code
+	}
+	return codes
+}
+
+var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+
+// RandomString returns n random letters, e.g. a user id not used by other tests
+func RandomString(n int) string {
+	b := make([]rune, n)
+	for i := range b {
+		b[i] = letterRunes[rand.Intn(len(letterRunes))]
+	}
+	return string(b)
+}
@@ -0,0 +1,134 @@
+package limittertest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	limitter "github.com/zeroboo/gin-request-limitter"
+)
+
+var ErrorInjectedFault = fmt.Errorf("injected store fault")
+
+// Kinds of faults of a FaultStore
+const FAULT_NONE int = 0
+
+// FAULT_ERROR fails a call without calling wrapped store
+const FAULT_ERROR int = 1
+
+// FAULT_PARTIAL_WRITE validates request but persists only its penalty, error is returned
+const FAULT_PARTIAL_WRITE int = 2
+
+// FAULT_UNACKED_WRITE persists validated tracker but returns error, like a write whose response is lost
+const FAULT_UNACKED_WRITE int = 3
+
+// Fault is a failure injected in calls of a FaultStore
+type Fault struct {
+	//Kind is one of FAULT_XXX
+	Kind int
+
+	//Err returned by faulty calls, nil means ErrorInjectedFault
+	Err error
+
+	//Latency added before each call, a call whose context is done first returns context error
+	Latency time.Duration
+}
+
+// errorPartialWrite makes wrapped store discard validated tracker, it is not a validate error so only penalty is persisted
+var errorPartialWrite = fmt.Errorf("partial write")
+
+// FaultStore wraps a store and injects failures in UpdateTracker calls
+type FaultStore struct {
+	Store limitter.TrackerStore
+
+	mutex     sync.Mutex
+	fault     Fault
+	remaining int
+	calls     int
+	faults    int
+}
+
+func NewFaultStore(store limitter.TrackerStore) *FaultStore {
+	return &FaultStore{Store: store}
+}
+
+// Inject applies fault to next calls, calls <= 0 means until Clear
+func (store *FaultStore) Inject(fault Fault, calls int) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if fault.Err == nil {
+		fault.Err = ErrorInjectedFault
+	}
+	store.fault = fault
+	store.remaining = calls
+}
+
+// Clear removes injected fault
+func (store *FaultStore) Clear() {
+	store.Inject(Fault{}, 0)
+}
+
+// GetCalls returns number of UpdateTracker calls and number of faulty ones
+func (store *FaultStore) GetCalls() (int, int) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.calls, store.faults
+}
+
+// nextFault returns fault of a new call
+func (store *FaultStore) nextFault() Fault {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.calls += 1
+	fault := store.fault
+	if fault.Kind == FAULT_NONE && fault.Latency == 0 {
+		return fault
+	}
+	if fault.Kind != FAULT_NONE {
+		store.faults += 1
+	}
+	if store.remaining > 0 {
+		store.remaining -= 1
+		if store.remaining == 0 {
+			store.fault = Fault{}
+		}
+	}
+	return fault
+}
+
+func (store *FaultStore) UpdateTracker(ctx context.Context, userId string, url string, config *limitter.LimitterConfig,
+	validate func(tracker *limitter.RequestTracker) error) (*limitter.RequestTracker, error) {
+	fault := store.nextFault()
+	if fault.Latency > 0 {
+		timer := time.NewTimer(fault.Latency)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	switch fault.Kind {
+	case FAULT_ERROR:
+		return nil, fault.Err
+	case FAULT_PARTIAL_WRITE:
+		var errValidate error
+		tracker, _ := store.Store.UpdateTracker(ctx, userId, url, config, func(tracker *limitter.RequestTracker) error {
+			errValidate = validate(tracker)
+			return errorPartialWrite
+		})
+		if errValidate != nil {
+			return tracker, errValidate
+		}
+		return tracker, fault.Err
+	case FAULT_UNACKED_WRITE:
+		tracker, errUpdate := store.Store.UpdateTracker(ctx, userId, url, config, validate)
+		if errUpdate != nil {
+			return tracker, errUpdate
+		}
+		return tracker, fault.Err
+	}
+	return store.Store.UpdateTracker(ctx, userId, url, config, validate)
+}
@@ -0,0 +1,58 @@
+package limittertest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	limitter "github.com/zeroboo/gin-request-limitter"
+	"github.com/zeroboo/gin-request-limitter/clocktest"
+)
+
+// go test -timeout 30s -run ^TestFaultStore_Error_AbortOnFail$ github.com/zeroboo/gin-request-limitter/limittertest -v
+func TestFaultStore_Error_AbortOnFail(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.UnixMilli(60000 * 1000))
+	store := NewFaultStore(NewMemoryStore())
+	store.Inject(Fault{Kind: FAULT_ERROR}, 2)
+
+	AssertStatuses(t, createTestLimitter(store, clock, true).SendN("user", 3),
+		http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK)
+	AssertStatuses(t, createTestLimitter(store, clock, false).SendN("other", 1), http.StatusOK)
+
+	calls, faults := store.GetCalls()
+	assert.Equal(t, 4, calls, "Calls")
+	assert.Equal(t, 2, faults, "Faulty calls")
+}
+
+// go test -timeout 30s -run ^TestFaultStore_Writes_PartialNotCountedUnackedCounted$ github.com/zeroboo/gin-request-limitter/limittertest -v
+func TestFaultStore_Writes_PartialNotCountedUnackedCounted(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.UnixMilli(60000 * 1000))
+	memory := NewMemoryStore()
+	memory.Clock = clock
+	store := NewFaultStore(memory)
+	driver := createTestLimitter(store, clock, false)
+
+	store.Inject(Fault{Kind: FAULT_PARTIAL_WRITE}, 1)
+	AssertAllowed(t, driver.Send("user"))
+	_, errGet := memory.GetTracker(context.Background(), "user", "/health")
+	assert.ErrorIs(t, errGet, limitter.ErrorTrackerNotFound, "Partial write does not save request")
+
+	store.Inject(Fault{Kind: FAULT_UNACKED_WRITE}, 1)
+	AssertAllowed(t, driver.Send("user"))
+	tracker, _ := memory.GetTracker(context.Background(), "user", "/health")
+	assert.Equal(t, int64(1), tracker.WindowRequest, "Unacked write saves request")
+}
+
+// go test -timeout 30s -run ^TestFaultStore_Latency_DeadlineExceeded$ github.com/zeroboo/gin-request-limitter/limittertest -v
+func TestFaultStore_Latency_DeadlineExceeded(t *testing.T) {
+	store := NewFaultStore(NewMemoryStore())
+	store.Inject(Fault{Latency: time.Second}, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, errUpdate := store.UpdateTracker(ctx, "user", "/health", &testConfig, func(tracker *limitter.RequestTracker) error { return nil })
+
+	assert.ErrorIs(t, errUpdate, context.DeadlineExceeded, "Slow store times out")
+}
@@ -0,0 +1,17 @@
+/*
+Package limittertest provides stores, request drivers and assertions to test limitters and applications using them
+*/
+
+package limittertest
+
+import (
+	limitter "github.com/zeroboo/gin-request-limitter"
+)
+
+// MemoryStore keeps trackers in memory of current process, see limitter.MemoryTrackerStore
+type MemoryStore = limitter.MemoryTrackerStore
+
+// NewMemoryStore returns a memory store keeping all trackers until they expire
+func NewMemoryStore() *MemoryStore {
+	return limitter.NewMemoryTrackerStore(0)
+}
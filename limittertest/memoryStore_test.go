@@ -0,0 +1,72 @@
+package limittertest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	limitter "github.com/zeroboo/gin-request-limitter"
+	"github.com/zeroboo/gin-request-limitter/clocktest"
+)
+
+var testConfig limitter.LimitterConfig = limitter.LimitterConfig{
+	MinRequestInterval:  0,
+	WindowSize:          60000,
+	MaxRequestPerWindow: 2,
+	ExpSec:              600,
+	BanThreshold:        2,
+	BanPeriod:           60000,
+	BanDurations:        []int64{60000},
+}
+
+func createTestLimitter(store limitter.TrackerStore, clock limitter.Clock, abortOnFail bool) *Driver {
+	config := testConfig
+	config.Clock = clock
+	config.AbortOnFail = abortOnFail
+	handler := limitter.CreateStoreBackedLimitter(store, limitter.GetUserIdFromContextByField(FieldNameUserId), &config, false)
+	return NewDriver(NewRouter(http.MethodGet, "/health", handler, HandleHealth), http.MethodGet, "/health")
+}
+
+// go test -timeout 30s -run ^TestMemoryStore_RejectedRequest_NotCounted$ github.com/zeroboo/gin-request-limitter/limittertest -v
+func TestMemoryStore_RejectedRequest_NotCounted(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.UnixMilli(60000 * 1000))
+	store := NewMemoryStore()
+	store.Clock = clock
+	driver := createTestLimitter(store, clock, false)
+
+	AssertStatuses(t, driver.SendN("user", 3), http.StatusOK, http.StatusOK, http.StatusTooManyRequests)
+	tracker, errGet := store.GetTracker(context.Background(), "user", "/health")
+	assert.Nil(t, errGet, "Tracker saved")
+	assert.Equal(t, int64(2), tracker.WindowRequest, "Rejected request not counted")
+	assert.Equal(t, int64(1), tracker.Violations, "Violation saved")
+	assert.Equal(t, 3, store.GetCalls(), "Store called")
+
+	clock.Advance(time.Duration(testConfig.ExpSec) * time.Second)
+	_, errGet = store.GetTracker(context.Background(), "user", "/health")
+	assert.ErrorIs(t, errGet, limitter.ErrorTrackerNotFound, "Tracker expired")
+	AssertStatus(t, driver.Send("user"), http.StatusOK, "Expired tracker starts over")
+}
+
+// go test -timeout 30s -run ^TestMemoryStore_LiftBan_Served$ github.com/zeroboo/gin-request-limitter/limittertest -v
+func TestMemoryStore_LiftBan_Served(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.UnixMilli(60000 * 1000))
+	store := NewMemoryStore()
+	store.Clock = clock
+	driver := createTestLimitter(store, clock, false)
+	driver.SendN("user", 3)
+
+	recorder := driver.Send("user")
+	AssertBanned(t, recorder, 1)
+	AssertRetryAfter(t, recorder, 60, 60)
+
+	assert.Nil(t, store.LiftBan(context.Background(), "user", "/health"), "Ban lifted")
+	AssertTooMany(t, driver.Send("user"))
+
+	trackers, _ := store.GetUserTrackers(context.Background(), "user")
+	assert.Equal(t, 1, len(trackers), "Trackers of user")
+	deleted, _ := store.ResetUserTrackers(context.Background(), "user")
+	assert.Equal(t, 1, deleted, "Trackers of user deleted")
+	AssertAllowed(t, driver.Send("user"))
+}
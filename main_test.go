@@ -12,7 +12,6 @@ import (
 	"cloud.google.com/go/datastore"
 	"github.com/gin-gonic/gin"
 	"google.golang.org/api/iterator"
-	"google.golang.org/api/option"
 
 	"strconv"
 	"strings"
@@ -61,38 +60,43 @@ func TestMain(m *testing.M) {
 	log.SetOutput(os.Stdout)
 	log.SetLevel(log.TraceLevel)
 
-	datastoreProjectId := os.Getenv("DATASTORE_PROJECT_ID")
-	serviceAccount := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
-
-	//Init datastore
-	var errDatastore error
-	dsClient, errDatastore = datastore.NewClient(context.Background(), datastoreProjectId, option.WithCredentialsFile(serviceAccount))
-	log.Printf("Init datastore: projectId=%v, error=%v, ", datastoreProjectId, errDatastore)
-
 	//Init random
 	rand.Seed(time.Now().UnixNano())
 
-	//Init redis
-	InitRedis("test", "dev", "127.0.0.1:6379", "", 0)
+	//Init redis and datastore
+	stopBackends, errBackends := setupTestBackends()
+	if errBackends != nil {
+		log.Errorf("TestMain: SetupBackendsFailed, error=%v", errBackends)
+		os.Exit(1)
+	}
 
 	CleanupTestData()
 	//Run all tests
 	exitCode := m.Run()
 
+	stopBackends()
 	os.Exit(exitCode)
 }
 
+// CleanupTestData deletes trackers of previous runs from datastore if tests have one
 func CleanupTestData() {
+	if dsClient == nil {
+		return
+	}
 	ctx := context.Background()
 	query := datastore.NewQuery(DatastoreKindRequestTracker)
 
 	it := dsClient.Run(ctx, query)
 	for {
 		var tracker RequestTracker = RequestTracker{}
-		key, errQuery := it.Next(tracker)
+		key, errQuery := it.Next(&tracker)
 		if errQuery == iterator.Done {
 			break
 		}
+		if key == nil {
+			log.Printf("Query trackers failed, error=%v", errQuery)
+			break
+		}
 		errDelete := dsClient.Delete(ctx, key)
 		log.Printf("Delete key %v, error=%v", key, errDelete)
 	}
@@ -0,0 +1,211 @@
+/*
+In-process cache of rejected keys in front of a tracker store
+*/
+
+package limitter
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const DefaultNearCacheMaxSize int = 10000
+
+// NearCacheStats are counters of a near cache since it was created
+type NearCacheStats struct {
+	//Hits is number of requests rejected locally
+	Hits int64
+	//Misses is number of requests sent to store
+	Misses int64
+	//Evictions is number of rejected keys removed before their retry time to keep cache bounded
+	Evictions int64
+	//Size is current number of rejected keys
+	Size int
+}
+
+// HitRatio returns ratio of requests rejected locally, 0 if there is no request
+func (stats NearCacheStats) HitRatio() float64 {
+	total := stats.Hits + stats.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(stats.Hits) / float64(total)
+}
+
+type nearCacheEntry struct {
+	key       trackerCacheKey
+	tracker   RequestTracker
+	err       error
+	retryTime int64
+}
+
+/*
+NearCacheTrackerStore wraps a store and remembers keys rejected by it.
+Until retry time of a rejected key, its requests are rejected without calling wrapped store.
+Requests rejected locally do not update tracker in store.
+If policy bans repeat offenders, only banned keys are remembered so violations still reach the store.
+*/
+type NearCacheTrackerStore struct {
+	Store TrackerStore
+
+	//MaxSize is max number of rejected keys, least recently used ones are evicted
+	MaxSize int
+
+	mutex   sync.Mutex
+	entries map[trackerCacheKey]*list.Element
+	lru     *list.List
+	stats   NearCacheStats
+}
+
+func NewNearCacheTrackerStore(store TrackerStore, maxSize int) *NearCacheTrackerStore {
+	if maxSize <= 0 {
+		maxSize = DefaultNearCacheMaxSize
+	}
+	return &NearCacheTrackerStore{
+		Store:   store,
+		MaxSize: maxSize,
+		entries: map[trackerCacheKey]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// GetStats returns a snapshot of cache counters
+func (cache *NearCacheTrackerStore) GetStats() NearCacheStats {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	stats := cache.stats
+	stats.Size = cache.lru.Len()
+	return stats
+}
+
+// get returns entry of key if it is still rejected at currentTime
+func (cache *NearCacheTrackerStore) get(key trackerCacheKey, currentTime time.Time) *nearCacheEntry {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	element, found := cache.entries[key]
+	if !found {
+		cache.stats.Misses += 1
+		return nil
+	}
+	entry := element.Value.(*nearCacheEntry)
+	if currentTime.UnixMilli() >= entry.retryTime {
+		cache.lru.Remove(element)
+		delete(cache.entries, key)
+		cache.stats.Misses += 1
+		return nil
+	}
+	cache.lru.MoveToFront(element)
+	cache.stats.Hits += 1
+	return entry
+}
+
+func (cache *NearCacheTrackerStore) put(entry *nearCacheEntry) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, found := cache.entries[entry.key]; found {
+		element.Value = entry
+		cache.lru.MoveToFront(element)
+		return
+	}
+	for cache.lru.Len() >= cache.MaxSize {
+		oldest := cache.lru.Back()
+		cache.lru.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*nearCacheEntry).key)
+		cache.stats.Evictions += 1
+	}
+	cache.entries[entry.key] = cache.lru.PushFront(entry)
+}
+
+// Remove forgets rejected key of userId and url in namespace of context
+func (cache *NearCacheTrackerStore) Remove(ctx context.Context, userId string, url string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	key := createTrackerCacheKey(ctx, userId, url)
+	if element, found := cache.entries[key]; found {
+		cache.lru.Remove(element)
+		delete(cache.entries, key)
+	}
+}
+
+// UpdateTracker rejects locally if key is still rejected in namespace of context, calls wrapped store otherwise
+func (cache *NearCacheTrackerStore) UpdateTracker(ctx context.Context, userId string, url string, config *LimitterConfig,
+	validate func(tracker *RequestTracker) error) (*RequestTracker, error) {
+	key := createTrackerCacheKey(ctx, userId, url)
+	if entry := cache.get(key, config.Now()); entry != nil {
+		tracker := entry.tracker
+		return &tracker, entry.err
+	}
+
+	tracker, err := cache.Store.UpdateTracker(ctx, userId, url, config, validate)
+	//Violations must reach store to be counted, so only bans are cached if policy bans
+	if tracker != nil && IsValidateError(err) && (!config.IsBanEnabled() || errors.Is(err, ErrorRequestBanned)) {
+		retryTime := tracker.GetRetryTime(err, config)
+		if !retryTime.IsZero() {
+			cache.put(&nearCacheEntry{
+				key:       key,
+				tracker:   *tracker,
+				err:       err,
+				retryTime: retryTime.UnixMilli(),
+			})
+		}
+	}
+	return tracker, err
+}
+
+// GetTracker returns tracker from wrapped store
+func (cache *NearCacheTrackerStore) GetTracker(ctx context.Context, userId string, url string) (*RequestTracker, error) {
+	admin, isAdmin := cache.Store.(TrackerAdmin)
+	if !isAdmin {
+		return nil, ErrorAdminNotSupported
+	}
+	return admin.GetTracker(ctx, userId, url)
+}
+
+// GetUserTrackers returns trackers from wrapped store
+func (cache *NearCacheTrackerStore) GetUserTrackers(ctx context.Context, userId string) ([]*RequestTracker, error) {
+	admin, isAdmin := cache.Store.(TrackerAdmin)
+	if !isAdmin {
+		return nil, ErrorAdminNotSupported
+	}
+	return admin.GetUserTrackers(ctx, userId)
+}
+
+// ResetTracker resets tracker in wrapped store and forgets its rejection
+func (cache *NearCacheTrackerStore) ResetTracker(ctx context.Context, userId string, url string) error {
+	admin, isAdmin := cache.Store.(TrackerAdmin)
+	if !isAdmin {
+		return ErrorAdminNotSupported
+	}
+	cache.Remove(ctx, userId, url)
+	return admin.ResetTracker(ctx, userId, url)
+}
+
+// ResetUserTrackers resets trackers in wrapped store and forgets rejections of userId
+func (cache *NearCacheTrackerStore) ResetUserTrackers(ctx context.Context, userId string) (int, error) {
+	admin, isAdmin := cache.Store.(TrackerAdmin)
+	if !isAdmin {
+		return 0, ErrorAdminNotSupported
+	}
+	cache.mutex.Lock()
+	for key, element := range cache.entries {
+		if key.isUserOf(ctx, userId) {
+			cache.lru.Remove(element)
+			delete(cache.entries, key)
+		}
+	}
+	cache.mutex.Unlock()
+	return admin.ResetUserTrackers(ctx, userId)
+}
+
+// LiftBan lifts ban in wrapped store and forgets rejection of userId and url
+func (cache *NearCacheTrackerStore) LiftBan(ctx context.Context, userId string, url string) error {
+	banAdmin, isBanAdmin := cache.Store.(TrackerBanAdmin)
+	if !isBanAdmin {
+		return ErrorAdminNotSupported
+	}
+	cache.Remove(ctx, userId, url)
+	return banAdmin.LiftBan(ctx, userId, url)
+}
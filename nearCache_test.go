@@ -0,0 +1,91 @@
+package limitter
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zeroboo/gin-request-limitter/clocktest"
+)
+
+// mapTrackerStore keeps trackers in a map and counts calls
+type mapTrackerStore struct {
+	Trackers map[string]*RequestTracker
+	Calls    int
+}
+
+func (store *mapTrackerStore) UpdateTracker(ctx context.Context, userId string, url string, config *LimitterConfig,
+	validate func(tracker *RequestTracker) error) (*RequestTracker, error) {
+	store.Calls += 1
+	if store.Trackers == nil {
+		store.Trackers = map[string]*RequestTracker{}
+	}
+	key := CreateTrackerName(userId, url)
+	tracker, found := store.Trackers[key]
+	if !found {
+		tracker = NewRequestTracker(userId, url)
+		store.Trackers[key] = tracker
+	}
+	return tracker, validate(tracker)
+}
+
+// go test -timeout 30s -run ^TestNearCache_RejectedKey_RejectLocally$ github.com/zeroboo/gin-request-limitter -v
+func TestNearCache_RejectedKey_RejectLocally(t *testing.T) {
+	store := &mapTrackerStore{}
+	cache := NewNearCacheTrackerStore(store, 10)
+	limitter := CreateStoreBackedLimitter(cache, GetUserIdFromContextByField(FieldNameUserId), &limitterTestConfigLongWindow, false)
+	authHandler := CreateFakeAuthenticationHandler(FieldNameUserId, RandomString(16))
+
+	recorder := RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{}, authHandler, limitter, HandleHealth)
+	assert.Equal(t, http.StatusOK, recorder.Code, "First request success")
+
+	for i := 0; i < 3; i++ {
+		recorder = RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{}, authHandler, limitter, HandleHealth)
+		assert.Equal(t, http.StatusTooEarly, recorder.Code, "Request too fast")
+	}
+
+	assert.Equal(t, 2, store.Calls, "Only first rejection goes to store")
+	stats := cache.GetStats()
+	assert.Equal(t, int64(2), stats.Hits, "Rejected locally")
+	assert.Equal(t, int64(2), stats.Misses, "Sent to store")
+	assert.Equal(t, 0.5, stats.HitRatio(), "Hit ratio")
+}
+
+// go test -timeout 30s -run ^TestNearCache_RetryTimePassed_CallStore$ github.com/zeroboo/gin-request-limitter -v
+func TestNearCache_RetryTimePassed_CallStore(t *testing.T) {
+	store := &mapTrackerStore{}
+	cache := NewNearCacheTrackerStore(store, 10)
+	clock := clocktest.NewFakeClock(time.Now())
+	config := limitterTestConfigLongWindow
+	config.Clock = clock
+	limitter := CreateStoreBackedLimitter(cache, GetUserIdFromContextByField(FieldNameUserId), &config, false)
+	authHandler := CreateFakeAuthenticationHandler(FieldNameUserId, RandomString(16))
+
+	RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{}, authHandler, limitter, HandleHealth)
+	recorder := RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{}, authHandler, limitter, HandleHealth)
+	assert.Equal(t, http.StatusTooEarly, recorder.Code, "Request too fast")
+
+	clock.Advance(time.Duration(config.MinRequestInterval) * time.Millisecond)
+	recorder = RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{}, authHandler, limitter, HandleHealth)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Request after retry time success")
+	assert.Equal(t, 3, store.Calls, "Store called after retry time")
+}
+
+// go test -timeout 30s -run ^TestNearCache_Full_EvictLeastRecentlyUsed$ github.com/zeroboo/gin-request-limitter -v
+func TestNearCache_Full_EvictLeastRecentlyUsed(t *testing.T) {
+	cache := NewNearCacheTrackerStore(&mapTrackerStore{}, 2)
+	retryTime := time.Now().Add(time.Minute).UnixMilli()
+	ctx := context.Background()
+	a, b, c := createTrackerCacheKey(ctx, "a", "/"), createTrackerCacheKey(ctx, "b", "/"), createTrackerCacheKey(ctx, "c", "/")
+	cache.put(&nearCacheEntry{key: a, retryTime: retryTime, err: ErrorRequestTooFast})
+	cache.put(&nearCacheEntry{key: b, retryTime: retryTime, err: ErrorRequestTooFast})
+	cache.get(a, time.Now())
+	cache.put(&nearCacheEntry{key: c, retryTime: retryTime, err: ErrorRequestTooFast})
+
+	assert.NotNil(t, cache.get(a, time.Now()), "Recently used key kept")
+	assert.Nil(t, cache.get(b, time.Now()), "Least recently used key evicted")
+	assert.Equal(t, 2, cache.GetStats().Size, "Cache bounded")
+	assert.Equal(t, int64(1), cache.GetStats().Evictions, "Eviction counted")
+}
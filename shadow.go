@@ -0,0 +1,135 @@
+/*
+Shadow mode and candidate policies: decisions are computed and recorded without being enforced
+*/
+
+package limitter
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const HeaderDecision string = "X-RateLimit-Decision"
+const HeaderCandidateDecision string = "X-RateLimit-Candidate-Decision"
+
+const DECISION_ALLOW string = "allow"
+const DECISION_REJECT string = "reject"
+
+// DecisionStats are counters of decisions of a limitter since its recorder was created
+type DecisionStats struct {
+	//Requests is number of requests decided by policy
+	Requests int64
+	//Rejected is number of requests rejected by policy, served anyway in shadow mode
+	Rejected int64
+	//CandidateRequests is number of requests also decided by candidate policy
+	CandidateRequests int64
+	//CandidateRejected is number of requests candidate policy would reject
+	CandidateRejected int64
+	//Disagreements is number of requests candidate policy decided differently from policy
+	Disagreements int64
+}
+
+// DisagreementRatio returns ratio of requests candidate policy decided differently, 0 if there is no request
+func (stats DecisionStats) DisagreementRatio() float64 {
+	if stats.CandidateRequests == 0 {
+		return 0
+	}
+	return float64(stats.Disagreements) / float64(stats.CandidateRequests)
+}
+
+// DecisionRecorder counts decisions of a policy and its candidate
+type DecisionRecorder struct {
+	mutex sync.Mutex
+	stats DecisionStats
+}
+
+func NewDecisionRecorder() *DecisionRecorder {
+	return &DecisionRecorder{}
+}
+
+// GetStats returns a snapshot of counters
+func (recorder *DecisionRecorder) GetStats() DecisionStats {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return recorder.stats
+}
+
+func (recorder *DecisionRecorder) record(rejected bool, candidateEvaluated bool, candidateRejected bool) {
+	if recorder == nil {
+		return
+	}
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.stats.Requests += 1
+	if rejected {
+		recorder.stats.Rejected += 1
+	}
+	if !candidateEvaluated {
+		return
+	}
+	recorder.stats.CandidateRequests += 1
+	if candidateRejected {
+		recorder.stats.CandidateRejected += 1
+	}
+	if candidateRejected != rejected {
+		recorder.stats.Disagreements += 1
+	}
+}
+
+func getDecision(rejected bool) string {
+	if rejected {
+		return DECISION_REJECT
+	}
+	return DECISION_ALLOW
+}
+
+/*
+checkCandidatePolicy returns decision of candidate policy of config on tracker before it is validated by config.
+Candidate windows are kept in tracker by config, see LimitterConfig.getTrackedRules
+*/
+func checkCandidatePolicy(tracker *RequestTracker, currentTime time.Time, config *LimitterConfig) error {
+	if tracker.IsBanned(currentTime) {
+		return ErrorRequestBanned
+	}
+	if errLimit := checkRequestLimits(tracker, currentTime, config.Candidate, config.WindowSize); errLimit != nil {
+		return errLimit
+	}
+	return nil
+}
+
+/*
+recordDecision records decision of a request in recorder of config, logs and decision headers.
+In shadow mode, rejection is returned as nil so request proceeds
+*/
+func recordDecision(header http.Header, config *LimitterConfig, userId string, url string, clientIP string,
+	errValidate error, candidateEvaluated bool, errCandidate error) error {
+	rejected := IsValidateError(errValidate)
+	candidateRejected := errCandidate != nil
+	config.Decisions.record(rejected, candidateEvaluated, candidateRejected)
+
+	if candidateEvaluated {
+		header.Set(HeaderCandidateDecision, getDecision(candidateRejected))
+		if candidateRejected != rejected {
+			log.Infof("RequestLimitter: CandidateDisagreed, userId=%v, url=%v, decision=%v, candidateDecision=%v, error=%v, candidateError=%v",
+				userId, url, getDecision(rejected), getDecision(candidateRejected), errValidate, errCandidate)
+		}
+	}
+
+	if !config.Shadow {
+		return errValidate
+	}
+	header.Set(HeaderDecision, getDecision(rejected))
+	if rejected {
+		var errLimit *RuleLimitError
+		if errors.As(errValidate, &errLimit) {
+			header.Set(HeaderLimitRule, errLimit.Rule)
+		}
+		log.Infof("RequestLimitter: ShadowRejected, userId=%v, url=%v, IP=%v, error=%v", userId, url, clientIP, errValidate)
+		return nil
+	}
+	return errValidate
+}
@@ -0,0 +1,91 @@
+package limitter
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestShadowMode_RejectedRequests_Served$ github.com/zeroboo/gin-request-limitter -v
+func TestShadowMode_RejectedRequests_Served(t *testing.T) {
+	config := limitterTestConfigLongWindow
+	config.MinRequestInterval = 0
+	config.Shadow = true
+	config.Decisions = NewDecisionRecorder()
+	handler := CreateStoreBackedLimitter(&mapTrackerStore{}, GetUserIdFromContextByField(FieldNameUserId), &config, true)
+	authHandler := CreateFakeAuthenticationHandler(FieldNameUserId, RandomString(16))
+
+	decisions := []string{}
+	for i := 0; i < 4; i++ {
+		recorder := RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{}, authHandler, handler, HandleHealth)
+		assert.Equal(t, http.StatusOK, recorder.Code, "Request served in shadow mode")
+		decisions = append(decisions, recorder.Header().Get(HeaderDecision))
+	}
+
+	assert.Equal(t, []string{DECISION_ALLOW, DECISION_ALLOW, DECISION_REJECT, DECISION_REJECT}, decisions, "Decisions in headers")
+	assert.Equal(t, DecisionStats{Requests: 4, Rejected: 2}, config.Decisions.GetStats(), "Decisions recorded")
+}
+
+// go test -timeout 30s -run ^TestCandidatePolicy_StricterCandidate_DisagreementsCounted$ github.com/zeroboo/gin-request-limitter -v
+func TestCandidatePolicy_StricterCandidate_DisagreementsCounted(t *testing.T) {
+	config := limitterTestConfigLongWindow
+	config.MinRequestInterval = 0
+	config.MaxRequestPerWindow = 3
+	config.Decisions = NewDecisionRecorder()
+	config.Candidate = &LimitterConfig{
+		Rules: []LimitRule{{Name: "minute", WindowSize: 60000, MaxRequestPerWindow: 1}},
+	}
+	handler := CreateStoreBackedLimitter(&mapTrackerStore{}, GetUserIdFromContextByField(FieldNameUserId), &config, false)
+	authHandler := CreateFakeAuthenticationHandler(FieldNameUserId, RandomString(16))
+
+	codes := []int{}
+	candidateDecisions := []string{}
+	for i := 0; i < 4; i++ {
+		recorder := RecordRequest(http.MethodGet, "/health", map[string][]string{}, map[string][]string{}, authHandler, handler, HandleHealth)
+		codes = append(codes, recorder.Code)
+		candidateDecisions = append(candidateDecisions, recorder.Header().Get(HeaderCandidateDecision))
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes, "Enforced policy decides")
+	assert.Equal(t, []string{DECISION_ALLOW, DECISION_REJECT, DECISION_REJECT, DECISION_REJECT}, candidateDecisions, "Candidate decisions in headers")
+	stats := config.Decisions.GetStats()
+	assert.Equal(t, DecisionStats{Requests: 4, Rejected: 1, CandidateRequests: 4, CandidateRejected: 3, Disagreements: 2}, stats, "Disagreements counted")
+	assert.Equal(t, 0.5, stats.DisagreementRatio(), "Disagreement ratio")
+}
+
+// go test -timeout 30s -run ^TestShadowMode_Violations_NotBanned$ github.com/zeroboo/gin-request-limitter -v
+func TestShadowMode_Violations_NotBanned(t *testing.T) {
+	config := limitterTestConfigBan
+	config.Shadow = true
+	store := &mapTrackerStore{}
+	limitter := NewLimitter(store, &config)
+
+	for i := 0; i < 5; i++ {
+		decision := limitter.Decide(context.Background(), "user", "/health", "", http.Header{})
+		assert.Nil(t, decision.Err, "Request served in shadow mode")
+	}
+
+	tracker := store.Trackers[CreateTrackerName("user", "/health")]
+	assert.Equal(t, int64(0), tracker.Violations, "Violations not saved")
+	assert.Equal(t, int64(0), tracker.BanUntil, "Ban not saved")
+}
+
+// go test -timeout 30s -run ^TestShadowMode_DeniedRequests_Served$ github.com/zeroboo/gin-request-limitter -v
+func TestShadowMode_DeniedRequests_Served(t *testing.T) {
+	accessList, errCreate := NewAccessList(AccessRules{DenyUserIds: []string{"user"}})
+	assert.Nil(t, errCreate, "Access list created")
+	config := limitterTestConfigLongWindow
+	config.Shadow = true
+	config.AccessList = accessList
+	config.Decisions = NewDecisionRecorder()
+	store := &mapTrackerStore{}
+
+	decision := NewLimitter(store, &config).Decide(context.Background(), "user", "/health", "", http.Header{})
+
+	assert.Nil(t, decision.Err, "Denied request served in shadow mode")
+	assert.Equal(t, DECISION_REJECT, decision.Header.Get(HeaderDecision), "Denial in header")
+	assert.Equal(t, DecisionStats{Requests: 1, Rejected: 1}, config.Decisions.GetStats(), "Denial recorded")
+	assert.Equal(t, 0, store.Calls, "Store not called")
+}
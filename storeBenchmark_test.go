@@ -0,0 +1,90 @@
+package limitter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	limitter "github.com/zeroboo/gin-request-limitter"
+	"github.com/zeroboo/gin-request-limitter/limittersim"
+	"github.com/zeroboo/gin-request-limitter/limittertest"
+)
+
+var benchmarkConfig limitter.LimitterConfig = limitter.LimitterConfig{
+	MinRequestInterval:  0,
+	WindowSize:          60000,
+	MaxRequestPerWindow: 100,
+	ExpSec:              600,
+}
+
+// benchmarkBackend creates a store measured by BenchmarkStoreBackedLimitter, skips benchmark if backend is not available
+type benchmarkBackend struct {
+	name   string
+	create func(b *testing.B) limitter.TrackerStore
+}
+
+var benchmarkBackends []benchmarkBackend = []benchmarkBackend{
+	{"memory", func(b *testing.B) limitter.TrackerStore { return limittertest.NewMemoryStore() }},
+	{"redis", func(b *testing.B) limitter.TrackerStore { return limitter.NewRedisTrackerStore(nil) }},
+	{"datastore", func(b *testing.B) limitter.TrackerStore {
+		return limitter.NewDatastoreTrackerStore(limitter.RequireTestDatastore(b), limitter.TestDatastoreKind)
+	}},
+	{"datastoreBatch", func(b *testing.B) limitter.TrackerStore {
+		store := limitter.NewDatastoreBatchTrackerStore(limitter.RequireTestDatastore(b), limitter.TestDatastoreKind, 0, 0)
+		b.Cleanup(func() { store.Close(context.Background()) })
+		return store
+	}},
+	{"nearCache", func(b *testing.B) limitter.TrackerStore {
+		return limitter.NewNearCacheTrackerStore(limitter.NewRedisTrackerStore(nil), 100)
+	}},
+	{"leasing", func(b *testing.B) limitter.TrackerStore {
+		store := limitter.NewLeasingTrackerStore(limitter.NewRedisTrackerStore(nil), 0, 0, 0)
+		b.Cleanup(func() { store.Close(context.Background()) })
+		return store
+	}},
+	{"circuitBreaker", func(b *testing.B) limitter.TrackerStore {
+		return limitter.NewCircuitBreakerTrackerStore(limitter.NewRedisTrackerStore(nil), limitter.DefaultCircuitBreakerConfig)
+	}},
+}
+
+// benchmarkUsers is number of users requests of benchmarkStore are spread over
+const benchmarkUsers int = 1000
+
+/*
+benchmarkStore measures overhead per request of a limitter of config on store, see Limitter.Decide.
+Requests of benchmarkUsers users are sent 1ms apart in virtual time, rejected requests are reported by metric rejected/op
+*/
+func benchmarkStore(b *testing.B, store limitter.TrackerStore, config *limitter.LimitterConfig) {
+	sim := limittersim.NewSimulation(store, config, time.Now())
+	start := sim.Clock.Now()
+	users := make([]string, benchmarkUsers)
+	for i := range users {
+		users[i] = limittersim.GetUserId(i)
+	}
+
+	rejected := 0
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		decision := sim.Decide(limittersim.Request{
+			Time:   start.Add(time.Duration(i) * time.Millisecond),
+			UserId: users[i%benchmarkUsers],
+			URL:    limittersim.DefaultURL,
+		})
+		if decision.Rejected {
+			rejected += 1
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(rejected)/float64(b.N), "rejected/op")
+}
+
+// go test -run ^$ -bench ^BenchmarkStoreBackedLimitter$ github.com/zeroboo/gin-request-limitter
+func BenchmarkStoreBackedLimitter(b *testing.B) {
+	for _, backend := range benchmarkBackends {
+		backend := backend
+		b.Run(backend.name, func(b *testing.B) {
+			benchmarkStore(b, backend.create(b), &benchmarkConfig)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package limitter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	limitter "github.com/zeroboo/gin-request-limitter"
+	"github.com/zeroboo/gin-request-limitter/limittertest"
+)
+
+// policyStore changes a copy of policies of requests by adapt before passing them to store, so store takes another path
+type policyStore struct {
+	limitter.TrackerStore
+	adapt func(config *limitter.LimitterConfig)
+}
+
+func (store policyStore) UpdateTracker(ctx context.Context, userId string, url string, config *limitter.LimitterConfig,
+	validate func(tracker *limitter.RequestTracker) error) (*limitter.RequestTracker, error) {
+	adapted := *config
+	store.adapt(&adapted)
+	return store.TrackerStore.UpdateTracker(ctx, userId, url, &adapted, validate)
+}
+
+// addLevelLimit adds a global limit no request reaches, so redis store takes its hierarchical path
+func addLevelLimit(config *limitter.LimitterConfig) {
+	config.GlobalLimit = limitter.LevelLimit{WindowSize: 60000, MaxRequestPerWindow: 1 << 30}
+}
+
+// addShards spreads trackers over shards, so datastore store takes its sharded path
+func addShards(config *limitter.LimitterConfig) {
+	config.ShardCount = 4
+}
+
+// namespacedStore puts requests without a namespace in its namespace
+type namespacedStore struct {
+	limitter.TrackerStore
+	namespace string
+}
+
+func (store namespacedStore) UpdateTracker(ctx context.Context, userId string, url string, config *limitter.LimitterConfig,
+	validate func(tracker *limitter.RequestTracker) error) (*limitter.RequestTracker, error) {
+	if _, found := limitter.GetTrackerNamespace(ctx); !found {
+		ctx = limitter.WithTrackerNamespace(ctx, store.namespace)
+	}
+	return store.TrackerStore.UpdateTracker(ctx, userId, url, config, validate)
+}
+
+// expireNothing is Expire hook of stores expiring trackers by clock of config
+func expireNothing(duration time.Duration) {}
+
+var storeBackends map[string]limittertest.StoreFactory = map[string]limittertest.StoreFactory{
+	"redis": func(t *testing.T) limittertest.ConformanceBackend {
+		return limittertest.ConformanceBackend{Store: limitter.NewRedisTrackerStore(nil), Expire: limitter.ExpireTestRedis,
+			SharedNamespaces: true}
+	},
+	"redisHierarchy": func(t *testing.T) limittertest.ConformanceBackend {
+		return limittertest.ConformanceBackend{Store: policyStore{limitter.NewRedisTrackerStore(nil), addLevelLimit}, Expire: limitter.ExpireTestRedis,
+			SharedNamespaces: true}
+	},
+	"datastore": func(t *testing.T) limittertest.ConformanceBackend {
+		store := limitter.NewDatastoreTrackerStore(limitter.RequireTestDatastore(t), limitter.TestDatastoreKind)
+		return limittertest.ConformanceBackend{Store: store, Expire: expireNothing}
+	},
+	"datastoreSharded": func(t *testing.T) limittertest.ConformanceBackend {
+		store := limitter.NewDatastoreTrackerStore(limitter.RequireTestDatastore(t), limitter.TestDatastoreKind)
+		return limittertest.ConformanceBackend{Store: policyStore{store, addShards}, Expire: expireNothing}
+	},
+	"datastoreNamespaced": func(t *testing.T) limittertest.ConformanceBackend {
+		store := limitter.NewDatastoreTrackerStore(limitter.RequireTestDatastore(t), limitter.TestDatastoreKind)
+		store.Namespace = "conformance"
+		store.GroupByUser = true
+		store.KeyCodec = limitter.EscapeTrackerKeyCodec{}
+		return limittertest.ConformanceBackend{Store: store, Expire: expireNothing}
+	},
+	"datastoreBatch": func(t *testing.T) limittertest.ConformanceBackend {
+		store := limitter.NewDatastoreBatchTrackerStore(limitter.RequireTestDatastore(t), limitter.TestDatastoreKind, 0, 0)
+		t.Cleanup(func() { store.Close(context.Background()) })
+		return limittertest.ConformanceBackend{Store: store, Expire: expireNothing}
+	},
+	"datastoreBatchNamespaced": func(t *testing.T) limittertest.ConformanceBackend {
+		store := limitter.NewDatastoreBatchTrackerStore(limitter.RequireTestDatastore(t), limitter.TestDatastoreKind, 0, 0)
+		store.GroupByUser = true
+		t.Cleanup(func() { store.Close(context.Background()) })
+		return limittertest.ConformanceBackend{Store: namespacedStore{store, "conformance"}, Expire: expireNothing}
+	},
+	"nearCache": func(t *testing.T) limittertest.ConformanceBackend {
+		store := limitter.NewNearCacheTrackerStore(limittertest.NewMemoryStore(), 100)
+		return limittertest.ConformanceBackend{Store: store, Expire: expireNothing}
+	},
+	"leasing": func(t *testing.T) limittertest.ConformanceBackend {
+		store := limitter.NewLeasingTrackerStore(limitter.NewRedisTrackerStore(nil), 0, 0, 0)
+		t.Cleanup(func() { store.Close(context.Background()) })
+		return limittertest.ConformanceBackend{Store: store, Expire: limitter.ExpireTestRedis, SharedNamespaces: true}
+	},
+	"leasingMemory": func(t *testing.T) limittertest.ConformanceBackend {
+		store := limitter.NewLeasingTrackerStore(limittertest.NewMemoryStore(), 0, 0, 0)
+		t.Cleanup(func() { store.Close(context.Background()) })
+		return limittertest.ConformanceBackend{Store: store, Expire: expireNothing}
+	},
+	"leasingNamespaced": func(t *testing.T) limittertest.ConformanceBackend {
+		store := limitter.NewLeasingTrackerStore(limittertest.NewMemoryStore(), 0, 0, 0)
+		t.Cleanup(func() { store.Close(context.Background()) })
+		return limittertest.ConformanceBackend{Store: namespacedStore{store, "conformance"}, Expire: expireNothing}
+	},
+	"circuitBreaker": func(t *testing.T) limittertest.ConformanceBackend {
+		store := limitter.NewCircuitBreakerTrackerStore(limitter.NewRedisTrackerStore(nil), limitter.DefaultCircuitBreakerConfig)
+		return limittertest.ConformanceBackend{Store: store, Expire: limitter.ExpireTestRedis, SharedNamespaces: true}
+	},
+}
+
+// go test -timeout 60s -run ^TestStoreConformance$ github.com/zeroboo/gin-request-limitter -v
+func TestStoreConformance(t *testing.T) {
+	for name, factory := range storeBackends {
+		t.Run(name, func(t *testing.T) {
+			limittertest.RunStoreConformance(t, factory)
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package limitter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/alicebob/miniredis/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// Test backends: REDIS_SERVER_ADDRESS selects a real redis, an in-process miniredis is started otherwise.
+// DATASTORE_EMULATOR_HOST selects a running datastore emulator, one is started if gcloud is installed,
+// datastore tests are skipped otherwise, or fail if REQUIRE_DATASTORE is set so CI can not pass without them
+const DefaultTestDatastoreProjectId string = "limitter-test"
+const DatastoreEmulatorStartTimeout time.Duration = 60 * time.Second
+
+// testMiniRedis is the in-process redis of tests, nil if tests use a real redis
+var testMiniRedis *miniredis.Miniredis
+
+// startTestRedis returns address of redis of tests, starting a miniredis if no server is configured
+func startTestRedis() (string, error) {
+	if address := os.Getenv("REDIS_SERVER_ADDRESS"); len(address) > 0 {
+		return address, nil
+	}
+	server, errRun := miniredis.Run()
+	if errRun != nil {
+		return "", errRun
+	}
+	testMiniRedis = server
+	return server.Addr(), nil
+}
+
+// stopTestRedis stops miniredis if started
+func stopTestRedis() {
+	if testMiniRedis != nil {
+		testMiniRedis.Close()
+	}
+}
+
+// getFreeAddress returns a local address nobody listens on
+func getFreeAddress() (string, error) {
+	listener, errListen := net.Listen("tcp", "127.0.0.1:0")
+	if errListen != nil {
+		return "", errListen
+	}
+	defer listener.Close()
+	return listener.Addr().String(), nil
+}
+
+// waitDatastoreEmulator waits until emulator at address answers or timeout
+func waitDatastoreEmulator(address string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		response, errGet := http.Get("http://" + address)
+		if errGet == nil {
+			response.Body.Close()
+			if response.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return fmt.Errorf("datastore emulator at %v is not ready after %v", address, timeout)
+}
+
+// startDatastoreEmulator starts an emulator by gcloud if none is configured and gcloud is installed.
+// It returns function stopping started emulator
+func startDatastoreEmulator() (func(), error) {
+	stop := func() {}
+	if len(os.Getenv("DATASTORE_EMULATOR_HOST")) > 0 {
+		return stop, nil
+	}
+	gcloud, errLookup := exec.LookPath("gcloud")
+	if errLookup != nil {
+		return stop, nil
+	}
+	address, errAddress := getFreeAddress()
+	if errAddress != nil {
+		return stop, errAddress
+	}
+	cmd := exec.Command(gcloud, "beta", "emulators", "datastore", "start", "--no-store-on-disk", "--consistency=1.0",
+		"--host-port="+address, "--project="+DefaultTestDatastoreProjectId)
+	if errStart := cmd.Start(); errStart != nil {
+		return stop, errStart
+	}
+	stop = func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+	if errWait := waitDatastoreEmulator(address, DatastoreEmulatorStartTimeout); errWait != nil {
+		stop()
+		return func() {}, errWait
+	}
+	os.Setenv("DATASTORE_EMULATOR_HOST", address)
+	os.Setenv("DATASTORE_PROJECT_ID", DefaultTestDatastoreProjectId)
+	return stop, nil
+}
+
+// createTestDatastoreClient returns a client of datastore emulator, nil if there is no emulator
+func createTestDatastoreClient() (*datastore.Client, error) {
+	if len(os.Getenv("DATASTORE_EMULATOR_HOST")) == 0 {
+		return nil, nil
+	}
+	projectId := os.Getenv("DATASTORE_PROJECT_ID")
+	if len(projectId) == 0 {
+		projectId = DefaultTestDatastoreProjectId
+	}
+	return datastore.NewClient(context.Background(), projectId)
+}
+
+// requireDatastore returns datastore client of tests, skips test if there is no datastore emulator, fails it if REQUIRE_DATASTORE is set
+func requireDatastore(t testing.TB) *datastore.Client {
+	if dsClient == nil {
+		if len(os.Getenv("REQUIRE_DATASTORE")) > 0 {
+			t.Fatal("Datastore emulator is not available but REQUIRE_DATASTORE is set")
+		}
+		t.Skip("Datastore emulator is not available, set DATASTORE_EMULATOR_HOST or install gcloud")
+	}
+	return dsClient
+}
+
+// setupTestBackends starts backends of tests and returns function stopping them
+func setupTestBackends() (func(), error) {
+	redisAddress, errRedis := startTestRedis()
+	if errRedis != nil {
+		return func() {}, errRedis
+	}
+	InitRedis("test", "dev", redisAddress, "", 0)
+
+	stopEmulator, errEmulator := startDatastoreEmulator()
+	if errEmulator != nil {
+		log.Warnf("TestMain: DatastoreEmulatorNotStarted, error=%v", errEmulator)
+	}
+	var errDatastore error
+	dsClient, errDatastore = createTestDatastoreClient()
+	log.Printf("TestMain: Backends, redis=%v, miniredis=%v, datastoreEmulator=%v, errDatastore=%v",
+		redisAddress, testMiniRedis != nil, os.Getenv("DATASTORE_EMULATOR_HOST"), errDatastore)
+	if errDatastore != nil {
+		dsClient = nil
+	}
+	return func() {
+		if dsClient != nil {
+			dsClient.Close()
+		}
+		stopEmulator()
+		stopTestRedis()
+	}, nil
+}
@@ -1,25 +1,38 @@
 package limitter
 
 import (
+	"errors"
 	"fmt"
 	"time"
 )
 
 // ----------------------------------------------------------------------------------------------------
 type RequestTracker struct {
-	UID string `redis:"uid" datastore:"uid"`
-	URL string `redis:"url" datastore:"url"`
+	UID string `redis:"uid" datastore:"uid" json:"uid"`
+	URL string `redis:"url" datastore:"url" json:"url"`
 
 	//WindowNum is index of current window
-	WindowNum int64 `redis:"winNum" datastore:"winNum"`
+	WindowNum int64 `redis:"winNum" datastore:"winNum" json:"winNum"`
 
 	//WindowRequest is calls of request in current window
-	WindowRequest int64 `redis:"winReq" datastore:"winReq"`
+	WindowRequest int64 `redis:"winReq" datastore:"winReq" json:"winReq"`
 	//Last time request in millisec
-	LastCall int64 `redis:"last" datastore:"last"`
+	LastCall int64 `redis:"last" datastore:"last" json:"last"`
 
 	//Exp is expiration of this tracker as unix millisecond
-	Exp int64 `redis:"exp" datastore:"exp"`
+	Exp int64 `redis:"exp" datastore:"exp" json:"exp"`
+
+	//Violations is number of rejected requests since ViolationStart
+	Violations int64 `redis:"vio" datastore:"vio,noindex" json:"vio,omitempty"`
+	//ViolationStart is time of first violation counted, unix millisecond
+	ViolationStart int64 `redis:"vioStart" datastore:"vioStart,noindex" json:"vioStart,omitempty"`
+	//BanLevel is number of bans in a row, it selects duration of next ban
+	BanLevel int64 `redis:"banLvl" datastore:"banLvl,noindex" json:"banLvl,omitempty"`
+	//BanUntil is end of current ban as unix millisecond, requests are rejected until then
+	BanUntil int64 `redis:"banUntil" datastore:"banUntil,noindex" json:"banUntil,omitempty"`
+
+	//RuleWindows are windows of LimitterConfig.Rules encoded as size:index:requests separated by comma
+	RuleWindows string `redis:"rules" datastore:"rules,noindex" json:"rules,omitempty"`
 }
 
 const DefaultRequestTrackingWindowMilis int64 = 60000
@@ -58,10 +71,20 @@ func (tracker *RequestTracker) UpdateWindow(currentTime time.Time, windowMilis i
 }
 
 func (tracker *RequestTracker) String() string {
+	return fmt.Sprintf("UID:%v|URL:%v|LastCall:%v|Window:%v:%v",
+		tracker.UID,
+		tracker.URL,
+		tracker.LastCall,
+		tracker.WindowNum, tracker.WindowRequest,
+	)
+}
+
+// Format returns tracker as String does with interval since last call at currentTime, e.g. config.Now()
+func (tracker *RequestTracker) Format(currentTime time.Time) string {
 	return fmt.Sprintf("UID:%v|URL:%v|Interval:%v|LastCall:%v|Window:%v:%v",
 		tracker.UID,
 		tracker.URL,
-		time.Since(time.UnixMilli(tracker.LastCall)).Milliseconds(),
+		currentTime.UnixMilli()-tracker.LastCall,
 		tracker.LastCall,
 		tracker.WindowNum, tracker.WindowRequest,
 	)
@@ -73,10 +96,17 @@ func (tracker *RequestTracker) UpdateRequest(currentTime time.Time, config *Limi
 		tracker.WindowRequest += 1
 	}
 
+	tracker.updateRuleWindows(currentTime, config.getTrackedRules())
+
 	tracker.LastCall = currentTime.UnixMilli()
 	tracker.Exp = config.CreateExpiration(currentTime).UnixMilli()
 }
 
+// IsExpired returns true if tracker has an expiration and it passed, expired tracker is not used anymore
+func (tracker *RequestTracker) IsExpired(currentTime time.Time) bool {
+	return tracker.Exp > 0 && tracker.Exp <= currentTime.UnixMilli()
+}
+
 func (tracker *RequestTracker) IsRequestTooFast(currentTime time.Time, requestMinIntervalMilis int64) bool {
 	if tracker.LastCall == 0 {
 		return false
@@ -84,6 +114,25 @@ func (tracker *RequestTracker) IsRequestTooFast(currentTime time.Time, requestMi
 	return currentTime.UnixMilli()-tracker.LastCall < requestMinIntervalMilis
 }
 
+// GetRetryTime returns the earliest time a request rejected by errValidate can be valid again.
+// Zero time means request is not rejected
+func (tracker *RequestTracker) GetRetryTime(errValidate error, config *LimitterConfig) time.Time {
+	if errors.Is(errValidate, ErrorRequestBanned) {
+		return time.UnixMilli(tracker.BanUntil)
+	}
+	var errLimit *RuleLimitError
+	if errors.As(errValidate, &errLimit) {
+		return time.UnixMilli(errLimit.RetryTime)
+	}
+	if errors.Is(errValidate, ErrorRequestTooFast) {
+		return time.UnixMilli(tracker.LastCall + config.MinRequestInterval)
+	}
+	if errors.Is(errValidate, ErrorRequestTooFreequently) && config.WindowSize > 0 {
+		return time.UnixMilli((tracker.WindowNum + 1) * config.WindowSize)
+	}
+	return time.Time{}
+}
+
 func (tracker *RequestTracker) IsRequestTooFrequently(currentTime time.Time, maxRequestPerWindow int64) bool {
 	return tracker.WindowRequest > maxRequestPerWindow
 }
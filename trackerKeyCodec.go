@@ -0,0 +1,65 @@
+/*
+Codecs of tracker names from user id and url
+*/
+
+package limitter
+
+import (
+	"net/url"
+	"strings"
+)
+
+// TrackerKeyCodec encodes userId and url of a tracker to a name valid in datastore
+type TrackerKeyCodec interface {
+	EncodeTrackerName(userId string, url string) string
+
+	// DecodeTrackerName returns userId and url of a name, ok is false if name can not be decoded
+	DecodeTrackerName(name string) (userId string, url string, ok bool)
+}
+
+// HashTrackerKeyCodec hashes names by CreateTrackerName, names can not be decoded.
+// Trackers still can be found by their indexed uid and url properties
+type HashTrackerKeyCodec struct{}
+
+func (codec HashTrackerKeyCodec) EncodeTrackerName(userId string, url string) string {
+	return CreateTrackerName(userId, url)
+}
+
+func (codec HashTrackerKeyCodec) DecodeTrackerName(name string) (string, string, bool) {
+	return "", "", false
+}
+
+// EscapeTrackerKeyCodec escapes userId and url and joins them by '|', names can be decoded.
+// Underscores are escaped too so a name never matches reserved pattern __.*__ of datastore.
+// Escaped names longer than DatastoreMaxKeyNameBytes are hashed as HashTrackerKeyCodec does, those names can not be decoded
+type EscapeTrackerKeyCodec struct{}
+
+// Max bytes of a key name in datastore
+const DatastoreMaxKeyNameBytes int = 1500
+
+const escapeTrackerNameSeparator string = "|"
+
+func escapeTrackerNamePart(part string) string {
+	return strings.ReplaceAll(url.QueryEscape(part), "_", "%5F")
+}
+
+func (codec EscapeTrackerKeyCodec) EncodeTrackerName(userId string, url string) string {
+	name := escapeTrackerNamePart(userId) + escapeTrackerNameSeparator + escapeTrackerNamePart(url)
+	if len(name) > DatastoreMaxKeyNameBytes {
+		return CreateTrackerName(userId, url)
+	}
+	return name
+}
+
+func (codec EscapeTrackerKeyCodec) DecodeTrackerName(name string) (string, string, bool) {
+	parts := strings.Split(name, escapeTrackerNameSeparator)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	userId, errUserId := url.QueryUnescape(parts[0])
+	requestURL, errURL := url.QueryUnescape(parts[1])
+	if errUserId != nil || errURL != nil {
+		return "", "", false
+	}
+	return userId, requestURL, true
+}
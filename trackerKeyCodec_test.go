@@ -0,0 +1,73 @@
+package limitter
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -timeout 30s -run ^TestEscapeTrackerKeyCodec_EncodeDecode_SameValues$ github.com/zeroboo/gin-request-limitter -v
+func TestEscapeTrackerKeyCodec_EncodeDecode_SameValues(t *testing.T) {
+	codec := EscapeTrackerKeyCodec{}
+	name := codec.EncodeTrackerName("__user|1__", "/api/v1/items?id=1|2")
+
+	assert.False(t, strings.HasPrefix(name, "__"), "Name is not reserved")
+	assert.Equal(t, 1, strings.Count(name, "|"), "Separator is unique")
+	userId, url, ok := codec.DecodeTrackerName(name)
+	assert.True(t, ok, "Name decoded")
+	assert.Equal(t, "__user|1__", userId, "Decoded userId")
+	assert.Equal(t, "/api/v1/items?id=1|2", url, "Decoded url")
+}
+
+// go test -timeout 30s -run ^TestEscapeTrackerKeyCodec_LongName_Hashed$ github.com/zeroboo/gin-request-limitter -v
+func TestEscapeTrackerKeyCodec_LongName_Hashed(t *testing.T) {
+	codec := EscapeTrackerKeyCodec{}
+	longURL := "/items?q=" + strings.Repeat("é", 500)
+	name := codec.EncodeTrackerName("user", longURL)
+
+	assert.LessOrEqual(t, len(name), DatastoreMaxKeyNameBytes, "Name fits datastore key")
+	assert.Equal(t, CreateTrackerName("user", longURL), name, "Long name hashed")
+	_, _, ok := codec.DecodeTrackerName(name)
+	assert.False(t, ok, "Hashed name not decoded")
+
+	limitURL := strings.Repeat("a", DatastoreMaxKeyNameBytes-len("user|"))
+	name = codec.EncodeTrackerName("user", limitURL)
+	assert.Equal(t, DatastoreMaxKeyNameBytes, len(name), "Name at limit escaped")
+}
+
+// go test -timeout 30s -run ^TestHashTrackerKeyCodec_Encode_SameAsTrackerName$ github.com/zeroboo/gin-request-limitter -v
+func TestHashTrackerKeyCodec_Encode_SameAsTrackerName(t *testing.T) {
+	codec := HashTrackerKeyCodec{}
+	assert.Equal(t, CreateTrackerName("user", "/health"), codec.EncodeTrackerName("user", "/health"), "Hash name")
+	_, _, ok := codec.DecodeTrackerName(codec.EncodeTrackerName("user", "/health"))
+	assert.False(t, ok, "Hash can not be decoded")
+}
+
+// go test -timeout 30s -run ^TestDatastoreStore_UserTrackers_ListedAndDeleted$ github.com/zeroboo/gin-request-limitter -v
+func TestDatastoreStore_UserTrackers_ListedAndDeleted(t *testing.T) {
+	client := requireDatastore(t)
+	ctx := context.Background()
+	config := &LimitterConfig{WindowSize: 60000, MaxRequestPerWindow: 5, ExpSec: 600}
+	store := &DatastoreTrackerStore{Client: client, Kind: "test_codec_" + RandomString(8), KeyCodec: EscapeTrackerKeyCodec{}}
+	userId := RandomString(16)
+	for _, url := range []string{"/health", "/items"} {
+		_, err := store.UpdateTracker(ctx, userId, url, config, func(tracker *RequestTracker) error {
+			return ValidateRequest(tracker, time.Now(), url, "", config)
+		})
+		assert.Nil(t, err, "Tracker saved")
+	}
+
+	keys, trackers, errList := store.ListUserTrackers(ctx, userId)
+	assert.Nil(t, errList, "List success")
+	assert.Equal(t, 2, len(trackers), "Trackers of user")
+	decodedUserId, _, ok := store.KeyCodec.DecodeTrackerName(keys[0].Name)
+	assert.True(t, ok, "Key decoded")
+	assert.Equal(t, userId, decodedUserId, "Key of user")
+
+	deleted, errDelete := store.DeleteUserTrackers(ctx, userId)
+	assert.Nil(t, errDelete, "Delete success")
+	assert.Equal(t, 2, deleted, "Trackers of user deleted")
+}
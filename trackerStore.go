@@ -0,0 +1,90 @@
+/*
+Backend-agnostic limitter that persists trackers through a TrackerStore
+*/
+
+package limitter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrackerStore loads and persists request trackers of a backend
+type TrackerStore interface {
+	// UpdateTracker loads tracker of userId and url, runs validate on it then persists the result.
+	// Error from validate is returned as is, any other error is a backend failure.
+	// Rejected request is not counted, only its penalty is persisted if changed, see RequestTracker.RecordViolation
+	UpdateTracker(ctx context.Context, userId string, url string, config *LimitterConfig,
+		validate func(tracker *RequestTracker) error) (*RequestTracker, error)
+}
+
+// IsValidateError returns true if err is a rejection of request, not a backend failure
+func IsValidateError(err error) bool {
+	return errors.Is(err, ErrorRequestTooFast) || errors.Is(err, ErrorRequestTooFreequently) || errors.Is(err, ErrorRequestBanned) ||
+		errors.Is(err, ErrorLevelLimitExceeded)
+}
+
+// createTimeoutContext returns a context with deadline of timeoutMilis, no deadline if timeoutMilis is 0
+func createTimeoutContext(ctx context.Context, timeoutMilis int64) (context.Context, context.CancelFunc) {
+	if timeoutMilis > 0 {
+		return context.WithTimeout(ctx, time.Duration(timeoutMilis)*time.Millisecond)
+	}
+	return context.WithCancel(ctx)
+}
+
+/*
+CreateStoreBackedLimitter returns a gin limitter that persists trackers in given store, see Limitter.Decide.
+
+Limitter aborts gin context with status of decision if request is rejected, headers of decision are set on response.
+A middleware calls next handlers of served requests
+*/
+func CreateStoreBackedLimitter(pStore TrackerStore,
+	pUserIdExtractor func(c *gin.Context) string,
+	pConfig *LimitterConfig,
+	pIsMiddleware bool) func(c *gin.Context) {
+	limitter := NewLimitter(pStore, pConfig)
+	return func(c *gin.Context) {
+		decision := limitter.Decide(c.Request.Context(), pUserIdExtractor(c), c.Request.URL.Path, c.ClientIP(), c.Request.Header)
+		copyHeader(c.Writer.Header(), decision.Header)
+		ProcessValidateResult(decision.Err, c, pIsMiddleware)
+	}
+}
+
+// trackerCacheKey identifies a tracker kept in memory by wrapper stores, trackers of a user in different namespaces are apart
+type trackerCacheKey struct {
+	namespace    string
+	hasNamespace bool
+	userId       string
+	url          string
+}
+
+// createTrackerCacheKey returns key of tracker of userId and url in namespace of context
+func createTrackerCacheKey(ctx context.Context, userId string, url string) trackerCacheKey {
+	namespace, hasNamespace := GetTrackerNamespace(ctx)
+	return trackerCacheKey{namespace: namespace, hasNamespace: hasNamespace, userId: userId, url: url}
+}
+
+// isUserOf returns true if key is of userId in namespace of context
+func (key trackerCacheKey) isUserOf(ctx context.Context, userId string) bool {
+	namespace, hasNamespace := GetTrackerNamespace(ctx)
+	return key.userId == userId && key.namespace == namespace && key.hasNamespace == hasNamespace
+}
+
+// withNamespace returns ctx with namespace of key, so a call outside of request reaches the same tracker
+func (key trackerCacheKey) withNamespace(ctx context.Context) context.Context {
+	if !key.hasNamespace {
+		return ctx
+	}
+	return WithTrackerNamespace(ctx, key.namespace)
+}
+
+// copyHeader sets values of source headers on destination
+func copyHeader(destination http.Header, source http.Header) {
+	for key, values := range source {
+		destination[key] = values
+	}
+}